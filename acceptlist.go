@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// acceptList holds duplicate groups that have been reviewed and should no
+// longer be reported. It maps each accepted group hash to the number of
+// files the group had when it was accepted, or 0 if the count is unknown.
+//
+// On disk the list is one hash per line, optionally followed by the member
+// count. Blank lines and anything after a '#' are ignored. When a hash is
+// listed more than once the last entry wins, so appending is always safe.
+type acceptList map[string]int
+
+func loadAcceptList(path string) (acceptList, error) {
+	accepted := make(acceptList)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return accepted, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		count := 0
+		if len(fields) > 1 {
+			count, err = strconv.Atoi(fields[1])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid file count %q", path, lineNum, fields[1])
+			}
+		}
+		accepted[fields[0]] = count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return accepted, nil
+}
+
+// filter removes accepted groups from dupes and returns the remaining groups
+// along with the number of groups suppressed. Accepted groups that have
+// gained members since they were accepted are kept and annotated.
+func (a acceptList) filter(dupes []dupe) ([]dupe, int) {
+	var reported []dupe
+	suppressed := 0
+	for _, d := range dupes {
+		count, ok := a[d.Hash]
+		if !ok {
+			reported = append(reported, d)
+			continue
+		}
+		if count > 0 && len(d.Files) > count {
+			addNote(&d, fmt.Sprintf("accepted group grew from %d to %d files", count, len(d.Files)))
+			reported = append(reported, d)
+			continue
+		}
+		suppressed++
+	}
+	return reported, suppressed
+}
+
+// appendAcceptList appends the hash and member count of each group in dupes
+// to the accept list at path, creating the file if necessary.
+func appendAcceptList(path string, dupes []dupe) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, d := range dupes {
+		fmt.Fprintf(w, "%s %d\n", d.Hash, len(d.Files))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
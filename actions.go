@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"gopkg.in/gookit/color.v1"
+)
+
+// confirm asks the user a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// cliAction describes an action selected on the command line.
+type cliAction struct {
+	action dupes.Action
+	// prompt is the confirmation question, formatted with the file count.
+	prompt string
+	// verb is the past tense of the action, noun what it failed to do, and
+	// dryVerb describes the action in --dry-run mode.
+	verb    string
+	noun    string
+	dryVerb string
+	// mark labels the files the action will apply to in --interactive.
+	mark string
+}
+
+// actionResults tallies the outcome of running an action.
+type actionResults struct {
+	count     int64
+	reclaimed int64
+	skipped   int64
+	failed    int64
+}
+
+// runAction applies a to the duplicates in found, printing each file acted
+// on. verb is the past tense of the action, e.g. "Deleted".
+func runAction(found []dupe, keep dupes.Keeper, a dupes.Action, verb string) actionResults {
+	groups := make([]dupes.DupeGroup, len(found))
+	for i, d := range found {
+		groups[i] = d.group
+	}
+
+	var results actionResults
+	dupes.Resolve(groups, keep, a, func(r dupes.ActionResult) {
+		results.report(a, verb, r)
+	})
+	return results
+}
+
+// noAction is an action that does nothing, for counting the files Resolve
+// would act on.
+type noAction struct{}
+
+func (noAction) Apply(keep dupes.File, dupe dupes.File) error { return nil }
+
+// actedOnCount returns the number of duplicates in found that an action
+// would be applied to, leaving out their links and the files keep spares.
+func actedOnCount(found []dupe, keep dupes.Keeper) int64 {
+	groups := make([]dupes.DupeGroup, len(found))
+	for i, d := range found {
+		groups[i] = d.group
+	}
+	var count int64
+	dupes.Resolve(groups, keep, noAction{}, func(r dupes.ActionResult) {
+		if !r.Link {
+			count++
+		}
+	})
+	return count
+}
+
+// skipProtected returns the groups of found that keep can act on, noting
+// each one skipped because every file of it is protected.
+func skipProtected(found []dupe, keep dupes.KeepRules) []dupe {
+	var kept []dupe
+	for _, d := range found {
+		if !keep.AllProtected(&d.group) {
+			kept = append(kept, d)
+			continue
+		}
+		if quiet < 2 {
+			color.Yellow.Printf(tr("Skipped the group of %s: every file is protected by --protect\n"), d.group.Files[0].Path)
+		}
+	}
+	return kept
+}
+
+// reclaimGroups returns the groups of found wasting the most space, largest
+// first, that are needed for their duplicates to hold at least target
+// bytes, with the number of duplicates in them and the space they waste.
+// Whole groups are chosen, so slightly more than target may be reclaimed.
+func reclaimGroups(found []dupe, target int64) ([]dupe, int64, int64) {
+	sorted := append([]dupe(nil), found...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].WastedBytes > sorted[j].WastedBytes
+	})
+	var count, wasted int64
+	for i, d := range sorted {
+		if wasted >= target {
+			return sorted[:i], count, wasted
+		}
+		count += int64(d.group.Duplicates())
+		wasted += d.WastedBytes
+	}
+	return sorted, count, wasted
+}
+
+// report prints and tallies the outcome of applying a to a single file,
+// recording it in the audit log if there is one.
+func (results *actionResults) report(a dupes.Action, verb string, r dupes.ActionResult) {
+	audit.record(a, r)
+	switch {
+	case errors.Is(r.Err, dupes.ErrCrossDevice):
+		results.skipped++
+		color.Yellow.Printf(tr("Warning: skipped %s, it is on a different filesystem than %s\n"), r.Dupe.Path, r.Keep.Path)
+	case errors.Is(r.Err, dupes.ErrInArchive):
+		results.skipped++
+		color.Yellow.Printf(tr("Warning: skipped %s, it cannot be linked to %s inside an archive\n"), r.Dupe.Path, r.Keep.Path)
+	case errors.Is(r.Err, dupes.ErrLinkUnsupported):
+		results.skipped++
+		color.Yellow.Printf(tr("Warning: skipped %s, its filesystem does not support hard links\n"), r.Dupe.Path)
+	case errors.Is(r.Err, dupes.ErrReflinkUnsupported):
+		results.skipped++
+		color.Yellow.Printf(tr("Warning: skipped %s, its filesystem cannot share data between files\n"), r.Dupe.Path)
+	case errors.Is(r.Err, dupes.ErrContentDiffers):
+		results.failed++
+		color.Red.Printf(tr("Error: %s no longer matches %s, it may have changed since the scan\n"), r.Dupe.Path, r.Keep.Path)
+	case errors.Is(r.Err, dupes.ErrSameFile):
+		results.skipped++
+		fmt.Printf(tr("Skipped %s, it is already the same file as %s\n"), r.Dupe.Path, r.Keep.Path)
+	case r.Err != nil:
+		results.failed++
+		color.Red.Printf(tr("Error: %v\n"), r.Err)
+	default:
+		results.count++
+		if !r.Link {
+			results.reclaimed += r.Dupe.Size
+		}
+		if quiet > 0 {
+			return
+		}
+		color.Yellow.Printf("%s %s", verb, r.Dupe.Path)
+		linkAction := a
+		if d, ok := a.(dupes.DryRun); ok {
+			linkAction = d.Action
+		} else if s, ok := a.(*scriptAction); ok {
+			linkAction = s.action
+		}
+		if s, ok := linkAction.(dupes.SymlinkAction); ok {
+			target, _ := s.Target(r.Keep, r.Dupe)
+			fmt.Printf(" -> %s\n", target)
+		} else if m, ok := linkAction.(dupes.MoveAction); ok {
+			dest, _ := m.Destination(r.Dupe)
+			fmt.Printf(" -> %s\n", dest)
+		} else {
+			fmt.Printf(tr(" (kept %s)\n"), r.Keep.Path)
+		}
+	}
+}
+
+// printActionSummary prints the totals of an action. verb is the past tense
+// of the action and noun describes what it failed to do to a file.
+func printActionSummary(results actionResults, verb string, noun string) {
+	color.Green.Printf(tr("%s %d files, reclaiming %s.\n"), verb, results.count, formatSize(results.reclaimed))
+	if results.skipped > 0 {
+		color.Yellow.Printf(tr("%d files were skipped.\n"), results.skipped)
+	}
+	if results.failed > 0 {
+		color.Red.Printf(tr("%d files could not be %s.\n"), results.failed, noun)
+	}
+}
+
+// watchDupe reports a new duplicate f found by --watch, and applies a to it
+// if set. The file kept is a reference file of the group if it has one, and
+// otherwise the first other file.
+func watchDupe(g dupes.DupeGroup, f dupes.File, a *cliAction) {
+	color.Red.Printf(tr("New duplicate: %s\n"), f.Path)
+	keep := -1
+	for i, other := range g.Files {
+		if other.Path == f.Path {
+			continue
+		}
+		fmt.Printf(tr("\tsame content as %s\n"), other.Path)
+		if keep < 0 || other.Reference && !g.Files[keep].Reference {
+			keep = i
+		}
+	}
+	if a == nil || f.Reference || keep < 0 {
+		return
+	}
+	var results actionResults
+	results.report(a.action, a.verb, dupes.ActionResult{Group: &g, Keep: g.Files[keep], Dupe: f, Err: a.action.Apply(g.Files[keep], f)})
+}
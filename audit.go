@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// audit is the log given with --audit-log, or nil.
+var audit *auditLog
+
+// auditLog appends a line of JSON to a file for every file an action is
+// applied to, so that cleanups can be traced afterwards. Each record is
+// written as soon as the action has run, so the log is complete up to the
+// last file acted on even if dupes is killed. The first write error is kept
+// in err and stops the log.
+type auditLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+	err  error
+}
+
+// auditRecord is a line of the audit log. Paths are absolute. Target is
+// where a moved file went, what a symbolic link points at, or the kept file
+// a hard link or reflink shares its data with. KeptModTime is the
+// modification time the kept file was scanned with, so that undo can tell
+// whether it has changed since.
+type auditRecord struct {
+	Time        string    `json:"time"`
+	Action      string    `json:"action"`
+	Source      string    `json:"source"`
+	Target      string    `json:"target,omitempty"`
+	Kept        string    `json:"kept"`
+	KeptModTime time.Time `json:"kept_mtime"`
+	Hash        string    `json:"hash"`
+	GroupID     string    `json:"group_id"`
+	Size        int64     `json:"size"`
+	Link        bool      `json:"link,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// openAuditLog opens the audit log at path, creating it if needed. Records
+// are always appended to what it already holds.
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f, path: path}, nil
+}
+
+// actionName returns the name a is recorded under, or "" if a does not
+// change any files, as with --dry-run and --script.
+func actionName(a dupes.Action) string {
+	switch a.(type) {
+	case dupes.DeleteAction:
+		return "delete"
+	case dupes.TrashAction:
+		return "trash"
+	case dupes.HardlinkAction:
+		return "hardlink"
+	case dupes.SymlinkAction:
+		return "symlink"
+	case dupes.ReflinkAction:
+		return "reflink"
+	case dupes.MoveAction:
+		return "move"
+	}
+	return ""
+}
+
+// record appends the outcome of applying a to a file to the log. Files
+// skipped because a could not apply to them are recorded with the error
+// too, since nothing tells them apart from failures afterwards.
+func (l *auditLog) record(a dupes.Action, r dupes.ActionResult) {
+	name := actionName(a)
+	if l == nil || name == "" {
+		return
+	}
+	rec := auditRecord{
+		Time:        time.Now().Format(time.RFC3339Nano),
+		Action:      name,
+		Source:      absPath(r.Dupe.Path),
+		Kept:        absPath(r.Keep.Path),
+		KeptModTime: r.Keep.ModTime,
+		Size:        r.Dupe.Size,
+		Link:        r.Link,
+	}
+	if r.Group != nil {
+		rec.Hash, rec.GroupID = r.Group.Hash, r.Group.ID
+	}
+	switch a := a.(type) {
+	case dupes.SymlinkAction:
+		rec.Target, _ = a.Target(r.Keep, r.Dupe)
+	case dupes.MoveAction:
+		if dest, err := a.Destination(r.Dupe); err == nil {
+			rec.Target = absPath(dest)
+		}
+	case dupes.HardlinkAction, dupes.ReflinkAction:
+		rec.Target = rec.Kept
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err == nil {
+		_, err = l.f.Write(append(line, '\n'))
+	}
+	if err != nil {
+		l.err = err
+		logger.Error("writing audit log", "path", l.path, "error", err)
+	}
+}
+
+// close closes the log, returning the first error writing it.
+func (l *auditLog) close() error {
+	if err := l.f.Close(); l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// absPath returns the absolute form of path, or path itself if it has none.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
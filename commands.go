@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"gopkg.in/gookit/color.v1"
+)
+
+// command is one of the subcommands dupes accepts as its first argument.
+// Each takes the options flags define, in the order they are listed.
+type command struct {
+	name    string
+	args    string
+	summary string
+	flags   []flagGroup
+}
+
+// rootFlags define the options dupes takes without a command, when it
+// scans and also applies any action given.
+var rootFlags = []flagGroup{outputFlags, findFlags, firstFlags, watchFlags, metricsFlags, notifyFlags, keepFlags, actionFlags, scannerFlags, commonFlags}
+
+var commands = []command{
+	{"scan", "[OPTIONS] <dupe_directory>...", "Finds duplicate files and reports them. Without a command, dupes scans and also applies any action given.", []flagGroup{outputFlags, findFlags, firstFlags, watchFlags, metricsFlags, notifyFlags, keepFlags, scannerFlags, commonFlags}},
+	{"clean", "[OPTIONS] <dupe_directory>...", "Finds duplicate files and acts on them; requires --delete, --trash, --hardlink, --symlink, --reflink, --move-to or --interactive.", []flagGroup{outputFlags, findFlags, watchFlags, metricsFlags, notifyFlags, keepFlags, actionFlags, scannerFlags, commonFlags}},
+	{"verify", "[OPTIONS] <dupe_directory>...", "Finds duplicate files and compares them byte for byte before reporting them, as with --verify.", []flagGroup{manifestFlags, outputFlags, findFlags, firstFlags, watchFlags, metricsFlags, notifyFlags, keepFlags, scannerFlags, commonFlags}},
+	{"manifest", "[OPTIONS] <directory>...", "Writes the hash of every file scanned to stdout in the format of sha256sum, for checking with sha256sum -c. --hash picks md5, sha1, sha256 (the default), blake3, xxhash or highway.", []flagGroup{scannerFlags, commonFlags}},
+	{"agent", "[OPTIONS] <directory>...", "Writes the path, size and hash of every file scanned to stdout as JSON lines, for the dupes that runs it over ssh to scan an agent://user@host/path root, so that only hashes cross the network.", []flagGroup{scannerFlags, commonFlags}},
+	{"snapshot", "[OPTIONS] -o <snapshot_file> <dupe_directory>...", "Scans as dupes scan does and also saves the files and duplicates found to a new SQLite database, as --sqlite does, for dupes diff.", []flagGroup{outputFlags, findFlags, notifyFlags, keepFlags, scannerFlags, commonFlags}},
+	{"bench", "[OPTIONS] <directory>...", "Reads a sample of the files, --bench-sample in all, and measures how fast xxhash, highway, sha256, blake3 and the default pair of them, and any --hash, hash it on this machine, recommending the fastest that rules out false duplicates.", []flagGroup{benchFlags, scannerFlags, commonFlags}},
+	{"diff", "[OPTIONS] <snapshot_file> <snapshot_file>", "Compares two snapshots, listing the duplicate groups that are new, resolved or changed in the second and how the reclaimable space changed.", []flagGroup{commonFlags}},
+	{"serve", "[OPTIONS] [<directory>...]", "Serves a REST API on --listen for starting scans, polling their progress and fetching their duplicate groups as JSON. If directories are given, only they and their subdirectories may be scanned.", []flagGroup{serveFlags, outputFlags, scannerFlags, commonFlags}},
+	{"daemon", "--schedule <cron> [OPTIONS] <dupe_directory>...", "Scans the directories each time the cron schedule comes round, until stopped, reusing a hash cache between scans. Each report is written to --output if given.", []flagGroup{daemonFlags, serveFlags, metricsFlags, outputFlags, notifyFlags, scannerFlags, commonFlags}},
+	{"undo", "[OPTIONS] <audit_log>", "Reverses the actions recorded by --audit-log, most recent first: moves files back, recreates deleted files from the copy kept and replaces links with copies. --dry-run shows what would be restored.", []flagGroup{undoFlags, commonFlags}},
+	{"cache", "stats|prune|clear <cache_file>", "Shows the hashes stored in a --cache file, removes those of files that changed, or deletes it.", nil},
+	{"completion", "bash|zsh|fish|powershell", "Writes a script that completes the commands, options and their values in the shell, e.g. source <(dupes completion bash).", nil},
+}
+
+// subcommand splits the command off args. Without one, dupes behaves as in
+// earlier versions, scanning and acting as the flags direct, which is
+// reported as the command "". A directory named like a command can be
+// scanned as ./scan, or after --.
+func subcommand(args []string) (string, []string) {
+	if len(args) > 0 {
+		for _, c := range commands {
+			if args[0] == c.name {
+				return c.name, args[1:]
+			}
+		}
+	}
+	return "", args
+}
+
+// findCommand returns the subcommand called name.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// commandFlags returns the flags of the command cmd, or of dupes without a
+// command if it is "", which store their values in o.
+func commandFlags(cmd string, o *options) *flagSet {
+	groups := rootFlags
+	if c, ok := findCommand(cmd); ok {
+		groups = c.flags
+	}
+	fs := newFlagSet(cmd)
+	for _, define := range groups {
+		define(fs, o)
+	}
+	return fs
+}
+
+// runCommand runs the command s was built for and returns the exit status.
+// Without a command, and for scan, clean, verify and snapshot, it scans,
+// reports and acts on the duplicates found, and then watches the
+// directories if --watch is given.
+func (s *scan) runCommand(ctx context.Context) int {
+	switch {
+	case s.cmd == "serve":
+		if s.listen == "" {
+			s.listen = DEFAULT_LISTEN
+		}
+		return runServe(s.server(ctx), s.listen)
+	case s.cmd == "daemon":
+		d := &daemon{s: s.server(ctx), sched: s.sched, dirs: s.dirs, format: s.outputFormat, output: s.outputFile}
+		return runDaemon(d, s.listen, s.metricsListen)
+	case s.cmd == "manifest":
+		return runManifest(ctx, &s.scanner, s.dirs, s.reportOut, s.progress, &s.errs)
+	case s.cmd == "agent":
+		return runAgent(ctx, &s.scanner, s.dirs, s.reportOut, &s.errs)
+	case s.cmd == "bench":
+		specs := benchHashes
+		if s.hashGiven && !containsString(specs, s.hashAlgorithm) {
+			specs = append(specs, s.hashAlgorithm)
+		}
+		return runBench(ctx, &s.scanner, s.dirs, specs, s.benchSample, s.progress, &s.errs)
+	case s.manifestFile != "":
+		return runVerifyManifest(ctx, &s.scanner, s.dirs, s.manifestFile, s.manifest, s.progress, &s.errs)
+	}
+
+	r, err := s.runScan(ctx)
+	if err != nil {
+		return exitStatus(s.cmd, err)
+	}
+	s.report(r)
+	if err := s.act(r); err != nil {
+		return exitStatus(s.cmd, err)
+	}
+	if s.watch && !r.Interrupted {
+		if err := s.watchDirs(ctx); err != nil {
+			return exitStatus(s.cmd, err)
+		}
+	}
+	return s.status
+}
+
+// printCommands lists the subcommands for printUsage.
+func printCommands() {
+	fmt.Println(tr("Commands:"))
+	for _, c := range commands {
+		fmt.Printf(tr("\tdupes %s %s\n"), c.name, c.args)
+		fmt.Println("\t\t" + c.summary)
+	}
+}
+
+// runCache runs the cache command and returns the exit status.
+func runCache(args []string) int {
+	if len(args) != 2 {
+		fmt.Println(tr("Error: dupes cache takes an operation and a cache file, e.g. dupes cache stats hashes.cache"))
+		printUsage("cache")
+		return EXIT_USAGE
+	}
+	op, path := args[0], args[1]
+	switch op {
+	case "stats", "prune":
+	case "clear":
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logError("clearing cache", err)
+			return EXIT_ERROR
+		}
+		fmt.Println(tr("Cleared"), path)
+		return EXIT_NO_DUPES
+	default:
+		fmt.Printf(tr("Error: Unknown cache operation %q; use stats, prune or clear\n"), op)
+		printUsage("cache")
+		return EXIT_USAGE
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		logError("opening cache", err)
+		return EXIT_ERROR
+	}
+	cache, err := dupes.OpenCache(path)
+	if err != nil {
+		logError("opening cache", err)
+		return EXIT_ERROR
+	}
+	if op == "prune" {
+		removed := cache.Prune()
+		if err := cache.Save(); err != nil {
+			logError("saving cache", err)
+			return EXIT_ERROR
+		}
+		fmt.Printf(tr("Removed %d stale hashes, %d remain\n"), removed, cache.Len())
+		return EXIT_NO_DUPES
+	}
+
+	fmt.Printf(tr("Hashes cached: %d\n"), cache.Len())
+	counts := cache.Algorithms()
+	algorithms := make([]string, 0, len(counts))
+	for a := range counts {
+		algorithms = append(algorithms, a)
+	}
+	sort.Strings(algorithms)
+	for _, a := range algorithms {
+		fmt.Printf("\t%s: %d\n", a, counts[a])
+	}
+	return EXIT_NO_DUPES
+}
+
+// runManifest runs the manifest command on dirs, writing a line per file to
+// out, and returns the exit status.
+func runManifest(ctx context.Context, scanner *dupes.Scanner, dirs []string, out io.Writer, progress *progressDisplay, errs *scanErrors) int {
+	w := bufio.NewWriter(out)
+	err := scanner.Manifest(ctx, func(path string, sum string) {
+		w.WriteString(manifestLine(sum, path))
+	}, dirs...)
+	flushErr := w.Flush()
+	if progress != nil {
+		progress.finish()
+	}
+	status := EXIT_NO_DUPES
+	if scanner.Cache != nil {
+		if err := scanner.Cache.Save(); err != nil {
+			logError("saving cache", err)
+			status = EXIT_ERROR
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		if quiet < 2 {
+			color.Yellow.Println(tr("Partial manifest: scan interrupted."))
+		}
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		logError("scanning", err)
+		return EXIT_ERROR
+	}
+	if flushErr != nil {
+		logError("writing manifest", flushErr)
+		return EXIT_ERROR
+	}
+	if errs.total > 0 {
+		if quiet == 0 {
+			errs.print()
+		}
+		if status < EXIT_FILE_ERRORS {
+			status = EXIT_FILE_ERRORS
+		}
+	}
+	return status
+}
+
+// runAgent runs the agent command, writing the records of the files under
+// dirs to out for the dupes reading them, and returns the exit status.
+// Files that cannot be read are sent as error records rather than listed.
+func runAgent(ctx context.Context, scanner *dupes.Scanner, dirs []string, out io.Writer, errs *scanErrors) int {
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	// Each record is flushed at once, so the files are compared as they
+	// are hashed.
+	write := func(r dupes.AgentRecord) {
+		enc.Encode(r)
+		w.Flush()
+	}
+	start, err := scanner.AgentStart()
+	if err != nil {
+		logError("starting agent", err)
+		return EXIT_ERROR
+	}
+	write(start)
+	onError := scanner.OnError
+	scanner.OnError = func(path string, op string, err error) {
+		write(dupes.AgentRecord{Type: dupes.AGENT_ERROR, Path: path, Op: op, Error: err.Error()})
+		onError(path, op, err)
+	}
+	var files int64
+	err = scanner.HashEvery(ctx, func(f dupes.File, sum string) {
+		write(dupes.AgentFile(f, sum))
+		files++
+	}, dirs...)
+	status := EXIT_NO_DUPES
+	if scanner.Cache != nil {
+		if err := scanner.Cache.Save(); err != nil {
+			logError("saving cache", err)
+			status = EXIT_ERROR
+		}
+	}
+	// Without the end record, the files sent are not compared.
+	if errors.Is(err, context.Canceled) {
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		logError("scanning", err)
+		return EXIT_ERROR
+	}
+	write(dupes.AgentRecord{Type: dupes.AGENT_END, Files: files})
+	if err := w.Flush(); err != nil {
+		logError("writing records", err)
+		return EXIT_ERROR
+	}
+	if errs.total > 0 && status < EXIT_FILE_ERRORS {
+		status = EXIT_FILE_ERRORS
+	}
+	return status
+}
+
+// benchHashes are the hashes dupes bench measures, besides any --hash.
+var benchHashes = []string{"xxhash", "highway", "sha256", "blake3", dupes.DEFAULT_HASH}
+
+// weakHashes are the algorithms that cannot rule out false duplicates on
+// their own: the 64 bits of xxHash make a collision among billions of
+// files plausible, and collisions of MD5 and SHA-1 can be made at will.
+var weakHashes = map[string]bool{"xxhash": true, "md5": true, "sha1": true}
+
+// safeHash reports whether the hash spec includes an algorithm that rules
+// out false duplicates.
+func safeHash(spec string) bool {
+	for _, name := range strings.Split(spec, "+") {
+		if !weakHashes[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// runBench runs the bench command on dirs, printing how fast each of specs
+// hashes a sample of their files and which to use, and returns the exit
+// status.
+func runBench(ctx context.Context, scanner *dupes.Scanner, dirs []string, specs []string, sampleBytes int64, progress *progressDisplay, errs *scanErrors) int {
+	report, err := scanner.Bench(ctx, specs, sampleBytes, dirs...)
+	if progress != nil {
+		progress.finish()
+	}
+	if errors.Is(err, context.Canceled) {
+		if quiet < 2 {
+			color.Yellow.Println(tr("Benchmark interrupted."))
+		}
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		logError("benchmarking", err)
+		return EXIT_ERROR
+	}
+
+	status := EXIT_NO_DUPES
+	if errs.total > 0 {
+		if quiet == 0 {
+			errs.print()
+		}
+		status = EXIT_FILE_ERRORS
+	}
+	if quiet >= 2 {
+		return status
+	}
+	if report.Files == 0 {
+		fmt.Println(tr("No files were found to sample."))
+		return status
+	}
+	fmt.Printf(tr("Sampled %s of %d files, read at %s/s.\n"), formatBytes(report.Bytes), report.Files, formatBytes(int64(report.ReadRate())))
+	best := -1
+	if quiet == 0 {
+		fmt.Println(tr("Hashing speed:"))
+	}
+	for i, r := range report.Results {
+		if quiet == 0 {
+			note := ""
+			if !safeHash(r.Hash) {
+				note = tr(" (cannot rule out false duplicates on its own)")
+			} else if r.Hash == dupes.DEFAULT_HASH {
+				note = tr(" (the default)")
+			}
+			fmt.Printf("\t%-16s %12s/s%s\n", r.Hash, formatBytes(int64(r.Rate())), note)
+		}
+		if safeHash(r.Hash) && (best < 0 || r.Rate() > report.Results[best].Rate()) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return status
+	}
+	b := report.Results[best]
+	if b.Hash == dupes.DEFAULT_HASH {
+		fmt.Printf(tr("Recommended: the default, --hash %s, the fastest hash here that rules out false duplicates.\n"), b.Hash)
+	} else {
+		fmt.Printf(tr("Recommended: --hash %s, the fastest hash here that rules out false duplicates.\n"), b.Hash)
+	}
+	if report.ReadRate() < b.Rate() {
+		fmt.Println(tr("The files were read more slowly than they were hashed, so scans of them are limited by the disks rather than the hash."))
+	}
+	return status
+}
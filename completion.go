@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Shells dupes completion writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// cacheOperations are the operations dupes cache takes.
+var cacheOperations = []string{"stats", "prune", "clear"}
+
+// completionFlag describes a flag for the completion scripts, taken from
+// its definition so that the scripts offer every flag dupes accepts.
+type completionFlag struct {
+	name  string
+	short string
+	// usage is the first line of the flag's description, and arg names its
+	// value, as in "size" for <size>.
+	usage      string
+	arg        string
+	takesValue bool
+	repeatable bool
+	// choices are the values the flag accepts, if its placeholder lists
+	// them, as in <absolute|relative>.
+	choices []string
+	// dirs and files are set if the flag's value is a directory, or the
+	// path of a file.
+	dirs  bool
+	files bool
+}
+
+// completionFlags returns the flags of every command, in the order
+// printUsage lists them.
+func completionFlags() []completionFlag {
+	var fl []completionFlag
+	flags := allFlags()
+	for _, name := range flags.order {
+		f := flags.Lookup(name)
+		c := completionFlag{
+			name:       name,
+			short:      flags.short[name],
+			usage:      strings.TrimRight(strings.SplitN(f.Usage, "\n", 2)[0], ";,. "),
+			takesValue: flags.takesValue(name),
+		}
+		switch f.Value.(type) {
+		case listValue, regexpListValue:
+			c.repeatable = true
+		}
+		arg := flags.arg[name]
+		c.arg = strings.SplitN(strings.TrimPrefix(arg, "<"), ">", 2)[0]
+		switch {
+		case arg == "<directory>":
+			c.dirs = true
+		case arg == "<path>":
+			c.files = true
+		case strings.HasPrefix(arg, "<") && strings.HasSuffix(arg, ">") && strings.Contains(arg, "|"):
+			c.choices = strings.Split(c.arg, "|")
+			c.arg = name
+		}
+		fl = append(fl, c)
+	}
+	return fl
+}
+
+// runCompletion runs the completion command, writing the script for the
+// shell args names to out, and returns the exit status.
+func runCompletion(args []string, out io.Writer) int {
+	if len(args) != 1 || !containsString(completionShells, args[0]) {
+		fmt.Println(tr("Error: dupes completion takes a shell: bash, zsh, fish or powershell"))
+		printUsage("completion")
+		return EXIT_USAGE
+	}
+	w := bufio.NewWriter(out)
+	fl := completionFlags()
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(w, fl)
+	case "zsh":
+		writeZshCompletion(w, fl)
+	case "fish":
+		writeFishCompletion(w, fl)
+	case "powershell":
+		writePowerShellCompletion(w, fl)
+	}
+	if err := w.Flush(); err != nil {
+		logError("writing completion script", err)
+		return EXIT_ERROR
+	}
+	return EXIT_NO_DUPES
+}
+
+// containsString reports whether list holds s.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// commandNames returns the names of the subcommands.
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
+
+// writeBashCompletion writes a bash script, to be sourced, that completes
+// the commands, flags and flag values of dupes, and paths otherwise.
+func writeBashCompletion(w io.Writer, fl []completionFlag) {
+	var words []string
+	fmt.Fprintln(w, "# bash completion for dupes, written by dupes completion bash.")
+	fmt.Fprintln(w, "# Load it with: source <(dupes completion bash)")
+	fmt.Fprintln(w, "_dupes() {")
+	fmt.Fprintln(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"")
+	// COMP_WORDBREAKS splits --keep=first into --keep, = and first.
+	fmt.Fprintln(w, "\tif [[ $cur == = ]]; then")
+	fmt.Fprintln(w, "\t\tcur=")
+	fmt.Fprintln(w, "\telif [[ $prev == = ]]; then")
+	fmt.Fprintln(w, "\t\tprev=\"${COMP_WORDS[COMP_CWORD-2]}\"")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase \"$prev\" in")
+	for _, f := range fl {
+		names := "--" + f.name
+		words = append(words, "--"+f.name)
+		if f.short != "" {
+			names += "|-" + f.short
+			words = append(words, "-"+f.short)
+		}
+		if !f.takesValue {
+			continue
+		}
+		switch {
+		case len(f.choices) > 0:
+			fmt.Fprintf(w, "\t%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn ;;\n", names, strings.Join(f.choices, " "))
+		case f.dirs:
+			fmt.Fprintf(w, "\t%s)\n\t\tcompopt -o filenames 2>/dev/null\n\t\tCOMPREPLY=($(compgen -d -- \"$cur\"))\n\t\treturn ;;\n", names)
+		case f.files:
+			fmt.Fprintf(w, "\t%s)\n\t\tcompopt -o filenames 2>/dev/null\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\t\treturn ;;\n", names)
+		default:
+			fmt.Fprintf(w, "\t%s)\n\t\tCOMPREPLY=()\n\t\treturn ;;\n", names)
+		}
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tif [[ $cur == -* ]]; then")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(append(words, "--help"), " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tif [[ $COMP_CWORD -eq 2 && ${COMP_WORDS[1]} == cache ]]; then")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(cacheOperations, " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tif [[ $COMP_CWORD -eq 2 && ${COMP_WORDS[1]} == completion ]]; then")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(completionShells, " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcompopt -o filenames 2>/dev/null")
+	fmt.Fprintln(w, "\tCOMPREPLY=($(compgen -f -- \"$cur\"))")
+	fmt.Fprintln(w, "\tif [[ $COMP_CWORD -eq 1 ]]; then")
+	fmt.Fprintf(w, "\t\tCOMPREPLY+=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _dupes dupes")
+}
+
+// zshQuote quotes s as a word for zsh, within single quotes.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshDescription escapes s for the brackets of an _arguments spec, or the
+// description of a command given to _describe.
+func zshDescription(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+// writeZshCompletion writes a zsh completion function for dupes, to be
+// saved as _dupes in a directory of $fpath or sourced.
+func writeZshCompletion(w io.Writer, fl []completionFlag) {
+	fmt.Fprintln(w, "#compdef dupes")
+	fmt.Fprintln(w, "# zsh completion for dupes, written by dupes completion zsh.")
+	fmt.Fprintln(w, "# Save it as _dupes in a directory of $fpath, or load it with: source <(dupes completion zsh)")
+	fmt.Fprintln(w, "_dupes() {")
+	fmt.Fprintln(w, "\tlocal -a commands opts")
+	fmt.Fprintln(w, "\tcommands=(")
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t\t%s\n", zshQuote(c.name+":"+zshDescription(c.summary)))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\topts=(")
+	for _, f := range fl {
+		names := []string{"--" + f.name}
+		if f.short != "" {
+			names = append(names, "-"+f.short)
+		}
+		for _, n := range names {
+			spec := n
+			if f.repeatable {
+				spec = "*" + spec
+			} else if f.short != "" {
+				spec = "(--" + f.name + " -" + f.short + ")" + spec
+			}
+			if f.takesValue {
+				if strings.HasPrefix(n, "--") {
+					spec += "="
+				} else {
+					spec += "+"
+				}
+			}
+			spec += "[" + zshDescription(f.usage) + "]"
+			if f.takesValue {
+				arg := zshDescription(f.arg)
+				switch {
+				case len(f.choices) > 0:
+					spec += ":" + arg + ":(" + strings.Join(f.choices, " ") + ")"
+				case f.dirs:
+					spec += ":directory:_files -/"
+				case f.files:
+					spec += ":path:_files"
+				default:
+					spec += ":" + arg + ": "
+				}
+			}
+			fmt.Fprintf(w, "\t\t%s\n", zshQuote(spec))
+		}
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\tif (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then")
+	fmt.Fprintln(w, "\t\t_describe -t commands 'dupes command' commands")
+	fmt.Fprintln(w, "\t\t_files")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase $words[2] in")
+	fmt.Fprintf(w, "\tcache)\n\t\tif (( CURRENT == 3 )); then\n\t\t\tcompadd %s\n\t\telse\n\t\t\t_files\n\t\tfi\n\t\treturn ;;\n", strings.Join(cacheOperations, " "))
+	fmt.Fprintf(w, "\tcompletion)\n\t\t(( CURRENT == 3 )) && compadd %s\n\t\treturn ;;\n", strings.Join(completionShells, " "))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\t_arguments -s $opts '*:path:_files'")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "if [[ $zsh_eval_context[-1] == loadautofunc ]]; then")
+	fmt.Fprintln(w, "\t_dupes \"$@\"")
+	fmt.Fprintln(w, "else")
+	fmt.Fprintln(w, "\tcompdef _dupes dupes")
+	fmt.Fprintln(w, "fi")
+}
+
+// fishQuote quotes s as a word for fish, within single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// writeFishCompletion writes the fish completions for dupes, to be saved
+// in ~/.config/fish/completions/dupes.fish or sourced.
+func writeFishCompletion(w io.Writer, fl []completionFlag) {
+	fmt.Fprintln(w, "# fish completion for dupes, written by dupes completion fish.")
+	fmt.Fprintln(w, "# Save it as ~/.config/fish/completions/dupes.fish, or load it with: dupes completion fish | source")
+	fmt.Fprintln(w, "complete -c dupes -f")
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c dupes -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	fmt.Fprintf(w, "complete -c dupes -n '__fish_seen_subcommand_from cache' -a %s\n", fishQuote(strings.Join(cacheOperations, " ")))
+	fmt.Fprintf(w, "complete -c dupes -n '__fish_seen_subcommand_from completion' -a %s\n", fishQuote(strings.Join(completionShells, " ")))
+	fmt.Fprintln(w, "complete -c dupes -n 'not __fish_seen_subcommand_from completion' -F")
+	for _, f := range fl {
+		line := "complete -c dupes -l " + f.name
+		if f.short != "" {
+			line += " -s " + f.short
+		}
+		switch {
+		case !f.takesValue:
+		case len(f.choices) > 0:
+			line += " -x -a " + fishQuote(strings.Join(f.choices, " "))
+		case f.dirs:
+			line += " -x -a '(__fish_complete_directories)'"
+		case f.files:
+			line += " -r -F"
+		default:
+			line += " -x"
+		}
+		fmt.Fprintln(w, line+" -d "+fishQuote(f.usage))
+	}
+}
+
+// powerShellQuote quotes s as a PowerShell string, within single quotes.
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// powerShellList writes list as a PowerShell array of strings.
+func powerShellList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = powerShellQuote(s)
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+// writePowerShellCompletion writes a PowerShell argument completer for
+// dupes, to be added to $PROFILE or dot-sourced. Where it offers nothing,
+// PowerShell completes paths.
+func writePowerShellCompletion(w io.Writer, fl []completionFlag) {
+	fmt.Fprintln(w, "# PowerShell completion for dupes, written by dupes completion powershell.")
+	fmt.Fprintln(w, "# Load it with: dupes completion powershell | Out-String | Invoke-Expression")
+	fmt.Fprintln(w, "Register-ArgumentCompleter -Native -CommandName dupes, dupes.exe -ScriptBlock {")
+	fmt.Fprintln(w, "\tparam($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w, "\t$commands = [ordered]@{")
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t\t%s = %s\n", powerShellQuote(c.name), powerShellQuote(c.summary))
+	}
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "\t$options = [ordered]@{")
+	for _, f := range fl {
+		fmt.Fprintf(w, "\t\t%s = %s\n", powerShellQuote("--"+f.name), powerShellQuote(f.usage))
+		if f.short != "" {
+			fmt.Fprintf(w, "\t\t%s = %s\n", powerShellQuote("-"+f.short), powerShellQuote(f.usage))
+		}
+	}
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "\t$values = @{")
+	for _, f := range fl {
+		if len(f.choices) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t\t%s = %s\n", powerShellQuote("--"+f.name), powerShellList(f.choices))
+		if f.short != "" {
+			fmt.Fprintf(w, "\t\t%s = %s\n", powerShellQuote("-"+f.short), powerShellList(f.choices))
+		}
+	}
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "\t$words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "\t$prev = $words[-1]")
+	fmt.Fprintln(w, "\t$choices = $null")
+	fmt.Fprintln(w, "\tif ($values.Contains($prev)) {")
+	fmt.Fprintln(w, "\t\t$choices = $values[$prev]")
+	fmt.Fprintln(w, "\t} elseif ($words.Count -eq 2 -and $words[1] -eq 'cache') {")
+	fmt.Fprintf(w, "\t\t$choices = %s\n", powerShellList(cacheOperations))
+	fmt.Fprintln(w, "\t} elseif ($words.Count -eq 2 -and $words[1] -eq 'completion') {")
+	fmt.Fprintf(w, "\t\t$choices = %s\n", powerShellList(completionShells))
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "\tif ($choices) {")
+	fmt.Fprintln(w, "\t\t$choices | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "\t\t\t[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "\t\t}")
+	fmt.Fprintln(w, "\t} elseif ($wordToComplete -like '-*') {")
+	fmt.Fprintln(w, "\t\t$options.Keys | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "\t\t\t[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterName', $options[$_])")
+	fmt.Fprintln(w, "\t\t}")
+	fmt.Fprintln(w, "\t} elseif ($words.Count -eq 1) {")
+	fmt.Fprintln(w, "\t\t$commands.Keys | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "\t\t\t[System.Management.Automation.CompletionResult]::new($_, $_, 'Command', $commands[$_])")
+	fmt.Fprintln(w, "\t\t}")
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "}")
+}
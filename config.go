@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configFile is the config file given with --config.
+var configFile string
+
+// configFlag returns the value of --config in args, or "" if it is not
+// given. The config file is read before the flags are parsed, so --config
+// is picked out on its own.
+func configFlag(args []string) string {
+	return flagArg(args, "config")
+}
+
+// flagArg returns the value of the flag called name in args, or "" if it
+// is not given, for the flags needed before the others are parsed.
+func flagArg(args []string, name string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		arg := strings.TrimLeft(a, "-")
+		if arg == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"=")
+		}
+	}
+	return ""
+}
+
+// configPath returns the path of the config file, and whether it was chosen
+// with --config or DUPES_CONFIG and so must exist. It returns "" if there
+// is no home directory to look in.
+func configPath() (string, bool) {
+	if configFile != "" {
+		return configFile, true
+	}
+	if path := os.Getenv("DUPES_CONFIG"); path != "" {
+		return path, true
+	}
+	dir := configDir()
+	if dir == "" {
+		return "", false
+	}
+	return filepath.Join(dir, "config.toml"), false
+}
+
+// configDir returns the directory the config file is looked for in,
+// ~/.config/dupes, or "" if there is no home directory.
+func configDir() string {
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		config = filepath.Join(home, ".config")
+	}
+	return filepath.Join(config, "dupes")
+}
+
+// envName returns the environment variable that sets the flag called name,
+// e.g. DUPES_MAX_DEPTH for max-depth.
+func envName(name string) string {
+	return "DUPES_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig applies the settings in the config file, and then those in
+// DUPES_* environment variables, to the flags. Each setting is named after
+// a flag's long name and is applied as if it had been given on the command
+// line, before it is parsed, so flags on the command line override single
+// values and add to repeatable ones.
+func (fs *flagSet) loadConfig() error {
+	if path, required := configPath(); path != "" {
+		if _, err := os.Stat(path); err == nil || required {
+			var settings map[string]interface{}
+			if _, err := toml.DecodeFile(path, &settings); err != nil {
+				return err
+			}
+			names := make([]string, 0, len(settings))
+			for name := range settings {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if err := fs.setConfig(name, settings[name]); err != nil {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+			}
+		}
+	}
+	for _, name := range fs.order {
+		if v := os.Getenv(envName(name)); v != "" {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%s: %v", envName(name), err)
+			}
+		}
+	}
+	return nil
+}
+
+// setConfig applies the config file setting name. Arrays give a repeatable
+// flag several values. Settings for options of other commands are left out,
+// so that one config file serves them all.
+func (fs *flagSet) setConfig(name string, value interface{}) error {
+	if _, ok := fs.arg[name]; !ok {
+		if _, ok := allFlags().arg[name]; ok {
+			return nil
+		}
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	values := []interface{}{value}
+	if list, ok := value.([]interface{}); ok {
+		values = list
+	}
+	for _, v := range values {
+		switch v.(type) {
+		case string, bool, int64, float64:
+		default:
+			return fmt.Errorf("invalid value for %s", name)
+		}
+		if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("invalid value %v for %s: %v", v, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Number of finished scans dupes daemon keeps the reports of, for the API.
+const DAEMON_HISTORY = 10
+
+// daemonCachePath returns the hash cache dupes daemon uses unless --cache
+// is given, so that each scan only reads the files changed since the last,
+// or "" if there is no cache directory.
+func daemonCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	dir = filepath.Join(dir, "dupes")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "daemon.cache")
+}
+
+// daemon is the state of dupes daemon: it scans dirs with s each time sched
+// comes round. If output is set, each scan's report is written there in
+// format, replacing the last.
+type daemon struct {
+	s      *server
+	sched  *schedule
+	dirs   []string
+	format string
+	output string
+}
+
+// run runs the scans until s.ctx is done, and returns the exit status.
+// Scans never overlap: one that is still running when the next is due
+// delays it to the next time after it finishes.
+func (d *daemon) run() int {
+	for {
+		next, err := d.sched.next(time.Now())
+		if err != nil {
+			logError("scheduling the next scan", err)
+			return EXIT_ERROR
+		}
+		if quiet < 2 {
+			fmt.Printf(tr("Next scan at %s.\n"), next.Format("2006-01-02 15:04 MST"))
+		}
+		if !d.wait(next) {
+			return EXIT_NO_DUPES
+		}
+		ctx, scan := d.s.begin(d.dirs)
+		d.s.run(ctx, scan)
+		d.finished(scan)
+	}
+}
+
+// wait waits until t, and reports whether it did rather than stopping.
+// The clock is checked every minute, so that scans still start on time
+// after the system is suspended or its clock is set.
+func (d *daemon) wait(t time.Time) bool {
+	for {
+		left := time.Until(t)
+		if left <= 0 {
+			return true
+		}
+		if left > time.Minute {
+			left = time.Minute
+		}
+		select {
+		case <-d.s.ctx.Done():
+			return false
+		case <-time.After(left):
+		}
+	}
+}
+
+// finished reports on a scan that has stopped, and forgets the oldest
+// scans beyond DAEMON_HISTORY.
+func (d *daemon) finished(scan *serverScan) {
+	d.s.mu.Lock()
+	r := scan.report
+	for i := 0; len(d.s.order) > DAEMON_HISTORY && i < len(d.s.order); {
+		if old := d.s.scans[d.s.order[i]]; old.State != SCAN_RUNNING {
+			d.s.forget(old.ID)
+		} else {
+			i++
+		}
+	}
+	d.s.mu.Unlock()
+	if r == nil {
+		return
+	}
+	if quiet < 2 {
+		fmt.Printf(tr("Found %d duplicate files in %d groups, wasting %s.\n"),
+			r.Summary.DuplicateFiles, r.Summary.DuplicateGroups, formatBytes(r.Summary.WastedBytes))
+	}
+	if d.output != "" {
+		writeReport(d.format, *r, d.output, nil)
+	}
+}
+
+// runDaemon runs the daemon command, serving the API on listen and the
+// metrics on metricsListen if they are set, and returns the exit status.
+func runDaemon(d *daemon, listen string, metricsListen string) int {
+	if listen != "" {
+		serve, err := d.s.listen(listen)
+		if err != nil {
+			logError("listening", err)
+			return EXIT_ERROR
+		}
+		go func() {
+			if err := serve(); err != nil {
+				logError("serving", err)
+			}
+		}()
+	}
+	if metricsListen != "" {
+		if err := serveMetrics(d.s.metrics, metricsListen); err != nil {
+			logError("serving metrics", err)
+			return EXIT_ERROR
+		}
+	}
+	return d.run()
+}
@@ -2,264 +2,623 @@ package main
 
 import (
 	"bytes"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
-	"path/filepath"
+	"os/user"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/OneOfOne/xxhash"
-	"github.com/minio/highwayhash"
-	"github.com/xiaonanln/go-trie-tst"
+	"github.com/cwadley/dupes/pkg/dupes"
 	"gopkg.in/gookit/color.v1"
 )
 
-// Key used as seed for the HighwayHash algorithm.
-// This is hardcoded to ensure consistent hashes for files across runs.
-const HH_KEY = "E9ECA1531393D174DFEA70CC5BAA4FCE5FC599D08ECB36B9961489985A64D3AE"
-
 type dupe struct {
-	Hash  string   `json:"hash"`
-	Files []string `json:"files"`
+	GroupID     string     `json:"group_id"`
+	Hash        string     `json:"hash"`
+	Size        int64      `json:"size"`
+	WastedBytes int64      `json:"wasted_bytes"`
+	Files       []dupeFile `json:"files"`
+	Note        string     `json:"note,omitempty"`
+
+	group dupes.DupeGroup
 }
 
-func printUsage() {
-	fmt.Println("Usage: dupes [OPTIONS] <dupe_directory>")
-	fmt.Println("\tdupe_directory is the directory that will be recursively searched for duplicate files")
-	fmt.Println("Options:")
-	fmt.Println("\t-j, --json <path> (Optional)")
-	fmt.Println("\t\tOutputs results as JSON to the specified file path")
+type dupeFile struct {
+	Path      string    `json:"path"`
+	Root      string    `json:"root"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	Reference bool      `json:"reference,omitempty"`
+	Archive   string    `json:"archive,omitempty"`
+	Links     []string  `json:"links,omitempty"`
 }
 
-func printDupes(t *trietst.TST, json_output bool, json_file string) error {
-	var json_dupes []dupe
-	t.ForEach(
-		func(k string, d interface{}) {
-			if d != nil {
-				dupes := d.([]string)
-				if len(dupes) > 1 {
-					color.Blue.Printf("Hash: %x\n", k)
-					for i, f := range dupes {
-						color.Red.Printf("\t%d ", i+1)
-						color.Yellow.Printf("%s\n", f)
-					}
-					fmt.Println()
-
-					if json_output {
-						var curr_dupe dupe
-						curr_dupe.Hash = k
-						curr_dupe.Files = dupes
-						json_dupes = append(json_dupes, curr_dupe)
-					}
-				}
-			}
-		})
+type summary struct {
+	FilesScanned     int64 `json:"files_scanned"`
+	BytesScanned     int64 `json:"bytes_scanned"`
+	CachedFiles      int64 `json:"cached_files"`
+	ResumedFiles     int64 `json:"resumed_files"`
+	RejectedFiles    int64 `json:"rejected_by_partial_hash"`
+	DuplicateGroups  int   `json:"duplicate_groups"`
+	DuplicateFiles   int64 `json:"duplicate_files"`
+	WastedBytes      int64 `json:"wasted_bytes"`
+	SuppressedGroups int   `json:"suppressed_groups"`
+	HashCollisions   int64 `json:"hash_collisions"`
+	LinkedFiles      int64 `json:"linked_files"`
+	// HashedFiles of the CandidateFiles, those sharing their size with
+	// another file, were hashed. Partial is set if a limit, named by Limit,
+	// stopped the scan before every candidate was: "max-files",
+	// "max-duration" or "first".
+	HashedFiles    int64  `json:"hashed"`
+	CandidateFiles int64  `json:"candidates"`
+	Partial        bool   `json:"partial"`
+	Limit          string `json:"limit,omitempty"`
+}
 
-	if json_output {
-		json_data, err := json.Marshal(json_dupes)
-		if err != nil {
-			fmt.Println("Error marshalling output JSON")
-			return err
-		}
-		err = ioutil.WriteFile(json_file, json_data, 0644)
-		if err != nil {
-			fmt.Println("Error writing JSON file, please check permissions and that the directory exists.")
-			return err
+// similarFiles is a group of images found by --images, of recordings
+// found by --audio, or of files found by --near.
+type similarFiles struct {
+	Similarity float64    `json:"similarity"`
+	Files      []dupeFile `json:"files"`
+}
+
+// similarNames is a group of files found by --similar-names, whose names
+// differ only by copy suffixes.
+type similarNames struct {
+	Name      string     `json:"name"`
+	SizeRatio float64    `json:"size_ratio"`
+	Files     []dupeFile `json:"files"`
+}
+
+// dupeDir is a group of identical directory trees.
+type dupeDir struct {
+	Digest      string   `json:"digest"`
+	Files       int      `json:"files"`
+	Size        int64    `json:"size"`
+	WastedBytes int64    `json:"wasted_bytes"`
+	Dirs        []string `json:"dirs"`
+}
+
+// comparison lists the files unique to each tree in --compare mode. The
+// files present in both are reported as duplicate groups.
+type comparison struct {
+	A       string     `json:"a"`
+	B       string     `json:"b"`
+	OnlyInA []dupeFile `json:"only_in_a"`
+	OnlyInB []dupeFile `json:"only_in_b"`
+}
+
+// hashChunking records how --chunk-over hashed large files, so that their
+// hashes can be reproduced: in chunks of ChunkSize bytes if larger than
+// Threshold, the hash of each file being that of its chunks' hashes, in
+// hex and one per line.
+type hashChunking struct {
+	Threshold int64 `json:"threshold"`
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+type report struct {
+	HashAlgorithm   string         `json:"hash_algorithm"`
+	HashChunking    *hashChunking  `json:"hash_chunking,omitempty"`
+	HighwayKey      string         `json:"highway_key,omitempty"`
+	Verified        bool           `json:"verified"`
+	Interrupted     bool           `json:"interrupted"`
+	Summary         summary        `json:"summary"`
+	Dupes           []dupe         `json:"dupes"`
+	Collisions      []dupe         `json:"collisions,omitempty"`
+	Comparison      *comparison    `json:"comparison,omitempty"`
+	DuplicateDirs   []dupeDir      `json:"duplicate_dirs,omitempty"`
+	WasteByDir      []dirWaste     `json:"waste_by_dir,omitempty"`
+	EmptyFiles      []string       `json:"empty_files,omitempty"`
+	EmptyDirs       []string       `json:"empty_dirs,omitempty"`
+	SimilarImages   []similarFiles `json:"similar_images,omitempty"`
+	SimilarAudio    []similarFiles `json:"similar_audio,omitempty"`
+	SimilarContent  []similarFiles `json:"similar_content,omitempty"`
+	SimilarNames    []similarNames `json:"similar_names,omitempty"`
+	Errors          []scanError    `json:"errors"`
+	ErrorsTotal     int64          `json:"errors_total"`
+	ErrorsTruncated bool           `json:"errors_truncated"`
+}
+
+// newSummary returns the summary of a scan with stats that found the
+// duplicate groups in found, after suppressed groups were left out by the
+// accept list.
+func newSummary(stats dupes.Stats, found []dupe, suppressed int) summary {
+	sum := summary{
+		FilesScanned:     stats.Files,
+		BytesScanned:     stats.Bytes,
+		CachedFiles:      stats.Cached,
+		ResumedFiles:     stats.Resumed,
+		RejectedFiles:    stats.Rejected,
+		DuplicateGroups:  len(found),
+		SuppressedGroups: suppressed,
+		HashCollisions:   stats.Collisions,
+		LinkedFiles:      stats.Links,
+		HashedFiles:      stats.Hashed,
+		CandidateFiles:   stats.Candidates,
+		Partial:          stats.Limit != nil,
+	}
+	switch stats.Limit {
+	case dupes.ErrMaxFiles:
+		sum.Limit = "max-files"
+	case dupes.ErrMaxDuration:
+		sum.Limit = "max-duration"
+	case dupes.ErrStopped:
+		sum.Limit = "first"
+	}
+	for _, d := range found {
+		sum.DuplicateFiles += int64(d.group.Duplicates())
+		sum.WastedBytes += d.WastedBytes
+	}
+	return sum
+}
+
+// quiet is the number of times -q was given: once prints only the summary
+// and the totals of any action, and twice nothing but errors and prompts.
+var quiet int
+
+// human is set by --human to print the sizes of groups and actions in KiB,
+// MiB and so on rather than in bytes.
+var human bool
+
+// print0 is set by --print0 to end each path of --format paths output with
+// a NUL byte rather than a newline.
+var print0 bool
+
+func toDupeFile(f dupes.File) dupeFile {
+	links := f.Links
+	if normalizeUnicode && len(links) > 0 {
+		links = make([]string, len(f.Links))
+		for i, link := range f.Links {
+			links[i] = reportedPath(link)
 		}
 	}
-	return nil
+	return dupeFile{
+		Path:      reportedPath(f.Path),
+		Root:      reportedPath(f.Root),
+		Size:      f.Size,
+		ModTime:   f.ModTime,
+		Reference: f.Reference,
+		Archive:   reportedPath(f.Archive),
+		Links:     links,
+	}
 }
 
-func addDupesToTST(key string, path string, t *trietst.TST) {
-	dupes := make([]string, 1)
-	dupes[0] = path
-	t.Set(key, dupes)
+// toDupes converts scan results into the CLI's report representation.
+func toDupes(groups []dupes.DupeGroup) []dupe {
+	var found []dupe
+	for _, g := range groups {
+		var curr_dupe dupe
+		curr_dupe.group = g
+		curr_dupe.GroupID = g.ID
+		curr_dupe.Hash = g.Hash
+		curr_dupe.Size = g.Size()
+		curr_dupe.WastedBytes = g.WastedBytes()
+		for _, f := range g.Files {
+			curr_dupe.Files = append(curr_dupe.Files, toDupeFile(f))
+		}
+		if normalizeUnicode {
+			if note := unicodeFormNote(g); note != "" {
+				addNote(&curr_dupe, note)
+			}
+		}
+		found = append(found, curr_dupe)
+	}
+	return found
 }
 
-func getSingleReader(path string) (io.Reader, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func printDupes(found []dupe) {
+	for _, d := range found {
+		color.Blue.Printf(tr("Group: %s Hash: %s (%s each, %s reclaimable)\n"), d.GroupID, d.Hash, formatSize(d.Size), formatSize(d.WastedBytes))
+		if d.Note != "" {
+			fmt.Printf(tr("Note: %s\n"), d.Note)
+		}
+		for i, f := range d.Files {
+			color.Red.Printf("\t%d ", i+1)
+			if f.Reference {
+				color.Green.Printf(tr("%s (reference)\n"), f.Path)
+			} else if f.Archive != "" {
+				color.Green.Printf(tr("%s (in archive)\n"), f.Path)
+			} else {
+				color.Yellow.Printf("%s\n", f.Path)
+			}
+			for _, link := range f.Links {
+				fmt.Printf(tr("\t  same file: %s\n"), link)
+			}
+		}
+		fmt.Println()
 	}
-	defer f.Close()
+}
 
-	b, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, err
+// printDupeDirs prints the groups of identical directory trees.
+func printDupeDirs(dirs []dupeDir) {
+	if len(dirs) == 0 {
+		color.Green.Println(tr("No duplicate directories found."))
+		return
+	}
+	color.Red.Printf(tr("%d groups of duplicate directories found:\n"), len(dirs))
+	for _, d := range dirs {
+		color.Blue.Printf(tr("Directories of %d files (%s each, %s wasted):\n"), d.Files, formatBytes(d.Size), formatBytes(d.WastedBytes))
+		for i, dir := range d.Dirs {
+			color.Red.Printf("\t%d ", i+1)
+			color.Yellow.Printf("%s\n", dir)
+		}
+		fmt.Println()
 	}
-	r := bytes.NewReader(b)
-	return r, nil
 }
 
-func getDualReaders(path string) (io.Reader, io.Reader, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, nil, err
+// printEmpty prints the empty files and directories found by --empty.
+func printEmpty(files []string, dirs []string) {
+	if len(files) == 0 && len(dirs) == 0 {
+		color.Green.Println(tr("No empty files or directories found."))
+		return
+	}
+	if len(files) > 0 {
+		color.Yellow.Printf(tr("%d empty files found:\n"), len(files))
+		for _, f := range files {
+			fmt.Println("\t" + f)
+		}
+	}
+	if len(dirs) > 0 {
+		color.Yellow.Printf(tr("%d empty directories found:\n"), len(dirs))
+		for _, d := range dirs {
+			fmt.Println("\t" + d)
+		}
 	}
-	defer f.Close()
+}
 
-	b, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, nil, err
+// printSimilar prints the groups of similar files found by --images,
+// --audio or --near, described by kind, e.g. "images".
+func printSimilar(groups []similarFiles, kind string) {
+	if len(groups) == 0 {
+		color.Green.Printf(tr("No similar %s found.\n"), kind)
+		return
+	}
+	color.Red.Printf(tr("%d groups of similar %s found:\n"), len(groups), kind)
+	for _, g := range groups {
+		color.Blue.Printf(tr("%s at least %.1f%% alike:\n"), capitalize(kind), g.Similarity)
+		for i, f := range g.Files {
+			color.Red.Printf("\t%d ", i+1)
+			color.Yellow.Printf("%s (%s)\n", f.Path, formatBytes(f.Size))
+		}
+		fmt.Println()
 	}
-	r1 := bytes.NewReader(b)
-	r2 := bytes.NewReader(b)
-	return r1, r2, nil
 }
 
-func computeXXHash(r io.Reader) (string, error) {
-	h := xxhash.New64()
-	if _, err := io.Copy(h, r); err != nil {
-		return "", err
+// printSimilarNames prints the groups of files found by --similar-names,
+// those of the closest sizes first.
+func printSimilarNames(groups []similarNames) {
+	if len(groups) == 0 {
+		color.Green.Println(tr("No files with similar names found."))
+		return
+	}
+	color.Red.Printf(tr("%d groups of files with similar names found:\n"), len(groups))
+	for _, g := range groups {
+		color.Blue.Printf(tr("Copies of %s, sizes %.1f%% alike:\n"), g.Name, g.SizeRatio)
+		for i, f := range g.Files {
+			color.Red.Printf("\t%d ", i+1)
+			color.Yellow.Printf("%s (%s)\n", f.Path, formatBytes(f.Size))
+		}
+		fmt.Println()
 	}
+}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+// printComparison prints the result of --compare: the groups of files
+// present in both trees, then the files unique to each.
+func printComparison(cmp *comparison, found []dupe) {
+	if len(found) > 0 {
+		color.Red.Printf(tr("%d groups of files present in both %s and %s:\n"), len(found), cmp.A, cmp.B)
+		printDupes(found)
+	} else {
+		color.Green.Printf(tr("No files are present in both %s and %s.\n"), cmp.A, cmp.B)
+	}
+	for _, side := range []struct {
+		dir   string
+		files []dupeFile
+	}{{cmp.A, cmp.OnlyInA}, {cmp.B, cmp.OnlyInB}} {
+		color.Blue.Printf(tr("%d files only in %s:\n"), len(side.files), side.dir)
+		for _, f := range side.files {
+			color.Yellow.Printf("\t%s\n", f.Path)
+		}
+		fmt.Println()
+	}
 }
 
-func computeHighwayHash(r io.Reader) (string, error) {
-	hhKey, err := hex.DecodeString(HH_KEY)
-	if err != nil {
-		return "", err
+// parseSize parses a byte count with an optional binary unit suffix,
+// e.g. "512", "64K", "10M", "1G" or "2TB".
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"T", 1 << 40},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
 	}
 
-	h, err := highwayhash.New(hhKey)
-	if err != nil {
-		return "", err
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(str, u.suffix) {
+			multiplier = u.multiplier
+			str = strings.TrimSuffix(str, u.suffix)
+			break
+		}
 	}
 
-	if _, err := io.Copy(h, r); err != nil {
-		return "", err
+	n, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
 	}
+	return n * multiplier, nil
+}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+// lookupOwners returns the user IDs of the users given by --owner, by name
+// or ID, and by --uid.
+func lookupOwners(names []string, ids []string) ([]uint32, error) {
+	var uids []uint32
+	for _, id := range ids {
+		uid, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q", id)
+		}
+		uids = append(uids, uint32(uid))
+	}
+	for _, name := range names {
+		id := name
+		if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return nil, fmt.Errorf("unknown user %q", name)
+			}
+			id = u.Uid
+		}
+		uid, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("user %q has no numeric ID", name)
+		}
+		uids = append(uids, uint32(uid))
+	}
+	return uids, nil
 }
 
-func processFile(path string, info os.FileInfo, err error, h1TST *trietst.TST, h2TST *trietst.TST,
-	dupeCount *int64, fileCount *int64, prevTime *int64) error {
-	*fileCount++
-	currTime := time.Now().Unix()
-	if currTime-*prevTime >= 5 {
-		fmt.Println("Files processed:", *fileCount)
-		*prevTime = currTime
+// parseTime parses a point in time given as a date, as in 2024-01-31, in
+// local time, a date and time, as in 2024-01-31T18:00 or in RFC 3339, or an
+// age before now: a number of years, weeks or days, as in 1y, 2w or 30d, or
+// a duration, as in 12h or 90m.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if len(s) > 1 {
+		if days, ok := map[string]int{"y": 365, "w": 7, "d": 1}[s[len(s)-1:]]; ok {
+			if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n >= 0 {
+				return now.AddDate(0, 0, -n*days), nil
+			}
+		}
 	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
 
-	if err != nil {
-		return err
+func printSummary(sum summary) {
+	fmt.Println(tr("Summary:"))
+	fmt.Printf(tr("\tFiles scanned:    %d (%s)\n"), sum.FilesScanned, formatBytes(sum.BytesScanned))
+	if sum.CachedFiles > 0 {
+		fmt.Printf(tr("\tHashes cached:    %d\n"), sum.CachedFiles)
+	}
+	if sum.ResumedFiles > 0 {
+		fmt.Printf(tr("\tHashes resumed:   %d\n"), sum.ResumedFiles)
 	}
+	if sum.RejectedFiles > 0 {
+		fmt.Printf(tr("\tRejected early:   %d (by partial hash or S3 ETag)\n"), sum.RejectedFiles)
+	}
+	if sum.LinkedFiles > 0 {
+		fmt.Printf(tr("\tLinked files:     %d (not counted as duplicates)\n"), sum.LinkedFiles)
+	}
+	fmt.Printf(tr("\tDuplicate groups: %d\n"), sum.DuplicateGroups)
+	fmt.Printf(tr("\tDuplicate files:  %d\n"), sum.DuplicateFiles)
+	color.Red.Printf(tr("\tWasted space:     %s (%d bytes)\n"), formatBytes(sum.WastedBytes), sum.WastedBytes)
+	if sum.SuppressedGroups > 0 {
+		fmt.Printf(tr("\t%d groups suppressed by accept list\n"), sum.SuppressedGroups)
+	}
+	if sum.HashCollisions > 0 {
+		color.Yellow.Printf(tr("\t%d hash collisions caught by --verify\n"), sum.HashCollisions)
+	}
+	if sum.Limit == "first" {
+		color.Yellow.Println(tr("Stopped at the first duplicate found; the other files were not all hashed."))
+	} else if sum.Partial {
+		color.Yellow.Printf(tr("Partial results: scan stopped by %s limit.\n"), "--"+sum.Limit)
+		color.Yellow.Printf(tr("Hashed %d of %d candidate files (%.2f%%); %d of %d files had a unique size.\n"),
+			sum.HashedFiles, sum.CandidateFiles, sum.hashedPercent(), sum.FilesScanned-sum.CandidateFiles, sum.FilesScanned)
+	}
+}
 
-	if info.IsDir() {
-		return nil
+// hashedPercent returns the share of the candidate files that were hashed,
+// as a percentage.
+func (sum summary) hashedPercent() float64 {
+	if sum.CandidateFiles == 0 {
+		return 0
 	}
+	return 100 * float64(sum.HashedFiles) / float64(sum.CandidateFiles)
+}
 
-	r1, r2, err := getDualReaders(path)
-	if err != nil {
-		fmt.Println("Error opening file", path)
-		return nil
+// printTimes prints how long each stage of the scan took.
+func printTimes(stats dupes.Stats) {
+	fmt.Println(tr("Times:"))
+	fmt.Printf(tr("\tWalking:          %s\n"), stats.WalkTime.Round(time.Millisecond))
+	fmt.Printf(tr("\tHashing:          %s\n"), stats.HashTime.Round(time.Millisecond))
+	if stats.VerifyTime > 0 {
+		fmt.Printf(tr("\tVerifying:        %s\n"), stats.VerifyTime.Round(time.Millisecond))
 	}
+}
 
-	hash1String, err := computeXXHash(r1)
+// readFileList reads the paths listed in the file at path, or on stdin if
+// path is "-". Paths are separated by NUL bytes if there are any, as
+// written by find -print0, and by newlines otherwise.
+func readFileList(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
 	if err != nil {
-		fmt.Println("Error computing xxHash")
-		return err
+		return nil, err
 	}
 
-	if exists := h1TST.Get(hash1String); exists != nil {
-		// Compute hash2 of previouly seen file and add it to the trie
-		r3, err := getSingleReader(exists.(string))
-		if err != nil {
-			fmt.Println("Error opening file", path)
-			return nil
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	var paths []string
+	for _, p := range strings.Split(string(data), sep) {
+		if sep == "\n" {
+			p = strings.TrimSuffix(p, "\r")
 		}
-
-		hash2StringPrevFile, err := computeHighwayHash(r3)
-		if err != nil {
-			fmt.Println("Error computing HighwayHash")
-			return err
+		if p != "" {
+			paths = append(paths, p)
 		}
-		addDupesToTST(hash1String+hash2StringPrevFile, exists.(string), h2TST)
+	}
+	return paths, nil
+}
 
-		// Now compute hash2 of the current file
-		hash2String, err := computeHighwayHash(r2)
+// readStdinLine reads the first line of stdin, a byte at a time so that the
+// rest is left for whatever reads stdin next, such as confirmation.
+func readStdinLine() (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
 		if err != nil {
-			fmt.Println("Error computing HighwayHash")
-			return err
+			return "", err
 		}
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
+// readPatterns reads one pattern per line from the file at path, ignoring
+// blank lines and lines starting with '#'.
+func readPatterns(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-		if d := h2TST.Get(hash1String + hash2String); d != nil {
-			*dupeCount++
-			dupes := d.([]string)
-			dupes = append(dupes, path)
-			h2TST.Set(hash1String+hash2String, dupes)
-		} else {
-			addDupesToTST(hash1String+hash2String, path, h2TST)
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-	} else {
-		h1TST.Set(hash1String, path)
+		patterns = append(patterns, line)
 	}
-	return nil
+	return patterns, nil
 }
 
 func main() {
-	args := os.Args[1:]
+	os.Exit(run())
+}
 
-	if len(args) < 1 {
-		printUsage()
-		os.Exit(1)
-	}
-
-	json_output := false
-	var json_file string
-	dupeDir := ""
-	for i := 0; i < len(args); i++ {
-		if string(args[i][0]) == "-" {
-			switch flag := string(args[i][1:]); flag {
-			case "j", "-json":
-				if i+1 >= len(args) {
-					fmt.Println("Error: No JSON output file specified")
-					printUsage()
-					os.Exit(1)
-				}
-				json_output = true
-				json_file = args[i+1]
-				i++
-			default:
-				fmt.Println("Error: Invalid flag", args[i])
-				printUsage()
-				os.Exit(1)
-			}
-		} else {
-			dupeDir = args[i]
-		}
+// run runs the command given on the command line and returns the exit
+// status. Everything it opens is closed before it returns.
+func run() (status int) {
+	cmd, args := subcommand(os.Args[1:])
+	// Pick the language before the flags are parsed, so that errors in
+	// them are translated; --lang may still be set by the config file.
+	lang := flagArg(args, "lang")
+	if lang == "" {
+		lang = os.Getenv("DUPES_LANG")
 	}
+	loadCatalog(messageLang(lang))
 
-	if dupeDir == "" {
-		fmt.Println("Error: No directory specified to scan for duplicate files")
-		printUsage()
-		os.Exit(1)
+	if cmd == "cache" {
+		return runCache(args)
+	}
+	if cmd == "completion" {
+		return runCompletion(args, os.Stdout)
+	}
+	if len(args) < 1 {
+		printUsage(cmd)
+		return EXIT_USAGE
 	}
 
-	var h1TST trietst.TST
-	var h2TST trietst.TST
-	var dupeCount int64
-	var fileCount int64
-	prevTime := time.Now().Unix()
-	err := filepath.Walk(dupeDir,
-		func(path string, info os.FileInfo, err error) error {
-			return processFile(path, info, err, &h1TST, &h2TST, &dupeCount, &fileCount, &prevTime)
-		})
-
+	o, err := parseOptions(cmd, args)
 	if err != nil {
-		os.Exit(3)
+		return exitStatus(cmd, err)
+	}
+	if o.logFile != "" {
+		f, err := openLog(o.logFile, o.logFormat, o.logLevel)
+		if err != nil {
+			fmt.Println(tr("Error opening log file:"), err)
+			return EXIT_ERROR
+		}
+		defer f.Close()
+	}
+	if cmd == "diff" {
+		return runDiff(o.dirs)
+	}
+	if cmd == "undo" {
+		return runUndo(o.dirs, o.dryRun, o.force)
 	}
 
-	if dupeCount > 0 {
-		color.Red.Printf("%d Files with duplicates found:\n", dupeCount)
-		_ = printDupes(&h2TST, json_output, json_file)
-	} else {
-		color.Green.Println("No duplicate files exist in the specified directory.")
+	s, err := buildScanner(o)
+	if err != nil {
+		return exitStatus(cmd, err)
 	}
+	defer func() {
+		if err := s.close(); err != nil && status < EXIT_ERROR {
+			status = EXIT_ERROR
+		}
+	}()
+	return s.runCommand(interruptContext())
+}
 
+// exitStatus reports err, which stopped the command cmd, and returns the
+// status to exit with: usage errors are printed with the command's usage,
+// and errors doing something are logged.
+func exitStatus(cmd string, err error) int {
+	var op *opError
+	if errors.As(err, &op) {
+		logError(op.op, op.err)
+		return EXIT_ERROR
+	}
+	if err == flag.ErrHelp {
+		printUsage(cmd)
+		return EXIT_NO_DUPES
+	}
+	fmt.Println(err)
+	if _, ok := err.(usageError); ok {
+		printUsage(cmd)
+	}
+	return EXIT_USAGE
 }
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"64K", 64 << 10},
+		{"64k", 64 << 10},
+		{"10M", 10 << 20},
+		{"1G", 1 << 30},
+		{"2TB", 2 << 40},
+		{"1KiB", 1 << 10},
+		{"1MB", 1 << 20},
+		{"12B", 12},
+		{" 5 K ", 5 << 10},
+	} {
+		got, err := parseSize(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tc.in, got, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{"", "K", "-1", "1.5M", "abc", "10X", "9999999999T"} {
+		if got, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) = %d; want an error", in, got)
+		}
+	}
+}
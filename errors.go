@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/gookit/color.v1"
+)
+
+// Default cap on the number of entries in the JSON report's errors array.
+const DEFAULT_MAX_ERRORS_REPORTED = 1000
+
+// scanError describes a file that could not be scanned.
+type scanError struct {
+	Path      string `json:"path"`
+	Operation string `json:"operation"`
+	Error     string `json:"error"`
+	// Reason is Error without the operation and path, e.g. "permission
+	// denied".
+	Reason string `json:"reason"`
+}
+
+// scanErrors collects the files skipped during a scan. Files are hashed in
+// parallel, so they fail in no set order; the entries are kept sorted by
+// path, so that reports of the same tree match. Only the max entries first
+// by path are retained, but every error is counted.
+type scanErrors struct {
+	entries []scanError
+	total   int64
+	max     int
+	verbose bool
+}
+
+// add records that path was skipped because op failed.
+func (s *scanErrors) add(path string, op string, err error) {
+	s.total++
+	// A PathError already names the operation and path.
+	reason := err
+	if pe, ok := err.(*os.PathError); ok {
+		reason = pe.Err
+	}
+	i := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].Path > path })
+	if i < s.max {
+		s.entries = append(s.entries, scanError{})
+		copy(s.entries[i+1:], s.entries[i:])
+		s.entries[i] = scanError{Path: path, Operation: op, Error: err.Error(), Reason: reason.Error()}
+		if len(s.entries) > s.max {
+			s.entries = s.entries[:s.max]
+		}
+	}
+	logger.Warn("skipped file", "path", path, "op", op, "error", reason)
+	if s.verbose {
+		fmt.Printf(tr("Error: %s %s: %v\n"), op, path, reason)
+	}
+}
+
+func (s *scanErrors) truncated() bool {
+	return s.total > int64(len(s.entries))
+}
+
+// print lists the skipped files retained, each with the operation that
+// failed and why, and how many more there were.
+func (s *scanErrors) print() {
+	color.Yellow.Printf(tr("Skipped files (%d could not be scanned):\n"), s.total)
+	for _, e := range s.entries {
+		fmt.Printf("\t%s: %s: %s\n", e.Path, e.Operation, e.Reason)
+	}
+	if s.truncated() {
+		fmt.Printf(tr("\t... and %d more (see --max-errors-reported)\n"), s.total-int64(len(s.entries)))
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+// Exit statuses, so that scripts can branch on the outcome of a run. When
+// several apply, the highest is used, except for EXIT_FIRST_DUPLICATE.
+const (
+	// No duplicates were found.
+	EXIT_NO_DUPES = 0
+	// Duplicates were found. Acting on them does not change the status.
+	EXIT_DUPES_FOUND = 1
+	// The command line or configuration was invalid.
+	EXIT_USAGE = 2
+	// The scan completed, but some files could not be read, or an action
+	// could not be applied to some duplicates.
+	EXIT_FILE_ERRORS = 3
+	// The scan was interrupted and only partial results were reported.
+	EXIT_INTERRUPTED = 4
+	// An error stopped the run, such as a root that could not be walked or
+	// a report or database that could not be written.
+	EXIT_ERROR = 5
+	// --first stopped the scan at a duplicate. It takes the place of every
+	// status but EXIT_ERROR, so that a check need only look for it.
+	EXIT_FIRST_DUPLICATE = 6
+)
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flagSet is the flag.FlagSet of a command, named after it. It also
+// records the order flags were defined in, their short aliases and the
+// placeholders for their values, so that printUsage can list them the way
+// they are grouped here.
+type flagSet struct {
+	*flag.FlagSet
+	order []string
+	short map[string]string
+	arg   map[string]string
+}
+
+// flagGroup defines a group of related flags on fs, storing their values
+// in o. Commands that share options share the groups defining them.
+type flagGroup func(fs *flagSet, o *options)
+
+func newFlagSet(cmd string) *flagSet {
+	fs := &flagSet{
+		FlagSet: flag.NewFlagSet(cmd, flag.ContinueOnError),
+		short:   make(map[string]string),
+		arg:     make(map[string]string),
+	}
+	// Errors are reported by run, which prints the usage itself.
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	return fs
+}
+
+// funcValue is a flag taking a value, which is passed to the function.
+type funcValue func(string) error
+
+func (f funcValue) Set(s string) error { return f(s) }
+func (f funcValue) String() string     { return "" }
+
+// boolFuncValue is a flag taking no value, which calls the function with
+// true when given. As with flag.Bool, --name=false passes false instead, so
+// that a setting from the config file can be turned off.
+type boolFuncValue func(bool)
+
+func (f boolFuncValue) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	f(v)
+	return nil
+}
+func (f boolFuncValue) String() string   { return "" }
+func (f boolFuncValue) IsBoolFlag() bool { return true }
+
+// listValue is a repeatable flag whose values are appended to a list. If
+// split is set, each value may hold several, separated by commas.
+type listValue struct {
+	list  *[]string
+	split bool
+}
+
+func (l listValue) Set(s string) error {
+	if !l.split {
+		*l.list = append(*l.list, s)
+		return nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l.list = append(*l.list, v)
+		}
+	}
+	return nil
+}
+func (l listValue) String() string { return "" }
+
+// regexpListValue is a repeatable flag whose values are regular expressions,
+// compiled and appended to a list.
+type regexpListValue struct {
+	list *[]*regexp.Regexp
+}
+
+func (l regexpListValue) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*l.list = append(*l.list, re)
+	return nil
+}
+func (l regexpListValue) String() string { return "" }
+
+// globListValue is a repeatable flag whose values are glob patterns, as
+// understood by filepath.Match, checked and appended to a list.
+type globListValue struct {
+	list *[]string
+}
+
+func (l globListValue) Set(s string) error {
+	if _, err := filepath.Match(s, ""); err != nil {
+		return err
+	}
+	*l.list = append(*l.list, s)
+	return nil
+}
+func (l globListValue) String() string { return "" }
+
+// sizeValue is a flag whose value is a size parsed by parseSize and
+// stored in p.
+func sizeValue(p *int64) funcValue {
+	return func(s string) error {
+		size, err := parseSize(s)
+		if err != nil {
+			return err
+		}
+		*p = size
+		return nil
+	}
+}
+
+// timeValue is a flag whose value is a point in time parsed by parseTime
+// and stored in p. Ages are taken back from when the flag is parsed.
+func timeValue(p *time.Time) funcValue {
+	return func(s string) error {
+		t, err := parseTime(s, time.Now())
+		if err != nil {
+			return err
+		}
+		*p = t
+		return nil
+	}
+}
+
+// value defines a flag called name, with an optional one-letter alias,
+// whose value is shown as arg in the usage. Usage lines after the first
+// are printed as further lines of the description.
+func (fs *flagSet) value(v flag.Value, name string, short string, arg string, usage string) {
+	fs.Var(v, name, usage)
+	if short != "" {
+		fs.Var(v, short, usage)
+		fs.short[name] = short
+	}
+	fs.order = append(fs.order, name)
+	fs.arg[name] = arg
+}
+
+// string defines a flag whose value is stored in p.
+func (fs *flagSet) string(p *string, name string, short string, arg string, usage string) {
+	fs.value(funcValue(func(s string) error {
+		*p = s
+		return nil
+	}), name, short, arg, usage)
+}
+
+// bool defines a flag taking no value that sets *p to true.
+func (fs *flagSet) bool(p *bool, name string, short string, usage string) {
+	fs.value(boolFuncValue(func(v bool) { *p = v }), name, short, "", usage)
+}
+
+// takesValue reports whether the flag called name is defined and is
+// followed by a value.
+func (fs *flagSet) takesValue(name string) bool {
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// parse parses args, which may mix flags and directories, and returns the
+// directories. Flags may be written with one dash or two, and their values
+// as a separate argument or after "=", as in --json=out.json. A value may
+// start with a dash. One-letter flags may be combined, as in -vi, and the
+// last of them may take a value. Every argument after "--" is a directory.
+func (fs *flagSet) parse(args []string) ([]string, error) {
+	var flagArgs, positional []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if len(a) < 2 || a[0] != '-' {
+			positional = append(positional, a)
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		if strings.Contains(name, "=") {
+			flagArgs = append(flagArgs, a)
+			continue
+		}
+		names := []string{name}
+		if a[1] != '-' && len(name) > 1 && fs.Lookup(name) == nil {
+			names = fs.splitShort(name)
+		}
+		for _, n := range names {
+			flagArgs = append(flagArgs, "-"+n)
+		}
+		if fs.takesValue(names[len(names)-1]) && i+1 < len(args) {
+			flagArgs = append(flagArgs, args[i+1])
+			i++
+		}
+	}
+	if err := fs.Parse(flagArgs); err != nil {
+		return nil, err
+	}
+	return positional, nil
+}
+
+// splitShort splits combined one-letter flags such as "vi" into their
+// names. If they are not all short flags, or one but the last takes a
+// value, name is returned whole so that parsing reports it.
+func (fs *flagSet) splitShort(name string) []string {
+	var names []string
+	for i, c := range name {
+		n := string(c)
+		if fs.Lookup(n) == nil || i < len(name)-1 && fs.takesValue(n) {
+			return []string{name}
+		}
+		names = append(names, n)
+	}
+	return names
+}
+
+// allFlags returns the flags of every command, each once, for the
+// completion scripts and to tell settings in the config file meant for
+// other commands from mistakes.
+func allFlags() *flagSet {
+	all := newFlagSet("")
+	for _, cmd := range append([]string{""}, commandNames()...) {
+		fs := commandFlags(cmd, newOptions(cmd))
+		for _, name := range fs.order {
+			if all.Lookup(name) == nil {
+				f := fs.Lookup(name)
+				all.value(f.Value, name, fs.short[name], fs.arg[name], f.Usage)
+			}
+		}
+	}
+	return all
+}
+
+// printUsage prints how to run the command cmd, or dupes itself if it is
+// "", and describes each of its flags.
+func printUsage(cmd string) {
+	if c, ok := findCommand(cmd); ok {
+		fmt.Printf(tr("Usage: dupes %s %s\n"), c.name, c.args)
+		fmt.Println("\t" + c.summary)
+		fmt.Println(tr("\tRun dupes --help for the other commands."))
+	} else {
+		fmt.Println("Usage: dupes [COMMAND] [OPTIONS] <dupe_directory>...")
+		fmt.Println("\tdupe_directory is a directory that will be recursively searched for duplicate files.")
+		fmt.Println("\tWhen several directories are given, duplicates are also detected across them.")
+		fmt.Println("\tArguments after -- are always treated as directories.")
+		fmt.Println("\tDefaults for any option can be set in ~/.config/dupes/config.toml, e.g. workers = 4, or in DUPES_<OPTION>")
+		fmt.Println("\tenvironment variables, e.g. DUPES_WORKERS=4; options given on the command line take precedence.")
+		printCommands()
+		fmt.Println(tr("\tRun dupes <COMMAND> --help for the options each command takes."))
+	}
+	fs := commandFlags(cmd, newOptions(cmd))
+	if len(fs.order) == 0 {
+		return
+	}
+	fmt.Println("Options:")
+	for _, name := range fs.order {
+		f := fs.Lookup(name)
+		line := "\t--" + name
+		if short, ok := fs.short[name]; ok {
+			line = "\t-" + short + ", --" + name
+		}
+		if arg := fs.arg[name]; arg != "" {
+			line += " " + arg
+		}
+		switch f.Value.(type) {
+		case listValue, regexpListValue:
+			line += " (Optional, repeatable)"
+		default:
+			line += " (Optional)"
+		}
+		fmt.Println(line)
+		for _, l := range strings.Split(f.Usage, "\n") {
+			fmt.Println("\t\t" + l)
+		}
+	}
+}
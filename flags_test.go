@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlagSetParse(t *testing.T) {
+	type parsed struct {
+		verbose, interactive bool
+		json                 string
+		exclude              []string
+		dirs                 []string
+	}
+	for _, tc := range []struct {
+		args    []string
+		want    parsed
+		wantErr bool
+	}{
+		{args: []string{"a", "b"}, want: parsed{dirs: []string{"a", "b"}}},
+		{args: []string{"-v", "a", "--interactive", "b"}, want: parsed{verbose: true, interactive: true, dirs: []string{"a", "b"}}},
+		{args: []string{"--json", "out.json", "a"}, want: parsed{json: "out.json", dirs: []string{"a"}}},
+		{args: []string{"-json=out.json", "a"}, want: parsed{json: "out.json", dirs: []string{"a"}}},
+		{args: []string{"a", "-j", "out.json"}, want: parsed{json: "out.json", dirs: []string{"a"}}},
+		// A value may start with a dash.
+		{args: []string{"--json", "-out.json", "a"}, want: parsed{json: "-out.json", dirs: []string{"a"}}},
+		{args: []string{"--exclude", "x", "a", "--exclude=y"}, want: parsed{exclude: []string{"x", "y"}, dirs: []string{"a"}}},
+		{args: []string{"-vi", "a"}, want: parsed{verbose: true, interactive: true, dirs: []string{"a"}}},
+		{args: []string{"-vij", "out.json", "a"}, want: parsed{verbose: true, interactive: true, json: "out.json", dirs: []string{"a"}}},
+		{args: []string{"--verbose=false", "a"}, want: parsed{dirs: []string{"a"}}},
+		{args: []string{"-v", "--", "-i", "--json"}, want: parsed{verbose: true, dirs: []string{"-i", "--json"}}},
+		{args: []string{"-", "a"}, want: parsed{dirs: []string{"-", "a"}}},
+		{args: []string{"--bogus", "a"}, wantErr: true},
+		// Only the last of combined flags may take a value.
+		{args: []string{"-jv", "out.json"}, wantErr: true},
+		{args: []string{"-vx"}, wantErr: true},
+		{args: []string{"--verbose=maybe"}, wantErr: true},
+	} {
+		var got parsed
+		fs := newFlagSet("")
+		fs.bool(&got.verbose, "verbose", "v", "")
+		fs.bool(&got.interactive, "interactive", "i", "")
+		fs.string(&got.json, "json", "j", "<path>", "")
+		fs.value(listValue{list: &got.exclude}, "exclude", "", "<pattern>", "")
+
+		var err error
+		got.dirs, err = fs.parse(tc.args)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parse(%q) succeeded; want an error", tc.args)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parse(%q) = %+v, %v; want %+v", tc.args, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The message catalogs built into dupes, one per language, such as
+// locales/de.json for German. Each is a JSON object mapping the English
+// messages, and the format strings they are printed with, to their
+// translations.
+//
+//go:embed locales/*.json
+var builtinLocales embed.FS
+
+// catalog holds the translations of the messages dupes prints, in the
+// language in use. Messages it lacks are printed in English.
+var catalog map[string]string
+
+// tr returns the translation of the English message or format string msg.
+// A translated format string may number its verbs, as in %[2]d, to take
+// the arguments in another order.
+func tr(msg string) string {
+	if t, ok := catalog[msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// capitalize returns s with its first letter in upper case.
+func capitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+// messageLang returns the language to print messages in: lang, if given,
+// or else the one LC_ALL, LC_MESSAGES or LANG names, as in de_DE.UTF-8.
+func messageLang(lang string) string {
+	for _, l := range []string{lang, os.Getenv("LC_ALL"), os.Getenv("LC_MESSAGES"), os.Getenv("LANG")} {
+		if l != "" {
+			return l
+		}
+	}
+	return ""
+}
+
+// loadCatalog makes the catalog for lang, such as de_AT.UTF-8, the one in
+// use, and reports whether there is one. The catalog of the language, de,
+// is overlaid with that of the region, de_AT, and each built-in catalog
+// with the file of the same name, as de.json, in DUPES_LOCALES or else in
+// the locales directory beside the config file, so translations can be
+// added or corrected without rebuilding dupes. English needs no catalog.
+func loadCatalog(lang string) (bool, error) {
+	catalog = nil
+	lang = strings.SplitN(strings.SplitN(lang, ".", 2)[0], "@", 2)[0]
+	if lang == "" || lang == "C" || lang == "POSIX" || lang == "en" || strings.HasPrefix(lang, "en_") {
+		return true, nil
+	}
+	dir := os.Getenv("DUPES_LOCALES")
+	if dir == "" {
+		if config := configDir(); config != "" {
+			dir = filepath.Join(config, "locales")
+		}
+	}
+	names := []string{lang}
+	if i := strings.IndexAny(lang, "_-"); i > 0 {
+		names = []string{lang[:i], lang}
+	}
+
+	found := false
+	for _, name := range names {
+		sources := []func() ([]byte, error){
+			func() ([]byte, error) { return builtinLocales.ReadFile("locales/" + name + ".json") },
+		}
+		if dir != "" {
+			sources = append(sources, func() ([]byte, error) { return os.ReadFile(filepath.Join(dir, name+".json")) })
+		}
+		for _, read := range sources {
+			data, err := read()
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			var messages map[string]string
+			if err := json.Unmarshal(data, &messages); err != nil {
+				return false, fmt.Errorf("%s.json: %v", name, err)
+			}
+			if catalog == nil {
+				catalog = make(map[string]string)
+			}
+			for msg, t := range messages {
+				catalog[msg] = t
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// I/O priority class and scheduling constants, from linux/ioprio.h.
+const (
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+// lowerPriority gives dupes the lowest CPU priority and the idle I/O
+// class, so it only reads the disks when nothing else is. Linux sets both
+// per thread, so every thread running is changed, and those started later
+// inherit the priorities.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil {
+			return err
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
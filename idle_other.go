@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import "syscall"
+
+// lowerPriority gives dupes the lowest CPU priority. Disk access is not
+// prioritised separately here.
+func lowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, 20)
+}
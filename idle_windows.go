@@ -0,0 +1,26 @@
+package main
+
+import "syscall"
+
+var procSetPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+// Priority classes of SetPriorityClass.
+const (
+	idlePriorityClass          = 0x40
+	processModeBackgroundBegin = 0x100000
+)
+
+// lowerPriority puts dupes in background processing mode, which lowers its
+// CPU, I/O and memory priorities, and gives it the idle priority class.
+func lowerPriority() error {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return err
+	}
+	for _, class := range []uintptr{processModeBackgroundBegin, idlePriorityClass} {
+		if r, _, err := procSetPriorityClass.Call(uintptr(process), class); r == 0 {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"golang.org/x/term"
+)
+
+// Keys recognised by the interactive review.
+const (
+	keyNone = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyToggle
+	keyKeepOnly
+	keyKeepAll
+	keyApply
+	keyQuit
+)
+
+// reviewer holds the state of an interactive review of duplicate groups.
+type reviewer struct {
+	found []dupe
+	// marked records, for each file of each group, whether the action is
+	// to be applied to it.
+	marked [][]bool
+	group  int
+	file   int
+	// top is the index of the first file shown when a group has more
+	// files than fit on the screen.
+	top     int
+	mark    string
+	message string
+	// protected reports whether a file is protected by --protect, if the
+	// Keeper protects any.
+	protected func(dupes.File) bool
+}
+
+// alwaysKept reports whether f can never be marked: reference files,
+// archive members and protected files are not acted on.
+func (r *reviewer) alwaysKept(f dupes.File) bool {
+	return f.Reference || f.Archive != "" || r.protected != nil && r.protected(f)
+}
+
+// newReviewer returns a reviewer for found with, in each group, every file
+// except the one chosen by keep, and any it spares, marked.
+func newReviewer(found []dupe, keep dupes.Keeper, mark string) *reviewer {
+	r := &reviewer{found: found, mark: mark}
+	if p, ok := keep.(interface{ Protected(dupes.File) bool }); ok {
+		r.protected = p.Protected
+	}
+	r.marked = make([][]bool, len(found))
+	sparer, _ := keep.(dupes.Sparer)
+	for i, d := range found {
+		k := keep.ChooseGroup(&d.group)
+		r.marked[i] = make([]bool, len(d.Files))
+		for j, f := range d.group.Files {
+			r.marked[i][j] = j != k && !r.alwaysKept(f) && (sparer == nil || !sparer.Spare(f))
+		}
+	}
+	return r
+}
+
+// review lets the user browse the groups in found, choose which files to
+// keep, and then applies a to the rest, returning its outcome. It requires
+// a terminal.
+func review(found []dupe, keep dupes.Keeper, a cliAction, force bool) (actionResults, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return actionResults{}, fmt.Errorf("--interactive requires a terminal")
+	}
+	r := newReviewer(found, keep, a.mark)
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return actionResults{}, err
+	}
+	// Draw on the alternate screen so the scan output is left intact.
+	fmt.Print("\x1b[?1049h")
+	apply := r.run(fd)
+	fmt.Print("\x1b[?1049l")
+	term.Restore(fd, state)
+
+	if !apply {
+		fmt.Printf(tr("No files were %s.\n"), a.noun)
+		return actionResults{}, nil
+	}
+	count := r.markedCount()
+	if count == 0 {
+		fmt.Println(tr("No files were marked."))
+		return actionResults{}, nil
+	}
+	if !force && !confirm(fmt.Sprintf(a.prompt, count)) {
+		fmt.Printf(tr("No files were %s.\n"), a.noun)
+		return actionResults{}, nil
+	}
+	results := r.apply(a.action, a.verb)
+	printActionSummary(results, a.verb, a.noun)
+	return results, nil
+}
+
+// run handles key presses until the user applies or quits, and reports
+// whether they chose to apply.
+func (r *reviewer) run(fd int) bool {
+	buf := make([]byte, 8)
+	for {
+		r.draw(fd)
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return false
+		}
+		r.message = ""
+		switch parseKey(buf[:n]) {
+		case keyUp:
+			if r.file > 0 {
+				r.file--
+			}
+		case keyDown:
+			if r.file < len(r.found[r.group].Files)-1 {
+				r.file++
+			}
+		case keyLeft:
+			if r.group > 0 {
+				r.group--
+				r.file, r.top = 0, 0
+			}
+		case keyRight:
+			if r.group < len(r.found)-1 {
+				r.group++
+				r.file, r.top = 0, 0
+			}
+		case keyToggle:
+			r.toggle()
+		case keyKeepOnly:
+			r.keepOnly()
+		case keyKeepAll:
+			for j := range r.marked[r.group] {
+				r.marked[r.group][j] = false
+			}
+		case keyApply:
+			return true
+		case keyQuit:
+			return false
+		}
+	}
+}
+
+// parseKey maps the bytes of a single key press to a key.
+func parseKey(b []byte) int {
+	switch string(b) {
+	case "\x1b[A", "\x1bOA":
+		return keyUp
+	case "\x1b[B", "\x1bOB":
+		return keyDown
+	case "\x1b[D", "\x1bOD", "\x1b[5~":
+		return keyLeft
+	case "\x1b[C", "\x1bOC", "\x1b[6~":
+		return keyRight
+	case " ":
+		return keyToggle
+	case "k":
+		return keyKeepOnly
+	case "a":
+		return keyKeepAll
+	case "\r", "\n":
+		return keyApply
+	case "q", "\x1b", "\x03":
+		return keyQuit
+	}
+	return keyNone
+}
+
+// toggle flips the mark of the selected file, refusing to mark a reference
+// file, an archive member, a protected file or the last kept file of a
+// group.
+func (r *reviewer) toggle() {
+	if f := r.found[r.group].group.Files[r.file]; r.alwaysKept(f) {
+		switch {
+		case f.Reference:
+			r.message = "Reference files are always kept."
+		case f.Archive != "":
+			r.message = "Files inside archives are always kept."
+		default:
+			r.message = "Protected files are always kept."
+		}
+		return
+	}
+	marks := r.marked[r.group]
+	if !marks[r.file] {
+		kept := 0
+		for _, m := range marks {
+			if !m {
+				kept++
+			}
+		}
+		if kept == 1 {
+			r.message = "At least one file in each group must be kept."
+			return
+		}
+	}
+	marks[r.file] = !marks[r.file]
+}
+
+// keepOnly marks every file of the group but the selected one, except
+// those that are always kept.
+func (r *reviewer) keepOnly() {
+	for j, f := range r.found[r.group].group.Files {
+		r.marked[r.group][j] = j != r.file && !r.alwaysKept(f)
+	}
+}
+
+// markedCount returns the number of files marked across every group.
+func (r *reviewer) markedCount() int {
+	count := 0
+	for _, marks := range r.marked {
+		for _, m := range marks {
+			if m {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// draw redraws the screen for the selected group and file. The terminal is
+// in raw mode, so lines end in "\r\n".
+func (r *reviewer) draw(fd int) {
+	_, height, err := term.GetSize(fd)
+	if err != nil || height <= 0 {
+		height = 24
+	}
+
+	d := r.found[r.group]
+	files := d.group.Files
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "Group %d of %d (%s): %d files of %s, %s wasted\r\n\r\n",
+		r.group+1, len(r.found), d.GroupID, len(files), formatBytes(d.Size), formatBytes(d.WastedBytes))
+
+	// Leave room for the header, the selected file's details and the help.
+	rows := height - 11
+	if rows < 1 {
+		rows = 1
+	}
+	if r.file < r.top {
+		r.top = r.file
+	}
+	if r.file >= r.top+rows {
+		r.top = r.file - rows + 1
+	}
+	for j := r.top; j < len(files) && j < r.top+rows; j++ {
+		cursor := "  "
+		if j == r.file {
+			cursor = "> "
+		}
+		label := "keep"
+		if files[j].Reference {
+			label = "ref"
+		} else if files[j].Archive != "" {
+			label = "archive"
+		} else if r.protected != nil && r.protected(files[j]) {
+			label = "protect"
+		}
+		if r.marked[r.group][j] {
+			label = r.mark
+		}
+		fmt.Fprintf(&b, "%s[%-7s] %s\r\n", cursor, label, files[j].Path)
+	}
+
+	f := files[r.file]
+	fmt.Fprintf(&b, "\r\nPath:     %s\r\n", f.Path)
+	fmt.Fprintf(&b, "Root:     %s\r\n", f.Root)
+	fmt.Fprintf(&b, "Size:     %s (%d bytes)\r\n", formatBytes(f.Size), f.Size)
+	fmt.Fprintf(&b, "Modified: %s\r\n", f.ModTime.Format("2006-01-02 15:04:05"))
+	if len(f.Links) > 0 {
+		fmt.Fprintf(&b, "Links:    %s\r\n", strings.Join(f.Links, ", "))
+	}
+	fmt.Fprintf(&b, "\r\n%s\r\n", r.message)
+	fmt.Fprintf(&b, "up/down: file  left/right: group  space: toggle %s  k: keep only this  a: keep all  enter: apply (%d marked)  q: quit",
+		r.mark, r.markedCount())
+	fmt.Print(b.String())
+}
+
+// apply applies a to every marked file and its links, keeping the group's
+// first unmarked reference file, or else its first unmarked file. Files
+// that are always kept are left alone even if marked.
+func (r *reviewer) apply(a dupes.Action, verb string) actionResults {
+	var results actionResults
+	for i, d := range r.found {
+		g := d.group
+		keep := -1
+		for j, f := range g.Files {
+			if !r.marked[i][j] && (keep < 0 || f.Reference && !g.Files[keep].Reference) {
+				keep = j
+			}
+		}
+		if keep < 0 {
+			continue
+		}
+		keepFile := g.Files[keep]
+		for j, f := range g.Files {
+			if !r.marked[i][j] || r.alwaysKept(f) {
+				continue
+			}
+			results.report(a, verb, dupes.ActionResult{Group: &g, Keep: keepFile, Dupe: f, Err: a.Apply(keepFile, f)})
+			for _, link := range f.Links {
+				l := dupes.File{Path: link, Root: f.Root, Size: f.Size, ModTime: f.ModTime}
+				results.report(a, verb, dupes.ActionResult{Group: &g, Keep: keepFile, Dupe: l, Link: true, Err: a.Apply(keepFile, l)})
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+func TestReviewerProtected(t *testing.T) {
+	g := dupes.DupeGroup{Files: []dupes.File{
+		{Path: "/srv/a/x"},
+		{Path: "/srv/b/x", Links: []string{"/srv/protected/x"}},
+		{Path: "/srv/protected/y"},
+	}}
+	found := []dupe{{Files: make([]dupeFile, len(g.Files)), group: g}}
+	rules := dupes.KeepRules{Protect: []string{"/srv/protected"}}
+	r := newReviewer(found, rules, "delete")
+	for j, want := range []bool{true, false, false} {
+		if r.marked[0][j] != want {
+			t.Errorf("newReviewer marked %s: %v; want %v", g.Files[j].Path, r.marked[0][j], want)
+		}
+	}
+
+	// Neither toggling nor keeping only another file marks a protected one.
+	r.file = 1
+	r.toggle()
+	if r.marked[0][1] {
+		t.Error("toggle marked a file with a protected link")
+	}
+	if r.message != "Protected files are always kept." {
+		t.Errorf("toggle message = %q", r.message)
+	}
+	r.file = 0
+	r.keepOnly()
+	for j, want := range []bool{false, false, false} {
+		if r.marked[0][j] != want {
+			t.Errorf("keepOnly marked %s: %v; want %v", g.Files[j].Path, r.marked[0][j], want)
+		}
+	}
+}
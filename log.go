@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Formats --log-format writes --log-file in.
+const (
+	LOG_TEXT = "text"
+	LOG_JSON = "json"
+)
+
+// logger is the log of the run. Until --log-file is given, it prints
+// errors as dupes always has, as in "Error saving cache: ...", among the
+// report, and drops other records.
+var logger = slog.New(consoleHandler{})
+
+// logError logs that doing what msg describes, as in "saving cache",
+// failed with err.
+func logError(msg string, err error) {
+	logger.Error(msg, "error", err)
+}
+
+// consoleHandler prints error records to stdout as "Error <message>:
+// <error>", translated, ignoring their other attributes.
+type consoleHandler struct{}
+
+func (consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelError
+}
+
+func (consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var err string
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "error" {
+			err = a.Value.String()
+			return false
+		}
+		return true
+	})
+	if err == "" {
+		fmt.Printf(tr("Error %s\n"), tr(r.Message))
+	} else {
+		fmt.Printf(tr("Error %s: %s\n"), tr(r.Message), err)
+	}
+	return nil
+}
+
+func (h consoleHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h consoleHandler) WithGroup(string) slog.Handler      { return h }
+
+// logScanStopped logs to l that the scan of dirs started at started has
+// stopped in state, with its summary.
+func logScanStopped(l *slog.Logger, dirs []string, state string, started time.Time, sum summary) {
+	l.Info("scan "+state, "dirs", dirs, "seconds", math.Round(time.Since(started).Seconds()*1000)/1000,
+		"files", sum.FilesScanned, "bytes", sum.BytesScanned, "groups", sum.DuplicateGroups,
+		"duplicates", sum.DuplicateFiles, "wasted_bytes", sum.WastedBytes)
+}
+
+// parseLogLevel parses a --log-level: debug, info, warn or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q; use debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// openLog makes logger write records of level and above to the file at
+// path, appending to it, in format, instead of printing errors. It returns
+// the file, to be closed when the run ends.
+func openLog(path string, format string, level slog.Level) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(f, opts)
+	if format == LOG_JSON {
+		h = slog.NewJSONHandler(f, opts)
+	}
+	logger = slog.New(h).With("pid", os.Getpid())
+	return f, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"gopkg.in/gookit/color.v1"
+)
+
+// manifestEntry is a line of a checksum manifest: a file's hash and path.
+type manifestEntry struct {
+	sum  string
+	path string
+}
+
+// manifestHashes maps the length of a manifest's hashes to the algorithm
+// that most likely produced them. sha256, blake3 and highway hashes are the
+// same length, so a manifest of blake3 or highway hashes needs --hash.
+var manifestHashes = map[int]string{
+	16: "xxhash",
+	32: "md5",
+	40: "sha1",
+	64: "sha256",
+}
+
+// manifestLine returns the manifest line for the file at path, in the
+// format of sha256sum: the hash, two spaces and the path. As there, a path
+// holding a newline or backslash has them escaped, and the line is marked
+// with a leading backslash.
+func manifestLine(sum string, path string) string {
+	if !strings.ContainsAny(path, "\\\n") {
+		return sum + "  " + path + "\n"
+	}
+	path = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+	return "\\" + sum + "  " + path + "\n"
+}
+
+// readManifest reads the manifest at path, as written by dupes manifest or
+// by sha256sum and its kin in text or binary mode. Blank lines and lines
+// starting with # are ignored.
+func readManifest(path string) ([]manifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []manifestEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(line, "\\")
+		if escaped {
+			line = line[1:]
+		}
+		i := strings.IndexByte(line, ' ')
+		if i <= 0 || i+2 > len(line) || line[i+1] != ' ' && line[i+1] != '*' || !isHex(line[:i]) {
+			return nil, fmt.Errorf("%s:%d: not a checksum line", path, n)
+		}
+		e := manifestEntry{sum: strings.ToLower(line[:i]), path: line[i+2:]}
+		if escaped {
+			e.path = unescapeManifestPath(e.path)
+		}
+		if len(entries) > 0 && len(e.sum) != len(entries[0].sum) {
+			return nil, fmt.Errorf("%s:%d: hash of a different length from the lines above", path, n)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// unescapeManifestPath reverses the escaping of manifestLine.
+func unescapeManifestPath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// runVerifyManifest hashes the files under dirs and compares them with
+// the manifest read from manifestFile, listing the files modified, missing
+// and new since it was written, and returns the exit status.
+func runVerifyManifest(ctx context.Context, scanner *dupes.Scanner, dirs []string, manifestFile string, entries []manifestEntry, progress *progressDisplay, errs *scanErrors) int {
+	current := make(map[string]string)
+	var scanned []string
+	err := scanner.Manifest(ctx, func(path string, sum string) {
+		path = filepath.Clean(path)
+		current[path] = sum
+		scanned = append(scanned, path)
+	}, dirs...)
+	if progress != nil {
+		progress.finish()
+	}
+	status := EXIT_NO_DUPES
+	if scanner.Cache != nil {
+		if err := scanner.Cache.Save(); err != nil {
+			logError("saving cache", err)
+			status = EXIT_ERROR
+		}
+	}
+	// Files not reached yet would be reported as missing or new.
+	if errors.Is(err, context.Canceled) {
+		if quiet < 2 {
+			color.Yellow.Println(tr("Scan interrupted; the manifest was not checked."))
+		}
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		logError("scanning", err)
+		return EXIT_ERROR
+	}
+
+	listed := make(map[string]bool, len(entries))
+	var modified, missing, added []string
+	unchanged, unchecked := 0, 0
+	for _, e := range entries {
+		path := filepath.Clean(e.path)
+		listed[path] = true
+		sum, ok := current[path]
+		switch {
+		case ok && sum != e.sum:
+			modified = append(modified, path)
+		case ok:
+			unchanged++
+		default:
+			// A file that is still there was skipped, by a filter or
+			// because it could not be read, or is outside dirs.
+			if _, err := os.Lstat(path); os.IsNotExist(err) {
+				missing = append(missing, path)
+			} else {
+				unchecked++
+			}
+		}
+	}
+	for _, path := range scanned {
+		if !listed[path] {
+			added = append(added, path)
+		}
+	}
+
+	if quiet == 0 {
+		printPaths := func(what string, paths []string) {
+			if len(paths) == 0 {
+				return
+			}
+			color.Red.Printf(tr("%s files (%d):\n"), what, len(paths))
+			for _, p := range paths {
+				fmt.Println("\t" + p)
+			}
+		}
+		printPaths(tr("Modified"), modified)
+		printPaths(tr("Missing"), missing)
+		printPaths(tr("New"), added)
+		if errs.total > 0 {
+			errs.print()
+		}
+	}
+	if quiet < 2 {
+		fmt.Printf(tr("Checked %d files against %s: %d unchanged, %d modified, %d missing, %d new\n"),
+			len(entries), manifestFile, unchanged, len(modified), len(missing), len(added))
+		if unchecked > 0 {
+			fmt.Printf(tr("%d files in the manifest were not scanned\n"), unchecked)
+		}
+	}
+	if len(modified)+len(missing)+len(added) > 0 && status < EXIT_DUPES_FOUND {
+		status = EXIT_DUPES_FOUND
+	}
+	if errs.total > 0 && status < EXIT_FILE_ERRORS {
+		status = EXIT_FILE_ERRORS
+	}
+	return status
+}
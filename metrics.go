@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// Upper bounds, in seconds, of the buckets of the scan duration histogram.
+var scanDurationBuckets = []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 12 * 3600}
+
+// metrics counts the work done by the scans of dupes serve and --watch, to
+// be served at /metrics in the Prometheus text format. Counters only grow
+// while dupes runs, so a stalled scan shows as a counter that stopped.
+type metrics struct {
+	mu             sync.Mutex
+	filesScanned   int64
+	bytesHashed    int64
+	duplicates     int64
+	errors         int64
+	running        int64
+	scans          map[string]int64
+	durations      []int64
+	durationCount  int64
+	durationSum    float64
+	lastSuccess    time.Time
+	lastWasted     int64
+	lastDuplicates int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{scans: make(map[string]int64), durations: make([]int64, len(scanDurationBuckets))}
+}
+
+// scanStarted records that a scan started.
+func (m *metrics) scanStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running++
+}
+
+// progress records how far a scan got since its stats were last prev, and
+// returns the stats to pass as prev next time.
+func (m *metrics) progress(prev dupes.Stats, stats dupes.Stats) dupes.Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filesScanned += stats.Files - prev.Files
+	m.bytesHashed += stats.HashedBytes - prev.HashedBytes
+	return stats
+}
+
+// fileError records a file that could not be scanned.
+func (m *metrics) fileError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors++
+}
+
+// duplicateFound records a duplicate found by --watch after the scan.
+func (m *metrics) duplicateFound() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.duplicates++
+}
+
+// scanStopped records that a scan started by scanStarted stopped in state,
+// after running for took, having found the duplicates summarized by sum.
+func (m *metrics) scanStopped(state string, took time.Duration, sum summary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running--
+	m.scans[state]++
+	m.duplicates += sum.DuplicateFiles
+	seconds := took.Seconds()
+	for i, bound := range scanDurationBuckets {
+		if seconds <= bound {
+			m.durations[i]++
+		}
+	}
+	m.durationCount++
+	m.durationSum += seconds
+	if state == SCAN_FINISHED {
+		m.lastSuccess = time.Now()
+		m.lastWasted = sum.WastedBytes
+		m.lastDuplicates = sum.DuplicateFiles
+	}
+}
+
+// write writes the metrics to w in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric := func(name string, kind string, help string, value string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, value)
+	}
+	metric("dupes_files_scanned_total", "counter", "Files found by the scans.", strconv.FormatInt(m.filesScanned, 10))
+	metric("dupes_bytes_hashed_total", "counter", "Bytes of file content hashed by the scans.", strconv.FormatInt(m.bytesHashed, 10))
+	metric("dupes_duplicate_files_total", "counter", "Duplicate files found by the scans and by --watch.", strconv.FormatInt(m.duplicates, 10))
+	metric("dupes_file_errors_total", "counter", "Files that could not be scanned.", strconv.FormatInt(m.errors, 10))
+	metric("dupes_scans_running", "gauge", "Scans in progress.", strconv.FormatInt(m.running, 10))
+
+	fmt.Fprintln(w, "# HELP dupes_scans_total Scans that stopped, by how they ended.")
+	fmt.Fprintln(w, "# TYPE dupes_scans_total counter")
+	for _, state := range []string{SCAN_FAILED, SCAN_FINISHED, SCAN_INTERRUPTED} {
+		fmt.Fprintf(w, "dupes_scans_total{state=%q} %d\n", state, m.scans[state])
+	}
+
+	fmt.Fprintln(w, "# HELP dupes_scan_duration_seconds How long scans took.")
+	fmt.Fprintln(w, "# TYPE dupes_scan_duration_seconds histogram")
+	for i, bound := range scanDurationBuckets {
+		fmt.Fprintf(w, "dupes_scan_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'f', -1, 64), m.durations[i])
+	}
+	fmt.Fprintf(w, "dupes_scan_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "dupes_scan_duration_seconds_sum %s\n", strconv.FormatFloat(m.durationSum, 'f', -1, 64))
+	fmt.Fprintf(w, "dupes_scan_duration_seconds_count %d\n", m.durationCount)
+
+	if !m.lastSuccess.IsZero() {
+		metric("dupes_last_success_timestamp_seconds", "gauge", "When the last scan to finish did, as a Unix time.", strconv.FormatInt(m.lastSuccess.Unix(), 10))
+		metric("dupes_duplicate_files", "gauge", "Duplicate files found by the last scan to finish.", strconv.FormatInt(m.lastDuplicates, 10))
+		metric("dupes_wasted_bytes", "gauge", "Space wasted by the duplicates found by the last scan to finish.", strconv.FormatInt(m.lastWasted, 10))
+	}
+}
+
+// ServeHTTP serves the metrics.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// serveMetrics serves the metrics at /metrics on listen, in the background,
+// returning once it is listening.
+func serveMetrics(m *metrics, listen string) error {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(ln, mux)
+	return nil
+}
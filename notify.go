@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Number of groups wasting the most space listed in a notification.
+const NOTIFY_GROUPS = 10
+
+// SMTP server notification emails are sent through unless --smtp is given.
+const DEFAULT_SMTP = "localhost:25"
+
+// notifier sends the results of each scan to the --webhook URL and to the
+// --email addresses, if the scan's duplicates waste more than threshold
+// bytes. The SMTP server is given as host:port or smtp://user@host:port,
+// with the user's password in DUPES_SMTP_PASSWORD.
+type notifier struct {
+	webhook   string
+	email     []string
+	from      string
+	smtp      string
+	threshold int64
+}
+
+// notification is the JSON posted to the webhook, describing a scan that
+// stopped.
+type notification struct {
+	Host          string          `json:"host"`
+	Dirs          []string        `json:"dirs"`
+	State         string          `json:"state"`
+	Started       time.Time       `json:"started"`
+	Finished      time.Time       `json:"finished"`
+	Summary       summary         `json:"summary"`
+	Threshold     int64           `json:"threshold"`
+	LargestGroups []notifiedGroup `json:"largest_groups"`
+}
+
+// notifiedGroup is one of the groups wasting the most space, in a
+// notification.
+type notifiedGroup struct {
+	GroupID     string   `json:"group_id"`
+	Size        int64    `json:"size"`
+	WastedBytes int64    `json:"wasted_bytes"`
+	Paths       []string `json:"paths"`
+}
+
+// newNotification describes a scan of dirs that stopped in state, and
+// found the groups in found, summarized by sum.
+func newNotification(dirs []string, state string, started time.Time, sum summary, found []dupe) notification {
+	host, _ := os.Hostname()
+	n := notification{
+		Host:          host,
+		Dirs:          dirs,
+		State:         state,
+		Started:       started.UTC(),
+		Finished:      time.Now().UTC(),
+		Summary:       sum,
+		LargestGroups: []notifiedGroup{},
+	}
+	largest := append([]dupe(nil), found...)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].WastedBytes > largest[j].WastedBytes
+	})
+	if len(largest) > NOTIFY_GROUPS {
+		largest = largest[:NOTIFY_GROUPS]
+	}
+	for _, d := range largest {
+		g := notifiedGroup{GroupID: d.GroupID, Size: d.Size, WastedBytes: d.WastedBytes}
+		for _, f := range d.Files {
+			g.Paths = append(g.Paths, f.Path)
+		}
+		n.LargestGroups = append(n.LargestGroups, g)
+	}
+	return n
+}
+
+// notify sends n, unless its duplicates waste no more than the threshold.
+// Both the webhook and the email are tried even if the other fails.
+func (nt *notifier) notify(n notification) error {
+	if nt == nil || nt.threshold > 0 && n.Summary.WastedBytes <= nt.threshold {
+		return nil
+	}
+	n.Threshold = nt.threshold
+	var errs []string
+	if nt.webhook != "" {
+		if err := nt.post(n); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(nt.email) > 0 {
+		if err := nt.mail(n); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// post posts n to the webhook as JSON.
+func (nt *notifier) post(n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(nt.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", nt.webhook, resp.Status)
+	}
+	return nil
+}
+
+// mail emails n to the addresses, as plain text.
+func (nt *notifier) mail(n notification) error {
+	addr, auth, err := smtpServer(nt.smtp)
+	if err != nil {
+		return err
+	}
+	from := nt.from
+	if from == "" {
+		from = "dupes@" + n.Host
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\n", from, strings.Join(nt.email, ", "))
+	fmt.Fprintf(&b, "Subject: dupes: %s wasted by duplicates on %s\r\n", formatBytes(n.Summary.WastedBytes), n.Host)
+	fmt.Fprintf(&b, "Date: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", n.Finished.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "The scan of %s %s at %s.\r\n\r\n", strings.Join(n.Dirs, ", "), n.State, n.Finished.Local().Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&b, "Files scanned:    %d (%s)\r\n", n.Summary.FilesScanned, formatBytes(n.Summary.BytesScanned))
+	fmt.Fprintf(&b, "Duplicate groups: %d\r\n", n.Summary.DuplicateGroups)
+	fmt.Fprintf(&b, "Duplicate files:  %d\r\n", n.Summary.DuplicateFiles)
+	fmt.Fprintf(&b, "Wasted space:     %s (%d bytes)\r\n", formatBytes(n.Summary.WastedBytes), n.Summary.WastedBytes)
+	if len(n.LargestGroups) > 0 {
+		b.WriteString("\r\nThe groups wasting the most space:\r\n")
+	}
+	for _, g := range n.LargestGroups {
+		fmt.Fprintf(&b, "\r\n%s: %d files of %s, %s reclaimable\r\n", g.GroupID, len(g.Paths), formatBytes(g.Size), formatBytes(g.WastedBytes))
+		for _, p := range g.Paths {
+			fmt.Fprintf(&b, "\t%s\r\n", strings.ReplaceAll(p, "\n", "\\n"))
+		}
+	}
+	return smtp.SendMail(addr, auth, from, nt.email, []byte(b.String()))
+}
+
+// smtpServer returns the address of the SMTP server given by --smtp, and
+// how to authenticate to it if it names a user.
+func smtpServer(server string) (string, smtp.Auth, error) {
+	if !strings.Contains(server, "://") {
+		return server, nil, nil
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme != "smtp" || u.Host == "" {
+		return "", nil, fmt.Errorf("invalid SMTP server %q; give host:port or smtp://user@host:port", server)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr += ":25"
+	}
+	if u.User == nil {
+		return addr, nil, nil
+	}
+	// PlainAuth refuses to send the password unless the connection is
+	// encrypted or to localhost.
+	return addr, smtp.PlainAuth("", u.User.Username(), os.Getenv("DUPES_SMTP_PASSWORD"), u.Hostname()), nil
+}
+
+// newNotifier returns the notifier for the --webhook URL and --email
+// addresses, checking the options.
+func newNotifier(webhook string, email []string, from string, server string, threshold int64) (*notifier, error) {
+	if webhook != "" {
+		u, err := url.Parse(webhook)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", webhook)
+		}
+	}
+	if server == "" {
+		server = DEFAULT_SMTP
+	}
+	if _, _, err := smtpServer(server); err != nil {
+		return nil, err
+	}
+	return &notifier{webhook: webhook, email: email, from: from, smtp: server, threshold: threshold}, nil
+}
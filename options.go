@@ -0,0 +1,858 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// options holds what the command line, the config file and any profile ask
+// of a run. parseOptions fills it in and checks that the options go
+// together, working out those that follow from others.
+type options struct {
+	// cmd is the subcommand, or "" for none, and dirs the directories and
+	// other arguments given with it.
+	cmd  string
+	dirs []string
+
+	// What to report, and where.
+	outputFormat      string
+	outputFile        string
+	sortOrder         string
+	top               int
+	largest           int
+	findDirs          bool
+	findEmpty         bool
+	byDir             bool
+	findImages        bool
+	imageHash         string
+	findAudio         bool
+	findNear          bool
+	findNames         bool
+	cdcChunk          int
+	similarity        float64 // as a fraction, or zero for the defaults
+	compare           bool
+	first             bool
+	acceptListFile    string
+	writeAcceptList   bool
+	sqliteFile        string
+	maxErrorsReported int
+	verbose           bool
+	showProgress      bool
+	colorMode         string
+	lang              string
+	logFile           string
+	logFormat         string
+	logLevel          slog.Level
+
+	// What to do with the duplicates.
+	actions       []cliAction
+	trash         bool
+	reflink       bool
+	interactive   bool
+	relativeLinks bool
+	dryRun        bool
+	force         bool
+	scriptFile    string
+	auditLogFile  string
+	reclaim       int64
+	keep          dupes.KeepStrategy
+	prefer        []string
+	avoid         []string
+	keepMatch     []*regexp.Regexp
+	deleteMatch   []*regexp.Regexp
+	protect       []string
+	keeper        dupes.KeepRules
+
+	// Which files to scan.
+	references       []string
+	excludes         []string
+	filesFrom        string
+	minSize          int64
+	maxSize          int64
+	newerThan        time.Time
+	olderThan        time.Time
+	maxDepth         int
+	includeEmpty     bool
+	skipHidden       bool
+	respectGitignore bool
+	archives         bool
+	includeExt       []string
+	excludeExt       []string
+	mimeTypes        []string
+	ownerNames       []string
+	ownerIDs         []string
+	owners           []uint32
+	skipUnreadable   bool
+	followSymlinks   bool
+	oneFileSystem    bool
+
+	// How to hash them.
+	hashAlgorithm  string
+	hashGiven      bool
+	hhKey          string
+	hhKeyFlag      bool
+	randomSeed     bool
+	verify         bool
+	partialHash    int64
+	workers        int
+	readBufferSize int
+	maxMemory      int64
+	mmap           bool
+	retryLocked    int
+	maxReadRate    int64
+	chunkOver      int64
+	chunkSize      int64
+	cacheFile      string
+	checkpointFile string
+	resume         bool
+	maxFiles       int64
+	maxDuration    time.Duration
+	idle           bool
+	profileName    string
+
+	// The other commands, --watch and notifications.
+	manifestFile  string
+	benchSample   int64
+	watch         bool
+	listen        string
+	metricsListen string
+	scheduleSpec  string
+	sched         *schedule
+	webhook       string
+	emailTo       []string
+	emailFrom     string
+	smtpAddr      string
+	notifyOver    int64
+	notify        *notifier
+}
+
+// deleteAction is the action of --delete, and of --interactive when no
+// other is given.
+var deleteAction = cliAction{dupes.DeleteAction{},
+	"Delete %d duplicate files?", "Deleted", "deleted", "Would delete", "delete"}
+
+// usageError is a mistake on the command line, reported with the usage.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// newOptions returns the defaults of the options of cmd.
+func newOptions(cmd string) *options {
+	o := &options{
+		cmd:               cmd,
+		imageHash:         dupes.DEFAULT_IMAGE_HASH,
+		cdcChunk:          dupes.DEFAULT_CDC_CHUNK,
+		maxErrorsReported: DEFAULT_MAX_ERRORS_REPORTED,
+		showProgress:      true,
+		colorMode:         "auto",
+		logFormat:         LOG_TEXT,
+		logLevel:          slog.LevelInfo,
+		keep:              dupes.KeepFirst,
+		hashAlgorithm:     dupes.DEFAULT_HASH,
+	}
+	if cmd == "manifest" {
+		// A manifest is for checking with other tools, which know sha256
+		// but not the default hash.
+		o.hashAlgorithm = "sha256"
+	}
+	return o
+}
+
+// commonFlags defines the options every command but cache and completion
+// takes: the config file, and the language, detail and log of what is
+// printed.
+func commonFlags(fs *flagSet, o *options) {
+	fs.string(&configFile, "config", "", "<path>", "Reads the defaults for options from the specified file instead of ~/.config/dupes/config.toml")
+	fs.string(&o.lang, "lang", "", "<language>", "Language to print the report and errors in, e.g. de, instead of the one LANG names;\n"+
+		"catalogs in ~/.config/dupes/locales, or DUPES_LOCALES, add or correct translations")
+	fs.value(boolFuncValue(func(v bool) {
+		if v {
+			quiet++
+		} else {
+			quiet = 0
+		}
+	}), "quiet", "q", "", "Prints only the summary and the totals of any action; given twice, as -qq, prints nothing but errors")
+	fs.bool(&human, "human", "H", "Prints the sizes of duplicate groups and the space reclaimed in KiB, MiB and so on instead of bytes")
+	fs.string(&o.logFile, "log-file", "", "<path>", "Appends the run's log to the specified file, with when each scan started and finished and every error and skipped file,\n"+
+		"instead of printing errors among the report")
+	fs.value(funcValue(func(s string) error {
+		if s != LOG_TEXT && s != LOG_JSON {
+			return fmt.Errorf("invalid log format %q; use text or json", s)
+		}
+		o.logFormat = s
+		return nil
+	}), "log-format", "", "<text|json>", "Format of --log-file: key=value text or JSON, one record per line (default text)")
+	fs.value(funcValue(func(s string) error {
+		level, err := parseLogLevel(s)
+		o.logLevel = level
+		return err
+	}), "log-level", "", "<level>", "Least severe records --log-file gets: debug, info, warn or error (default info)")
+}
+
+// scannerFlags defines the options shared by the commands that scan: which
+// files are scanned, how they are hashed and how the scan shows its
+// progress.
+func scannerFlags(fs *flagSet, o *options) {
+	fs.value(listValue{list: &o.references}, "reference", "", "<directory>", "Also scans the directory, but only reports files outside it that have a copy inside it.\n"+
+		"Files under a reference directory are always kept")
+	fs.value(listValue{list: &o.excludes}, "exclude", "", "<pattern>", "Skips files and directories matching the glob pattern, e.g. node_modules, .git or *.tmp.\n"+
+		"Patterns containing a / match the path relative to the scanned directory")
+	fs.value(funcValue(func(path string) error {
+		patterns, err := readPatterns(path)
+		if err != nil {
+			return err
+		}
+		o.excludes = append(o.excludes, patterns...)
+		return nil
+	}), "exclude-from", "", "<path>", "Reads exclude patterns from the specified file, one per line")
+	fs.value(listValue{list: &o.includeExt, split: true}, "include-ext", "", "<ext>[,<ext>...]", "Scans only files with one of the specified extensions, e.g. jpg,png,mp4")
+	fs.value(listValue{list: &o.excludeExt, split: true}, "exclude-ext", "", "<ext>[,<ext>...]", "Skips files with any of the specified extensions")
+	fs.value(listValue{list: &o.mimeTypes, split: true}, "mime", "", "<type>[,<type>...]", "Scans only files whose content, sniffed from their first bytes, has one of the specified MIME types, e.g. image/* or video/mp4")
+	fs.bool(&o.respectGitignore, "respect-gitignore", "", "Skips files and directories that git would ignore, and .git directories.\n"+
+		".dupesignore files, in gitignore syntax, are honoured whether or not this is given")
+	fs.bool(&o.archives, "archives", "", "Also compares the files inside .zip, .tar, .tar.gz and .tgz archives, reported as archive.zip!inner/path.\n"+
+		"Files inside archives are always kept, like reference files")
+	fs.value(sizeValue(&o.minSize), "min-size", "", "<size>", "Skips files smaller than the specified size, e.g. 10K or 1M")
+	fs.value(sizeValue(&o.maxSize), "max-size", "", "<size>", "Skips files larger than the specified size, e.g. 500M or 1G")
+	fs.value(timeValue(&o.newerThan), "newer-than", "", "<time>", "Skips files last modified before the specified date, e.g. 2024-01-31, or age, e.g. 30d, 2w, 1y or 12h")
+	fs.value(timeValue(&o.olderThan), "older-than", "", "<time>", "Skips files last modified since the specified date or age, e.g. 2y to only scan files untouched for two years")
+	fs.value(funcValue(func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return errors.New("invalid depth")
+		}
+		o.maxDepth = n
+		return nil
+	}), "max-depth", "", "<count>", "Descends at most the specified number of levels below each directory; 1 scans only the files directly in it")
+	fs.bool(&o.followSymlinks, "follow-symlinks", "", "Follows symbolic links to files and directories; by default they are skipped")
+	fs.bool(&o.oneFileSystem, "one-file-system", "", "Does not descend into directories on other filesystems, such as mount points below a root")
+	fs.bool(&o.includeEmpty, "include-empty", "", "Scans zero-byte files, which are skipped by default")
+	fs.value(listValue{list: &o.ownerNames, split: true}, "owner", "", "<user>[,<user>...]", "Scans only files owned by any of the specified users, given by name or user ID")
+	fs.value(listValue{list: &o.ownerIDs, split: true}, "uid", "", "<uid>[,<uid>...]", "Scans only files owned by any of the specified user IDs")
+	fs.bool(&o.skipUnreadable, "skip-unreadable", "", "Skips files and directories whose permissions do not let dupes read them, rather than listing them as errors")
+	fs.bool(&o.skipHidden, "skip-hidden", "", "Skips hidden files and directories: those whose names start with a dot, such as .cache and .DS_Store,\n"+
+		"and on Windows those with the hidden attribute")
+	fs.value(boolFuncValue(func(v bool) { o.skipHidden = !v }), "include-hidden", "", "", "Scans hidden files and directories, as by default, overriding skip-hidden in the config file")
+	fs.value(funcValue(func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return errors.New("invalid worker count")
+		}
+		o.workers = n
+		return nil
+	}), "workers", "w", "<count>", "Number of files to hash concurrently (default is the number of CPUs)")
+	fs.value(funcValue(func(s string) error {
+		if err := dupes.ValidateHash(s); err != nil {
+			return err
+		}
+		o.hashAlgorithm, o.hashGiven = s, true
+		return nil
+	}), "hash", "", "<algorithm>[+<algorithm>]", fmt.Sprintf("Hash algorithms to compare files by: xxhash, highway, sha256, blake3, sha1 or md5 (default %s)", dupes.DEFAULT_HASH))
+	fs.value(funcValue(func(s string) error {
+		o.hhKey, o.hhKeyFlag = s, true
+		return nil
+	}), "hh-key", "", "<hex>", "Seeds HighwayHash with the specified 64-hex-digit key instead of the built-in one (also read from DUPES_HH_KEY);\n"+
+		"- reads it from the first line of stdin, keeping it out of the command line")
+	fs.bool(&o.randomSeed, "random-seed", "", "Seeds HighwayHash with a random key for this run only; group IDs will differ from every other run")
+	fs.bool(&o.verify, "verify", "", "Compares the files of each group byte for byte before reporting them as duplicates")
+	fs.value(funcValue(func(s string) error {
+		size, err := parseSize(s)
+		if err != nil {
+			return err
+		}
+		o.partialHash = size
+		if size == 0 {
+			o.partialHash = -1
+		}
+		return nil
+	}), "partial-hash", "", "<size>", "Bytes hashed at each end of a large file to reject it before reading it in full, e.g. 1M (default 64K; 0 disables)")
+	fs.value(funcValue(func(s string) error {
+		size, err := parseSize(s)
+		if err != nil || size < 1 || size > 1<<30 {
+			return errors.New("invalid read buffer size")
+		}
+		o.readBufferSize = int(size)
+		return nil
+	}), "read-buffer", "", "<size>", "Size of the buffer used when reading files, e.g. 256K or 1M (default 32K)")
+	fs.value(sizeValue(&o.maxMemory), "max-memory", "", "<size>", "Keeps the memory used to about the specified size, e.g. 512M, by hashing fewer files at once if need be\n"+
+		"and collecting garbage more often as the limit nears")
+	fs.bool(&o.mmap, "mmap", "", "Maps files of 1M or more into memory to hash them instead of reading them, which is faster for large files\n"+
+		"on most systems; files that cannot be mapped are read as usual")
+	fs.string(&o.cacheFile, "cache", "", "<path>", "Stores file hashes in the specified file so unchanged files are not re-read on later scans")
+	fs.value(funcValue(func(s string) error {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 {
+			return errors.New("invalid file count")
+		}
+		o.maxFiles = n
+		return nil
+	}), "max-files", "", "<count>", "Stops the scan once the specified number of files have been found or hashed, and reports partial results")
+	fs.value(funcValue(func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return errors.New("invalid duration")
+		}
+		o.maxDuration = d
+		return nil
+	}), "max-duration", "", "<duration>", "Stops the scan after the specified duration (e.g. 10m) and reports partial results")
+	fs.value(funcValue(func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errors.New("invalid retry count")
+		}
+		o.retryLocked = n
+		return nil
+	}), "retry-locked", "", "<count>", "Tries files locked by another process again, up to the specified number of times, once the others are hashed;\n"+
+		"files still locked are skipped and listed as locked")
+	fs.value(funcValue(func(s string) error {
+		rate, err := parseSize(strings.TrimSuffix(strings.ToLower(s), "/s"))
+		if err != nil {
+			return err
+		}
+		o.maxReadRate = rate
+		return nil
+	}), "throttle", "", "<rate>", "Limits the rate files are read at, by all workers together, to the specified size per second, e.g. 50M/s")
+	fs.value(sizeValue(&o.chunkOver), "chunk-over", "", "<size>", "Hashes files larger than the specified size, e.g. 1G, in chunks read in parallel, so that one huge file does not\n"+
+		"hold up the scan; their hashes differ from those of the whole file, and reports record the chunking")
+	fs.value(funcValue(func(s string) error {
+		size, err := parseSize(s)
+		if err != nil || size < 1 {
+			return errors.New("invalid chunk size")
+		}
+		o.chunkSize = size
+		return nil
+	}), "chunk-size", "", "<size>", "Size of the chunks --chunk-over hashes files in (default 64M)")
+	fs.bool(&o.idle, "idle", "", "Runs at the lowest CPU and disk priority, so that a scan in the background does not slow other programs")
+	fs.value(funcValue(func(s string) error {
+		if _, err := findProfile(s); err != nil {
+			return err
+		}
+		o.profileName = s
+		return nil
+	}), "profile", "", "<"+strings.Join(profileNames(), "|")+">", "Applies settings suited to a kind of scan, which options given override:\n"+profileUsage())
+	fs.value(funcValue(func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errors.New("invalid error count")
+		}
+		o.maxErrorsReported = n
+		return nil
+	}), "max-errors-reported", "", "<count>", fmt.Sprintf("Maximum number of skipped files listed in the output (default %d)", DEFAULT_MAX_ERRORS_REPORTED))
+	fs.value(boolFuncValue(func(v bool) { o.showProgress = !v }), "no-progress", "", "", "Disables the progress display")
+	fs.value(funcValue(func(mode string) error {
+		switch mode {
+		case "auto", "always", "never":
+			o.colorMode = mode
+			return nil
+		}
+		return errors.New("invalid color mode")
+	}), "color", "", "<auto|always|never>", "Whether to color the output; auto colors it only on a terminal, and when NO_COLOR is not set (default auto)")
+	fs.bool(&o.verbose, "verbose", "v", "Prints every file that could not be scanned or was skipped by a filter, and how long each stage took")
+	fs.bool(&normalizeUnicode, "normalize-unicode", "", "Reports paths in composed Unicode form (NFC), and notes files of a group named alike in different forms,\n"+
+		"as copies made on macOS may be")
+}
+
+// outputFlags defines the options choosing which duplicate groups are
+// reported, and how.
+func outputFlags(fs *flagSet, o *options) {
+	fs.value(funcValue(func(path string) error {
+		o.outputFormat, o.outputFile = "json", path
+		return nil
+	}), "json", "j", "<path>", "Outputs results as JSON to the specified file path; shorthand for --format json --output <path>")
+	fs.value(funcValue(func(format string) error {
+		if _, ok := reportFormats[format]; !ok && format != "text" && format != "ndjson" {
+			return errors.New("invalid output format")
+		}
+		o.outputFormat = format
+		if o.outputFormat == "text" {
+			o.outputFormat = ""
+		}
+		return nil
+	}), "format", "", "<text|json|ndjson|csv|tsv|markdown|paths>", "Outputs results in the specified format instead of the text report (default text).\n"+
+		"CSV and TSV output has one row per duplicate file: group_id, hash, size and path\n"+
+		"Markdown output has a summary table and a collapsible section per duplicate group\n"+
+		"Paths output has only the path of each duplicate file, one per line, with a blank line between groups\n"+
+		"NDJSON output has one JSON object per duplicate group, written as soon as the group is confirmed, and per file that could not be scanned, each with a type field")
+	fs.value(funcValue(func(order string) error {
+		if _, ok := groupOrders[order]; !ok {
+			return errors.New("invalid sort order")
+		}
+		o.sortOrder = order
+		return nil
+	}), "sort", "", "<size|count|path|hash>", "Orders duplicate groups by wasted space or number of files, largest first, or by path or hash (default walk order)")
+	fs.value(funcValue(func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return errors.New("invalid group count")
+		}
+		o.top = n
+		return nil
+	}), "top", "", "<count>", "Reports only the first specified number of duplicate groups, after --sort; the summary and any action still cover them all")
+	fs.value(funcValue(func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return errors.New("invalid group count")
+		}
+		o.largest = n
+		return nil
+	}), "largest", "", "<count>", "Reports only the specified number of duplicate groups wasting the most space, as --sort size --top does")
+	fs.bool(&print0, "print0", "0", "Ends each path of --format paths output, and each group, with a NUL byte instead of a newline, as with find -print0;\n"+
+		"implies --format paths")
+	fs.string(&o.outputFile, "output", "o", "<path>", "Writes --format output to the specified file path instead of stdout.\n"+
+		"When writing to stdout, the text report is not printed and other messages go to stderr")
+	fs.bool(&o.findEmpty, "empty", "", "Also lists the empty files, unless --include-empty groups them, and the directories holding nothing but\n"+
+		"empty directories, as candidates for cleaning up")
+	fs.string(&o.acceptListFile, "accept-list", "", "<path>", "Suppresses duplicate groups whose hashes are listed in the specified file")
+	fs.bool(&o.writeAcceptList, "write-accept-list", "", "Appends the hashes of all reported groups to the --accept-list file")
+}
+
+// findFlags defines the options finding more than duplicate files, and
+// saving what the scan found.
+func findFlags(fs *flagSet, o *options) {
+	fs.bool(&o.findDirs, "dirs", "", "Also reports directories whose whole trees are identical")
+	fs.bool(&o.byDir, "by-dir", "", "Also totals the space wasted under each directory directly below the scanned directories, to show where\n"+
+		"the duplicates are; the file each group keeps, as chosen by --keep, is not counted")
+	fs.bool(&o.findImages, "images", "", "Also reports JPEG, PNG and GIF images that look alike, such as resized or re-encoded copies")
+	fs.value(funcValue(func(name string) error {
+		if err := dupes.ValidateImageHash(name); err != nil {
+			return err
+		}
+		o.imageHash = name
+		return nil
+	}), "image-hash", "", "<dhash|phash>", fmt.Sprintf("Perceptual hash --images compares images by (default %s)", dupes.DEFAULT_IMAGE_HASH))
+	fs.bool(&o.findAudio, "audio", "", "Also reports audio files that sound alike, such as the same song as MP3 and FLAC (requires Chromaprint's fpcalc)")
+	fs.value(funcValue(func(s string) error {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return errors.New("invalid similarity")
+		}
+		o.similarity = percent / 100
+		return nil
+	}), "similarity", "", "<percent>", fmt.Sprintf("How alike files must be for --images, --audio or --near to group them (default %.0f for images, %.0f for audio, %.0f for --near)",
+		100*dupes.DEFAULT_IMAGE_SIMILARITY, 100*dupes.DEFAULT_AUDIO_SIMILARITY, 100*dupes.DEFAULT_CDC_SIMILARITY))
+	fs.bool(&o.findNear, "near", "", "Also reports files that share most of their content without being identical, such as a log and a longer copy of it,\n"+
+		"comparing the content-defined chunks they are split into")
+	fs.value(funcValue(func(s string) error {
+		size, err := parseSize(s)
+		if err != nil || size < 256 || size > 64<<20 {
+			return errors.New("invalid chunk size")
+		}
+		o.cdcChunk = int(size)
+		return nil
+	}), "near-chunk", "", "<size>", fmt.Sprintf("Average size of the chunks --near compares files by; smaller chunks find smaller shared parts but take more memory (default %dK)", dupes.DEFAULT_CDC_CHUNK>>10))
+	fs.bool(&o.findNames, "similar-names", "", "Also lists files in the same directory whose names differ only by copy suffixes, such as photo (1).jpg,\n"+
+		"photo - Copy.jpg or photo_final_v2.jpg, even if their content differs, those of the closest sizes first")
+	fs.bool(&o.compare, "compare", "", "Compares exactly two directories by content, listing the files present in both and the files unique to each")
+	fs.string(&o.filesFrom, "files-from", "", "<path>", "Compares only the files listed in the specified file, or on stdin if it is -, instead of walking directories.\n"+
+		"Paths are separated by newlines, or by NUL bytes as written by find -print0")
+	fs.string(&o.sqliteFile, "sqlite", "", "<path>", "Writes every file scanned, its hash and its duplicate group to a new SQLite database at the specified path")
+	fs.string(&o.checkpointFile, "checkpoint", "", "<path>", "Periodically records the scan's progress in the specified file, so an interrupted scan can be resumed")
+	fs.bool(&o.resume, "resume", "", "Resumes the scan recorded in the --checkpoint file instead of starting over")
+}
+
+// firstFlags defines --first, for the commands that only report duplicates.
+func firstFlags(fs *flagSet, o *options) {
+	fs.bool(&o.first, "first", "", fmt.Sprintf("Stops hashing at the first duplicate found, reports it and exits with status %d, for checks that only need\n"+
+		"to know whether there are duplicates", EXIT_FIRST_DUPLICATE))
+	fs.bool(&o.first, "any", "", "Same as --first")
+}
+
+// keepFlags defines the options choosing the file each duplicate group keeps.
+func keepFlags(fs *flagSet, o *options) {
+	fs.value(funcValue(func(s string) error {
+		k, err := dupes.ParseKeepStrategy(s)
+		if err != nil {
+			return errors.New("invalid keep strategy")
+		}
+		o.keep = k
+		return nil
+	}), "keep", "", "<first|oldest|newest|shortest-path>", "Which file in each group is kept by --delete, --hardlink, --symlink, --reflink or --move-to (default first)")
+	fs.value(listValue{list: &o.prefer}, "prefer", "", "<directory>", "Keeps a file inside the directory rather than its copies elsewhere; when repeated, the first directory given wins.\n"+
+		"--keep chooses between the files of the most preferred directory")
+	fs.value(listValue{list: &o.avoid}, "avoid", "", "<directory>", "Keeps a file inside the directory only if every copy is in one such directory")
+	fs.value(regexpListValue{&o.keepMatch}, "keep-match", "", "<regex>", "Never acts on files whose path matches the regular expression, e.g. _originals/")
+	fs.value(regexpListValue{&o.deleteMatch}, "delete-match", "", "<regex>", "Acts only on files whose path matches the regular expression; one file of each group is always kept")
+	fs.value(globListValue{&o.protect}, "protect", "", "<pattern>", "Never acts on files matching the glob pattern, and keeps them whatever --keep prefers, e.g. ~/Photos/Originals")
+}
+
+// actionFlags defines the actions taken on duplicates, and how they are taken.
+func actionFlags(fs *flagSet, o *options) {
+	fs.value(boolFuncValue(func(v bool) {
+		if v {
+			o.actions = append(o.actions, deleteAction)
+		}
+	}),
+		"delete", "", "", "Deletes all but one file in each duplicate group, after confirmation")
+	fs.value(boolFuncValue(func(v bool) {
+		if v {
+			o.actions = append(o.actions, cliAction{dupes.HardlinkAction{},
+				"Replace %d duplicate files with hard links?", "Linked", "linked", "Would link", "link"})
+		}
+	}), "hardlink", "", "", "Replaces all but one file in each duplicate group with hard links to it, after confirmation")
+	fs.value(boolFuncValue(func(v bool) {
+		if v {
+			o.actions = append(o.actions, cliAction{dupes.SymlinkAction{},
+				"Replace %d duplicate files with symbolic links?", "Linked", "linked", "Would link", "symlink"})
+		}
+	}), "symlink", "", "", "Replaces all but one file in each duplicate group with symbolic links to it, after confirmation")
+	fs.value(boolFuncValue(func(v bool) {
+		if v {
+			o.reflink = true
+			o.actions = append(o.actions, cliAction{dupes.ReflinkAction{},
+				"Share the data of %d duplicate files with the files kept?", "Reflinked", "reflinked", "Would reflink", "reflink"})
+		}
+	}), "reflink", "", "", "Makes all but one file in each duplicate group share its data on disk, on btrfs, XFS and other copy-on-write filesystems on Linux.\n"+
+		"Each file keeps its path, inode and permissions, and the kernel checks the contents match first")
+	fs.bool(&o.trash, "trash", "", "Like --delete, but moves the files to the trash or Recycle Bin so they can be restored")
+	fs.value(funcValue(func(dir string) error {
+		prompt := "Move %d duplicate files to " + strings.ReplaceAll(dir, "%", "%%") + "?"
+		o.actions = append(o.actions, cliAction{dupes.MoveAction{Dir: dir},
+			prompt, "Moved", "moved", "Would move", "move"})
+		return nil
+	}), "move-to", "", "<directory>", "Moves all but one file in each duplicate group into the directory, under their absolute paths, after confirmation")
+	fs.bool(&o.interactive, "interactive", "i", "Reviews each duplicate group in the terminal to choose which files to keep, then acts on the rest\n"+
+		"The action is --delete unless --hardlink, --symlink, --reflink or --move-to is given; --keep sets the initial choice")
+	fs.value(funcValue(func(style string) error {
+		switch style {
+		case "absolute":
+			o.relativeLinks = false
+		case "relative":
+			o.relativeLinks = true
+		default:
+			return errors.New("invalid link style")
+		}
+		return nil
+	}), "link-style", "", "<absolute|relative>", "Whether --symlink creates absolute or relative links (default absolute)")
+	fs.bool(&o.force, "force", "", "Acts on duplicates without asking for confirmation")
+	fs.bool(&o.dryRun, "dry-run", "", "Prints what --delete, --hardlink, --symlink, --reflink or --move-to would do and the space it would reclaim, without changing any files")
+	fs.string(&o.scriptFile, "script", "", "<path>", "Writes the shell commands that --delete, --hardlink, --symlink or --move-to would run to the specified file for review,\n"+
+		"instead of changing any files")
+	fs.string(&o.auditLogFile, "audit-log", "", "<path>", "Appends a line of JSON to the specified file for every file acted on, with the time, action, path, target, hash and size,\n"+
+		"so that cleanups can be traced afterwards")
+	fs.value(sizeValue(&o.reclaim), "reclaim", "", "<size>", "Acts only on the groups wasting the most space, largest first, until they hold the specified amount, e.g. 50G")
+}
+
+// watchFlags defines --watch.
+func watchFlags(fs *flagSet, o *options) {
+	fs.bool(&o.watch, "watch", "", "Keeps watching the directories after the scan, reporting new duplicates as files arrive until interrupted.\n"+
+		"With --force or --dry-run, the selected action is applied to each new duplicate")
+}
+
+// metricsFlags defines --metrics, for --watch and dupes daemon.
+func metricsFlags(fs *flagSet, o *options) {
+	fs.string(&o.metricsListen, "metrics", "", "<address>", "With --watch or dupes daemon, serves Prometheus metrics of the scan and the duplicates found since at /metrics on the address, e.g. :9100")
+}
+
+// notifyFlags defines the options sending a summary of each scan.
+func notifyFlags(fs *flagSet, o *options) {
+	fs.string(&o.webhook, "webhook", "", "<url>", "Posts a JSON summary of each scan, with the groups wasting the most space, to the URL")
+	fs.value(listValue{list: &o.emailTo, split: true}, "email", "", "<address>[,<address>...]", "Emails a summary of each scan, with the groups wasting the most space, to the addresses")
+	fs.string(&o.emailFrom, "email-from", "", "<address>", "Address --email sends from (default dupes@<hostname>)")
+	fs.string(&o.smtpAddr, "smtp", "", "<host:port>", "SMTP server --email sends through (default "+DEFAULT_SMTP+"); give smtp://user@host:port to log in,\n"+
+		"with the password in DUPES_SMTP_PASSWORD")
+	fs.value(sizeValue(&o.notifyOver), "notify-over", "", "<size>", "Sends --webhook and --email notifications only when the duplicates waste more than the specified space, e.g. 100G")
+}
+
+// serveFlags defines the options of dupes serve and dupes daemon.
+func serveFlags(fs *flagSet, o *options) {
+	fs.string(&o.listen, "listen", "", "<address>", "Address dupes serve listens on, e.g. :8080 to accept connections from other hosts (default "+DEFAULT_LISTEN+");\n"+
+		"with dupes daemon, also serves the API there")
+}
+
+// daemonFlags defines the options of dupes daemon alone.
+func daemonFlags(fs *flagSet, o *options) {
+	fs.string(&o.scheduleSpec, "schedule", "", "<cron>", "When dupes daemon scans, as the five time fields of a crontab line, e.g. \"0 3 * * *\" for 3am every day,\n"+
+		"or @hourly, @daily or @weekly")
+}
+
+// manifestFlags defines the options of dupes verify alone.
+func manifestFlags(fs *flagSet, o *options) {
+	fs.string(&o.manifestFile, "manifest", "", "<path>", "With dupes verify, checks the files against a manifest written by dupes manifest or sha256sum instead,\n"+
+		"listing those modified, missing or new since; the hash is judged by its length unless --hash is given")
+}
+
+// benchFlags defines the options of dupes bench.
+func benchFlags(fs *flagSet, o *options) {
+	fs.value(sizeValue(&o.benchSample), "bench-sample", "", "<size>", "How much of the files dupes bench reads and hashes, e.g. 1G (default 128 MiB)")
+}
+
+// undoFlags defines the options of dupes undo.
+func undoFlags(fs *flagSet, o *options) {
+	fs.bool(&o.force, "force", "", "Restores the files without asking for confirmation")
+	fs.bool(&o.dryRun, "dry-run", "", "Prints what would be restored, without changing any files")
+}
+
+// parseOptions returns the options of cmd given in args, after those in the
+// config file and any profile. The directories and other arguments are left
+// in dirs. Errors in the options are usageErrors; flag.ErrHelp is returned
+// if --help is given.
+func parseOptions(cmd string, args []string) (*options, error) {
+	o := newOptions(cmd)
+	flags := commandFlags(cmd, o)
+	configFile = configFlag(args)
+	if err := flags.loadConfig(); err != nil {
+		return nil, fmt.Errorf("%s %v", tr("Error reading configuration:"), err)
+	}
+	// A profile, from the command line or the config file, stands in for
+	// options given on the command line, which are parsed after it.
+	if p := flagArg(args, "profile"); p != "" {
+		o.profileName = p
+	}
+	if o.profileName != "" {
+		if err := flags.applyProfile(o.profileName); err != nil {
+			return nil, usageError(tr("Error:") + " " + err.Error())
+		}
+	}
+	// A key from the config file or DUPES_HH_KEY is only used if the hash
+	// includes highway, while --hh-key requires it.
+	o.hhKeyFlag = false
+
+	var err error
+	if o.dirs, err = flags.parse(args); err == flag.ErrHelp {
+		return nil, err
+	} else if err != nil {
+		return nil, usageError(tr("Error:") + " " + err.Error())
+	}
+	if o.lang != "" {
+		found, err := loadCatalog(o.lang)
+		if err != nil {
+			return nil, fmt.Errorf("%s %v", tr("Error reading message catalog:"), err)
+		}
+		if !found {
+			return nil, usageError(fmt.Sprintf(tr("Error: No messages in the language %q"), o.lang))
+		}
+	}
+	if cmd == "diff" || cmd == "undo" {
+		return o, nil
+	}
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// check checks that the options go together, and works out those that
+// follow from others.
+func (o *options) check() error {
+	if o.filesFrom != "" && len(o.dirs) > 0 {
+		return usageError(tr("Error: --files-from cannot be used with directories"))
+	}
+	if len(o.dirs) == 0 && o.filesFrom == "" && o.cmd != "serve" {
+		return usageError(tr("Error: No directory specified to scan for duplicate files"))
+	}
+
+	// --trash on its own or with --delete sends the deleted files to the
+	// trash.
+	if o.trash {
+		var others []cliAction
+		for _, a := range o.actions {
+			if _, ok := a.action.(dupes.DeleteAction); !ok {
+				others = append(others, a)
+			}
+		}
+		o.actions = append(others, cliAction{dupes.TrashAction{},
+			"Move %d duplicate files to the trash?", "Trashed", "trashed", "Would trash", "trash"})
+	}
+
+	if o.watch && o.compare {
+		return usageError(tr("Error: --watch cannot be used with --compare"))
+	}
+	if o.outputFormat == "ndjson" && o.compare {
+		return usageError(tr("Error: --format ndjson cannot be used with --compare"))
+	}
+	if o.largest > 0 {
+		if o.top > 0 || o.sortOrder != "" && o.sortOrder != "size" {
+			return usageError(tr("Error: --largest cannot be used with --top or another --sort order"))
+		}
+		o.sortOrder, o.top = "size", o.largest
+	}
+	if o.outputFormat == "ndjson" && (o.sortOrder != "" || o.top > 0) {
+		return usageError(tr("Error: --format ndjson writes groups as they are found and cannot be used with --sort, --top or --largest"))
+	}
+	if o.watch && len(o.actions) > 0 && !o.force && !o.dryRun {
+		return usageError(tr("Error: --watch with an action requires --force or --dry-run"))
+	}
+
+	if o.resume && o.checkpointFile == "" {
+		return usageError(tr("Error: --resume requires --checkpoint"))
+	}
+
+	if quiet > 0 && o.verbose {
+		return usageError(tr("Error: Only one of --quiet and --verbose may be given"))
+	}
+	if quiet > 0 {
+		o.showProgress = false
+	}
+
+	if len(o.ownerNames) > 0 || len(o.ownerIDs) > 0 {
+		var err error
+		if o.owners, err = lookupOwners(o.ownerNames, o.ownerIDs); err != nil {
+			return usageError(tr("Error:") + " " + err.Error())
+		}
+	}
+
+	if o.hhKeyFlag && o.randomSeed {
+		return usageError(tr("Error: Only one of --hh-key and --random-seed may be given"))
+	}
+	usesHighway := strings.Contains("+"+o.hashAlgorithm+"+", "+highway+")
+	if (o.hhKeyFlag || o.randomSeed) && !usesHighway {
+		return usageError(tr("Error: --hh-key and --random-seed require a --hash including highway"))
+	}
+	if o.archives && o.checkpointFile != "" {
+		return usageError(tr("Error: --archives cannot be used with --checkpoint"))
+	}
+	for _, dir := range append(append([]string(nil), o.dirs...), o.references...) {
+		if (dupes.IsRemote(dir) || dupes.IsImage(dir)) && (o.watch || o.checkpointFile != "") {
+			return usageError(tr("Error: --watch and --checkpoint cannot be used with remote or image roots"))
+		}
+	}
+	if o.findEmpty && o.checkpointFile != "" {
+		return usageError(tr("Error: --empty cannot be used with --checkpoint"))
+	}
+	if o.randomSeed && (o.cacheFile != "" || o.checkpointFile != "") {
+		return usageError(tr("Error: --random-seed cannot be used with --cache or --checkpoint"))
+	}
+	if o.filesFrom != "" && (o.compare || o.watch || o.checkpointFile != "") {
+		return usageError(tr("Error: --files-from cannot be used with --compare, --watch or --checkpoint"))
+	}
+	if o.filesFrom == "-" && o.hhKey == "-" {
+		return usageError(tr("Error: --files-from - and --hh-key - cannot both be read from stdin"))
+	}
+	// Confirmation is read from stdin, so it cannot also hold the list.
+	if o.filesFrom == "-" && (o.interactive || len(o.actions) > 0 && !o.force && !o.dryRun) {
+		return usageError(tr("Error: --files-from - with an action requires --force or --dry-run, and cannot be used with --interactive"))
+	}
+	if o.compare && len(o.dirs) != 2 {
+		return usageError(tr("Error: --compare requires exactly two directories"))
+	}
+
+	if len(o.actions) > 1 {
+		return usageError(tr("Error: Only one of --delete, --trash, --hardlink, --symlink, --reflink and --move-to may be given"))
+	}
+	if o.interactive && len(o.actions) == 0 {
+		o.actions = append(o.actions, deleteAction)
+	}
+	o.keeper = dupes.KeepRules{Strategy: o.keep, Prefer: o.prefer, Avoid: o.avoid, KeepMatch: o.keepMatch, DeleteMatch: o.deleteMatch, Protect: o.protect}
+	if o.scriptFile != "" && (len(o.actions) != 1 || o.interactive || o.watch || o.trash || o.reflink) {
+		return usageError(tr("Error: --script requires one of --delete, --hardlink, --symlink and --move-to, and cannot be used with --trash, --reflink, --interactive or --watch"))
+	}
+	if o.auditLogFile != "" && len(o.actions) == 0 {
+		return usageError(tr("Error: --audit-log requires --delete, --trash, --hardlink, --symlink, --reflink, --move-to or --interactive"))
+	}
+	if o.metricsListen != "" && !o.watch && o.cmd != "daemon" {
+		return usageError(tr("Error: --metrics requires --watch or dupes daemon; dupes serve serves /metrics itself"))
+	}
+	if o.webhook != "" || len(o.emailTo) > 0 {
+		var err error
+		if o.notify, err = newNotifier(o.webhook, o.emailTo, o.emailFrom, o.smtpAddr, o.notifyOver); err != nil {
+			return usageError(tr("Error:") + " " + err.Error())
+		}
+	} else if o.notifyOver > 0 || o.emailFrom != "" || o.smtpAddr != "" {
+		return usageError(tr("Error: --notify-over, --email-from and --smtp require --webhook or --email"))
+	}
+	if o.cmd == "serve" || o.cmd == "daemon" {
+		o.showProgress = false
+	}
+	if o.cmd == "serve" && o.outputFormat != "" {
+		return usageError(tr("Error: dupes serve cannot be used with --format; GET /scans/<id>/groups returns the JSON report"))
+	}
+	if o.cmd == "daemon" {
+		if o.scheduleSpec == "" {
+			return usageError(tr("Error: dupes daemon requires --schedule, e.g. --schedule \"0 3 * * *\""))
+		}
+		var err error
+		if o.sched, err = parseSchedule(o.scheduleSpec); err == nil {
+			_, err = o.sched.next(time.Now())
+		}
+		if err != nil {
+			return usageError(tr("Error:") + " " + err.Error())
+		}
+		if o.outputFormat == "ndjson" || o.outputFormat != "" && (o.outputFile == "" || o.outputFile == "-") {
+			return usageError(tr("Error: dupes daemon writes each scan's report to the --output file, in any --format but ndjson"))
+		}
+		if o.cacheFile == "" && !o.randomSeed {
+			o.cacheFile = daemonCachePath()
+		}
+	}
+	if o.reclaim > 0 && (len(o.actions) == 0 || o.interactive || o.watch) {
+		return usageError(tr("Error: --reclaim requires --delete, --trash, --hardlink, --symlink, --reflink or --move-to, and cannot be used with --interactive or --watch"))
+	}
+	switch o.cmd {
+	case "verify":
+		if o.manifestFile == "" {
+			o.verify = true
+		}
+	case "snapshot":
+		if o.outputFile == "" || o.outputFile == "-" || o.outputFormat != "" || o.sqliteFile != "" {
+			return usageError(tr("Error: dupes snapshot requires -o with the file to save the snapshot in, and cannot be used with --format or --sqlite"))
+		}
+		o.sqliteFile, o.outputFile = o.outputFile, ""
+	case "manifest":
+		if o.chunkOver > 0 {
+			return usageError(tr("Error: dupes manifest cannot be used with --chunk-over"))
+		}
+		if strings.Contains(o.hashAlgorithm, "+") {
+			return usageError(tr("Error: dupes manifest requires a --hash of a single algorithm"))
+		}
+		// Like sha256sum, a manifest lists empty files too.
+		o.includeEmpty = true
+	case "agent":
+		// Its stderr is shown by the dupes that started it.
+		o.showProgress = false
+	case "clean":
+		if len(o.actions) == 0 {
+			return usageError(tr("Error: dupes clean requires --delete, --trash, --hardlink, --symlink, --reflink, --move-to or --interactive"))
+		}
+	}
+	if o.first && (len(o.actions) > 0 || o.compare || o.watch || o.manifestFile != "" || o.outputFormat == "ndjson") {
+		return usageError(tr("Error: --first cannot be used with an action, --compare, --watch, --manifest or --format ndjson"))
+	}
+	if o.manifestFile != "" {
+		if o.compare || o.watch || o.filesFrom != "" || o.checkpointFile != "" || o.outputFormat != "" || o.outputFile != "" || o.chunkOver > 0 {
+			return usageError(tr("Error: --manifest cannot be used with --compare, --watch, --files-from, --checkpoint, --format, --output or --chunk-over"))
+		}
+	}
+	for i := range o.actions {
+		a := &o.actions[i]
+		a.prompt, a.verb, a.noun, a.dryVerb = tr(a.prompt), tr(a.verb), tr(a.noun), tr(a.dryVerb)
+		if m, ok := a.action.(dupes.MoveAction); ok {
+			a.prompt = fmt.Sprintf(tr("Move %%d duplicate files to %s?"), strings.ReplaceAll(m.Dir, "%", "%%"))
+		}
+		if _, ok := o.actions[i].action.(dupes.SymlinkAction); ok {
+			o.actions[i].action = dupes.SymlinkAction{Relative: o.relativeLinks}
+		}
+		// A script checks each file as --dry-run does.
+		if o.dryRun && o.scriptFile == "" {
+			o.actions[i].action = dupes.DryRun{Action: o.actions[i].action}
+		}
+		if o.dryRun || o.scriptFile != "" {
+			o.actions[i].verb = o.actions[i].dryVerb
+		}
+	}
+
+	if o.writeAcceptList && o.acceptListFile == "" {
+		return usageError(tr("Error: --write-accept-list requires --accept-list"))
+	}
+
+	if print0 && o.outputFormat == "" {
+		o.outputFormat = "paths"
+	}
+	if print0 && o.outputFormat != "paths" {
+		return usageError(tr("Error: --print0 can only be used with --format paths"))
+	}
+	if normalizeUnicode && o.outputFormat == "paths" {
+		return usageError(tr("Error: --normalize-unicode cannot be used with --format paths, whose paths must be given as they are"))
+	}
+	if o.outputFile != "" && o.outputFormat == "" {
+		return usageError(tr("Error: --output requires --format"))
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// A reportWriter renders a report in a machine-readable format.
+type reportWriter interface {
+	writeReport(w io.Writer, r report) error
+}
+
+// reportFormats maps the names accepted by --format to their writers.
+// "text" and "ndjson" are accepted too, but are not written as a report.
+var reportFormats = map[string]reportWriter{
+	"json": jsonReport{},
+	"csv":  delimitedReport{comma: ','},
+	"tsv":  delimitedReport{comma: '\t'},
+
+	"markdown": markdownReport{},
+	"paths":    pathsReport{},
+}
+
+// groupOrders maps the names accepted by --sort to orderings of duplicate
+// groups: by wasted space or file count, largest first, or by the first
+// file's path or the hash.
+var groupOrders = map[string]func(a, b *dupe) bool{
+	"size":  func(a, b *dupe) bool { return a.WastedBytes > b.WastedBytes },
+	"count": func(a, b *dupe) bool { return len(a.Files) > len(b.Files) },
+	"path":  func(a, b *dupe) bool { return a.Files[0].Path < b.Files[0].Path },
+	"hash":  func(a, b *dupe) bool { return a.Hash < b.Hash },
+}
+
+// sortDupes orders found in place by the named --sort order, keeping the
+// walk order of groups that compare equal.
+func sortDupes(found []dupe, order string) {
+	less := groupOrders[order]
+	sort.SliceStable(found, func(i, j int) bool {
+		return less(&found[i], &found[j])
+	})
+}
+
+// writeReport renders r in the given format to out, or to the file at path
+// if out is nil.
+func writeReport(format string, r report, path string, out io.Writer) error {
+	if out == nil {
+		f, err := os.Create(path)
+		if err != nil {
+			logError("writing output file, please check permissions and that the directory exists", err)
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := reportFormats[format].writeReport(out, r); err != nil {
+		logError("writing "+format+" output", err)
+		return err
+	}
+	return nil
+}
+
+// jsonReport writes the whole report as a single JSON object.
+type jsonReport struct{}
+
+func (jsonReport) writeReport(w io.Writer, r report) error {
+	if r.Dupes == nil {
+		r.Dupes = []dupe{}
+	}
+	if r.Errors == nil {
+		r.Errors = []scanError{}
+	}
+
+	json_data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(json_data))
+	return err
+}
+
+// delimitedReport writes one row per duplicate file, for CSV and TSV output.
+type delimitedReport struct {
+	comma rune
+}
+
+func (d delimitedReport) writeReport(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = d.comma
+	if err := cw.Write([]string{"group_id", "hash", "size", "path"}); err != nil {
+		return err
+	}
+	for _, g := range r.Dupes {
+		for _, f := range g.Files {
+			row := []string{g.GroupID, g.Hash, strconv.FormatInt(f.Size, 10), f.Path}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	// CSV has no place for a summary, so a partial scan is noted in a
+	// comment line after the rows.
+	if note := partialNote(r); note != "" {
+		_, err := fmt.Fprintf(w, "# Partial results: %s.\n", note)
+		return err
+	}
+	return nil
+}
+
+// pathsReport writes only the paths of the duplicate files, one per line
+// with a blank line after each group, or, if --print0 was given, each
+// followed by a NUL byte with another after each group.
+type pathsReport struct{}
+
+func (pathsReport) writeReport(w io.Writer, r report) error {
+	end := "\n"
+	if print0 {
+		end = "\x00"
+	}
+	bw := bufio.NewWriter(w)
+	for _, d := range r.Dupes {
+		for _, f := range d.Files {
+			bw.WriteString(f.Path + end)
+		}
+		bw.WriteString(end)
+	}
+	return bw.Flush()
+}
+
+// markdownReport writes a summary table and a collapsible section per
+// duplicate group, for pasting into an issue or wiki page.
+type markdownReport struct{}
+
+func (markdownReport) writeReport(w io.Writer, r report) error {
+	var b strings.Builder
+	b.WriteString("# Duplicate files\n\n")
+	if note := partialNote(r); note != "" {
+		fmt.Fprintf(&b, "**Partial results: %s.**\n\n", note)
+	}
+	sum := r.Summary
+	b.WriteString("| Summary | |\n| --- | --- |\n")
+	fmt.Fprintf(&b, "| Files scanned | %d (%s) |\n", sum.FilesScanned, formatBytes(sum.BytesScanned))
+	fmt.Fprintf(&b, "| Duplicate groups | %d |\n", sum.DuplicateGroups)
+	fmt.Fprintf(&b, "| Duplicate files | %d |\n", sum.DuplicateFiles)
+	fmt.Fprintf(&b, "| Wasted space | %s (%d bytes) |\n", formatBytes(sum.WastedBytes), sum.WastedBytes)
+	if sum.Partial {
+		fmt.Fprintf(&b, "| Candidates hashed | %d of %d (%.2f%%) |\n", sum.HashedFiles, sum.CandidateFiles, sum.hashedPercent())
+	}
+	if sum.SuppressedGroups > 0 {
+		fmt.Fprintf(&b, "| Suppressed by accept list | %d |\n", sum.SuppressedGroups)
+	}
+	if r.ErrorsTotal > 0 {
+		fmt.Fprintf(&b, "| Skipped files | %d |\n", r.ErrorsTotal)
+	}
+	fmt.Fprintf(&b, "| Hash | %s |\n", r.HashAlgorithm)
+	if c := r.HashChunking; c != nil {
+		fmt.Fprintf(&b, "| Hash chunking | %s chunks of files over %s |\n", formatBytes(c.ChunkSize), formatBytes(c.Threshold))
+	}
+
+	if len(r.Dupes) > 0 {
+		b.WriteString("\n## Duplicate groups\n")
+	}
+	for _, d := range r.Dupes {
+		fmt.Fprintf(&b, "\n<details>\n<summary>%s: %d files of %s, %s reclaimable</summary>\n\n",
+			d.GroupID, len(d.Files), formatBytes(d.Size), formatBytes(d.WastedBytes))
+		fmt.Fprintf(&b, "Hash: %s\n\n", markdownCode(d.Hash))
+		for _, f := range d.Files {
+			fmt.Fprintf(&b, "- %s", markdownCode(f.Path))
+			if f.Reference {
+				b.WriteString(" (reference)")
+			} else if f.Archive != "" {
+				b.WriteString(" (in archive)")
+			}
+			b.WriteString("\n")
+			for _, link := range f.Links {
+				fmt.Fprintf(&b, "  - same file: %s\n", markdownCode(link))
+			}
+		}
+		b.WriteString("\n</details>\n")
+	}
+
+	if len(r.WasteByDir) > 0 {
+		b.WriteString("\n## Reclaimable space by directory\n\n| Directory | Files | Reclaimable | Share |\n| --- | --- | --- | --- |\n")
+		for _, d := range r.WasteByDir {
+			fmt.Fprintf(&b, "| %s | %d | %s | %.1f%% |\n", strings.ReplaceAll(markdownCode(d.Dir), "|", `\|`), d.Files, formatBytes(d.WastedBytes), d.Share)
+		}
+	}
+
+	if len(r.DuplicateDirs) > 0 {
+		b.WriteString("\n## Duplicate directories\n")
+		for _, d := range r.DuplicateDirs {
+			fmt.Fprintf(&b, "\n<details>\n<summary>%d directories of %d files, %s reclaimable</summary>\n\n",
+				len(d.Dirs), d.Files, formatBytes(d.WastedBytes))
+			for _, dir := range d.Dirs {
+				fmt.Fprintf(&b, "- %s\n", markdownCode(dir))
+			}
+			b.WriteString("\n</details>\n")
+		}
+	}
+
+	if len(r.EmptyFiles) > 0 || len(r.EmptyDirs) > 0 {
+		b.WriteString("\n## Empty files and directories\n\n")
+		for _, f := range r.EmptyFiles {
+			fmt.Fprintf(&b, "- %s\n", markdownCode(f))
+		}
+		for _, d := range r.EmptyDirs {
+			fmt.Fprintf(&b, "- %s\n", markdownCode(d+string(filepath.Separator)))
+		}
+	}
+
+	if len(r.Errors) > 0 {
+		b.WriteString("\n## Skipped files\n\n")
+		for _, e := range r.Errors {
+			fmt.Fprintf(&b, "- %s: %s: %s\n", markdownCode(e.Path), e.Operation, e.Reason)
+		}
+		if r.ErrorsTruncated {
+			fmt.Fprintf(&b, "- ... and %d more (see `--max-errors-reported`)\n", r.ErrorsTotal-int64(len(r.Errors)))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// partialNote says why the results in r are partial, or returns "" if the
+// scan was complete.
+func partialNote(r report) string {
+	sum := r.Summary
+	switch {
+	case r.Interrupted:
+		return "the scan was interrupted"
+	case sum.Limit == "first":
+		return "the scan stopped at the first duplicate found"
+	case sum.Partial:
+		return fmt.Sprintf("the scan was stopped by the --%s limit after hashing %d of %d candidate files",
+			sum.Limit, sum.HashedFiles, sum.CandidateFiles)
+	}
+	return ""
+}
+
+// markdownCode formats s as inline code, fenced with more backticks than
+// it contains in a row so that it is shown verbatim.
+func markdownCode(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// ndjsonStream writes each duplicate group to w as a line of JSON as soon as
+// the scanner reports it, leaving out groups on the accept list, and each
+// file that could not be scanned, up to max of them. Each record's type
+// field tells them apart. The first write error stops the stream and is
+// kept in err.
+type ndjsonStream struct {
+	w        io.Writer
+	accepted acceptList
+	max      int
+	err      error
+	// errors is the number of error records written, and dropped the
+	// number over max left out since the last truncated record.
+	errors  int
+	dropped int64
+}
+
+func (n *ndjsonStream) group(g dupes.DupeGroup) {
+	found := toDupes([]dupes.DupeGroup{g})
+	if n.accepted != nil {
+		found, _ = n.accepted.filter(found)
+	}
+	for _, d := range found {
+		n.write(struct {
+			Type string `json:"type"`
+			dupe
+		}{"group", d})
+	}
+}
+
+// fileError writes a record of the file at path, which was skipped because
+// op failed, in the shape of an entry of the JSON report's errors.
+func (n *ndjsonStream) fileError(path string, op string, err error) {
+	if n.errors >= n.max {
+		n.dropped++
+		return
+	}
+	n.errors++
+	reason := err
+	if pe, ok := err.(*os.PathError); ok {
+		reason = pe.Err
+	}
+	n.write(struct {
+		Type string `json:"type"`
+		scanError
+	}{"error", scanError{Path: path, Operation: op, Error: err.Error(), Reason: reason.Error()}})
+}
+
+// finish writes a truncated record if error records have been left out
+// since the last one, with the number of them.
+func (n *ndjsonStream) finish() {
+	if n.dropped == 0 {
+		return
+	}
+	n.write(struct {
+		Type    string `json:"type"`
+		Omitted int64  `json:"errors_omitted"`
+	}{"truncated", n.dropped})
+	n.dropped = 0
+}
+
+// summary writes the summary of a scan as the last record of its results,
+// with whether it was interrupted, so that partial results can be told
+// from complete ones.
+func (n *ndjsonStream) summary(sum summary, interrupted bool) {
+	n.write(struct {
+		Type        string `json:"type"`
+		Interrupted bool   `json:"interrupted"`
+		summary
+	}{"summary", interrupted, sum})
+}
+
+// write writes v as a line of JSON, unless an earlier write failed.
+func (n *ndjsonStream) write(v interface{}) {
+	if n.err != nil {
+		return
+	}
+	json_data, err := json.Marshal(v)
+	if err != nil {
+		n.err = err
+		return
+	}
+	_, n.err = fmt.Fprintln(n.w, string(json_data))
+}
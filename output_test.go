@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+func TestPartialReport(t *testing.T) {
+	stats := dupes.Stats{Files: 10, Candidates: 8, Hashed: 3, Limit: dupes.ErrMaxFiles}
+	r := report{Summary: newSummary(stats, nil, 0)}
+	sum := r.Summary
+	if !sum.Partial || sum.Limit != "max-files" || sum.HashedFiles != 3 || sum.CandidateFiles != 8 {
+		t.Fatalf("newSummary = %+v; want a partial summary stopped by max-files", sum)
+	}
+
+	var b bytes.Buffer
+	if err := (jsonReport{}).writeReport(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Summary map[string]interface{} `json:"summary"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{"partial": true, "limit": "max-files", "hashed": 3.0, "candidates": 8.0} {
+		if got := decoded.Summary[key]; got != want {
+			t.Errorf("JSON summary %s = %v; want %v", key, got, want)
+		}
+	}
+
+	note := "Partial results: the scan was stopped by the --max-files limit after hashing 3 of 8 candidate files."
+	for name, w := range map[string]reportWriter{"csv": delimitedReport{comma: ','}, "markdown": markdownReport{}} {
+		b.Reset()
+		if err := w.writeReport(&b, r); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), note) {
+			t.Errorf("%s report lacks the partial note:\n%s", name, b.String())
+		}
+	}
+
+	b.Reset()
+	n := &ndjsonStream{w: &b}
+	n.summary(sum, false)
+	if got := b.String(); !strings.HasPrefix(got, `{"type":"summary","interrupted":false,`) || !strings.Contains(got, `"partial":true,"limit":"max-files"`) {
+		t.Errorf("ndjson summary record = %s", got)
+	}
+}
@@ -0,0 +1,197 @@
+package dupes
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Errors returned by actions that skip a duplicate rather than fail on it.
+var (
+	ErrCrossDevice     = errors.New("dupes: duplicate is on a different filesystem")
+	ErrSameFile        = errors.New("dupes: duplicate is already the same file")
+	ErrInArchive       = errors.New("dupes: kept file is inside an archive")
+	ErrLinkUnsupported = errors.New("dupes: filesystem does not support hard links")
+)
+
+// An Action resolves duplicates by acting on every file of a group except
+// the one that is kept.
+type Action interface {
+	// Apply acts on dupe, which has the same content as keep.
+	Apply(keep File, dupe File) error
+}
+
+// ActionResult describes the outcome of applying an Action to one file.
+type ActionResult struct {
+	Group *DupeGroup
+	Keep  File
+	Dupe  File
+	// Link is true if Dupe is one of the Links of a duplicate rather than
+	// a member of the group itself.
+	Link bool
+	Err  error
+}
+
+// Resolve applies a to every file in each group except the one chosen by
+// keep, and passes the outcome of each to report. Reference files and
+// archive members are never acted on, and one of them is kept if the group
+// has any; nor are the files a Sparer spares. A duplicate's hard
+// links are acted on too, since its storage is only reclaimed once none
+// remain; the kept file's links are left alone. KeepRules spares a
+// duplicate with all its links if any of them is protected.
+func Resolve(groups []DupeGroup, keep Keeper, a Action, report func(ActionResult)) {
+	sparer, _ := keep.(Sparer)
+	for i := range groups {
+		g := &groups[i]
+		k := keep.ChooseGroup(g)
+		for j, f := range g.Files {
+			if j == k || f.Reference || f.Archive != "" || sparer != nil && sparer.Spare(f) {
+				continue
+			}
+			err := a.Apply(g.Files[k], f)
+			report(ActionResult{Group: g, Keep: g.Files[k], Dupe: f, Err: err})
+			for _, link := range f.Links {
+				l := File{Path: link, Root: f.Root, Size: f.Size, ModTime: f.ModTime}
+				err := a.Apply(g.Files[k], l)
+				report(ActionResult{Group: g, Keep: g.Files[k], Dupe: l, Link: true, Err: err})
+			}
+		}
+	}
+}
+
+// DeleteAction removes duplicate files.
+type DeleteAction struct{}
+
+func (DeleteAction) Apply(keep File, dupe File) error {
+	if err := checkNotSameFile(keep, dupe); err != nil {
+		return err
+	}
+	return os.Remove(dupe.Path)
+}
+
+// DryRun wraps an Action so that it only checks each duplicate is eligible,
+// without changing the filesystem.
+type DryRun struct {
+	Action Action
+}
+
+func (d DryRun) Apply(keep File, dupe File) error {
+	switch d.Action.(type) {
+	case HardlinkAction, SymlinkAction, ReflinkAction:
+		if keep.Archive != "" {
+			return ErrInArchive
+		}
+	}
+	return checkNotSameFile(keep, dupe)
+}
+
+// HardlinkAction replaces duplicate files with hard links to the kept file.
+// Duplicates on a different filesystem are skipped with ErrCrossDevice, and
+// those on a filesystem without hard links with ErrLinkUnsupported. On
+// Windows, the links are NTFS hard links.
+type HardlinkAction struct{}
+
+func (HardlinkAction) Apply(keep File, dupe File) error {
+	if keep.Archive != "" {
+		return ErrInArchive
+	}
+	if err := checkNotSameFile(keep, dupe); err != nil {
+		return err
+	}
+	err := replace(dupe.Path, func(tmp string) error {
+		return os.Link(keep.Path, tmp)
+	})
+	switch {
+	case isCrossDevice(err):
+		return ErrCrossDevice
+	case isLinkUnsupported(err):
+		return ErrLinkUnsupported
+	}
+	return err
+}
+
+// SymlinkAction replaces duplicate files with symbolic links to the kept
+// file. The links are relative to the duplicate's directory if Relative is
+// set, and absolute otherwise.
+type SymlinkAction struct {
+	Relative bool
+}
+
+func (s SymlinkAction) Apply(keep File, dupe File) error {
+	if keep.Archive != "" {
+		return ErrInArchive
+	}
+	if err := checkNotSameFile(keep, dupe); err != nil {
+		return err
+	}
+	target, err := s.Target(keep, dupe)
+	if err != nil {
+		return err
+	}
+	return replace(dupe.Path, func(tmp string) error {
+		return os.Symlink(target, tmp)
+	})
+}
+
+// Target returns the path the symbolic link replacing dupe points at.
+func (s SymlinkAction) Target(keep File, dupe File) (string, error) {
+	target, err := filepath.Abs(keep.Path)
+	if err != nil {
+		return "", err
+	}
+	if !s.Relative {
+		return target, nil
+	}
+	dupePath, err := filepath.Abs(dupe.Path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(filepath.Dir(dupePath), target)
+}
+
+// checkNotSameFile returns ErrSameFile if keep and dupe already refer to the
+// same underlying file, e.g. because they are hard links to each other or
+// one is a symbolic link to the other. Acting on such a duplicate would
+// either reclaim nothing or destroy the only copy.
+func checkNotSameFile(keep File, dupe File) error {
+	if keep.Archive != "" {
+		// The kept copy is inside an archive, so cannot be dupe itself.
+		_, err := os.Stat(dupe.Path)
+		return err
+	}
+	keepInfo, err := os.Stat(keep.Path)
+	if err != nil {
+		return err
+	}
+	dupeInfo, err := os.Stat(dupe.Path)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(keepInfo, dupeInfo) {
+		return ErrSameFile
+	}
+	return nil
+}
+
+// replace atomically replaces path with the file create makes at a temporary
+// path in the same directory. If create fails, path is left untouched.
+func replace(path string, create func(tmp string) error) error {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		tmp := filepath.Join(dir, fmt.Sprintf(".%s.dupes-%d-%d", base, os.Getpid(), i))
+		err := create(tmp)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package dupes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveProtectedLinks(t *testing.T) {
+	root := writeTree(t, map[string]string{"a/x": "same", "b/x": "same"})
+	protected := filepath.Join(root, "protected")
+	if err := os.Mkdir(protected, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "b", "x"), filepath.Join(protected, "x")); err != nil {
+		t.Skip("hard links are not supported here:", err)
+	}
+
+	var s Scanner
+	groups, err := s.Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("Scan found %d groups; want 1", len(groups))
+	}
+	// b/x is spared with protected/x, the link it shares its storage with,
+	// so a/x is the only file deleted.
+	rules := KeepRules{Strategy: KeepFirst, Protect: []string{protected}}
+	var acted []string
+	Resolve(groups, rules, DeleteAction{}, func(r ActionResult) {
+		if r.Err != nil {
+			t.Errorf("deleting %s: %v", r.Dupe.Path, r.Err)
+		}
+		acted = append(acted, r.Dupe.Path)
+	})
+	if want := filepath.Join(root, "a", "x"); len(acted) != 1 || acted[0] != want {
+		t.Errorf("Resolve acted on %q; want only %s", acted, want)
+	}
+	for _, path := range []string{filepath.Join(root, "b", "x"), filepath.Join(protected, "x")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was not kept: %v", path, err)
+		}
+	}
+
+	// A group whose every file is linked to a protected path has nothing to
+	// act on.
+	groups[0].Files[0].Links = append(groups[0].Files[0].Links, filepath.Join(protected, "y"))
+	if !rules.AllProtected(&groups[0]) {
+		t.Error("AllProtected = false; want true when every file has a protected link")
+	}
+}
@@ -0,0 +1,219 @@
+package dupes
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix of the roots that name a directory on another machine scanned by
+// dupes agent there, as in "agent://backup@dc2/srv/photos". A path
+// starting with /~/ is relative to the user's home directory.
+const AGENT_SCHEME = "agent://"
+
+// Types of the records of an agent's stream: the start, giving the hash
+// its files were hashed with, a file, a file that could not be scanned, and
+// the end, after every file.
+const (
+	AGENT_START = "start"
+	AGENT_FILE  = "file"
+	AGENT_ERROR = "error"
+	AGENT_END   = "end"
+)
+
+// AgentRecord is a line of the JSON stream dupes agent writes to its
+// stdout, and an agent:// root reads. Only the fields of its Type are set.
+type AgentRecord struct {
+	Type string `json:"type"`
+	// HashScheme is the agent's Scanner.HashScheme, and EmptyHash the hash
+	// it gives an empty file, which also tells apart HighwayHash keys.
+	HashScheme string `json:"hash_scheme,omitempty"`
+	EmptyHash  string `json:"empty_hash,omitempty"`
+	// Path is the path of a file, or of one that could not be scanned, as
+	// the agent found it.
+	Path    string   `json:"path,omitempty"`
+	Size    int64    `json:"size,omitempty"`
+	ModTime string   `json:"mtime,omitempty"`
+	Hash    string   `json:"hash,omitempty"`
+	Links   []string `json:"links,omitempty"`
+	// Op and Error describe why a file could not be scanned.
+	Op    string `json:"op,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Files is the number of files sent, in the end record.
+	Files int64 `json:"files,omitempty"`
+}
+
+// errAgentFile is returned when a file scanned by an agent is opened.
+var errAgentFile = errors.New("dupes: only the hash of a file scanned by an agent is known")
+
+// AgentStart returns the start record of an agent hashing with s.
+func (s *Scanner) AgentStart() (AgentRecord, error) {
+	hashes, err := s.newHashes()
+	if err != nil {
+		return AgentRecord{}, err
+	}
+	var empty strings.Builder
+	for _, h := range hashes {
+		empty.WriteString(hexSum(h))
+	}
+	return AgentRecord{Type: AGENT_START, HashScheme: s.HashScheme(), EmptyHash: empty.String()}, nil
+}
+
+// AgentFile returns the record of the file f, with hash sum, as HashEvery
+// passes them.
+func AgentFile(f File, sum string) AgentRecord {
+	return AgentRecord{Type: AGENT_FILE, Path: f.Path, Size: f.Size, ModTime: f.ModTime.UTC().Format(time.RFC3339Nano), Hash: sum, Links: f.Links}
+}
+
+// agentArgs returns the command run over ssh for an agent:// root:
+// DUPES_AGENT_COMMAND, or dupes agent, with the options that make it hash
+// as s does, and dir, quoted for the remote shell. A HighwayKey is given as
+// --hh-key -, for the agent to read from its stdin, so that it does not
+// show in the command line of the remote process.
+func (s *Scanner) agentArgs(dir string) []string {
+	args := []string{"dupes", "agent"}
+	if command := strings.Fields(os.Getenv("DUPES_AGENT_COMMAND")); len(command) > 0 {
+		args = command
+	}
+	args = append(args, "--hash", s.hashAlgorithm())
+	if s.ChunkThreshold > 0 {
+		args = append(args, "--chunk-over", strconv.FormatInt(s.ChunkThreshold, 10), "--chunk-size", strconv.FormatInt(s.chunkSize(), 10))
+	}
+	if s.HighwayKey != nil {
+		args = append(args, "--hh-key", "-")
+	}
+	if s.IncludeEmpty {
+		args = append(args, "--include-empty")
+	}
+	return append(args, "--", shellQuote(dir))
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// agentPath returns the path on the coordinator of the file an agent on
+// the host of archive found at p: absolute, or relative to the home
+// directory.
+func agentPath(archive string, p string) string {
+	if strings.HasPrefix(p, "/") {
+		return archive + p
+	}
+	return archive + "/~/" + p
+}
+
+// walkAgent adds the files under the agent root to the walk, as the agent
+// that dupes runs on its host over ssh lists them with their hashes. They
+// are found as archive members are, the host standing for the archive,
+// and filtered by their paths relative to the root; their hashes are known,
+// so they are never read, and only this metadata crosses the network.
+func (w *walker) walkAgent(root string) error {
+	s := w.s
+	archive, dir, err := parseRemoteURL(root, AGENT_SCHEME)
+	if err != nil {
+		return err
+	}
+	dir = strings.TrimPrefix(dir, "./")
+	cmd, err := sshCommand(archive, nil, s.agentArgs(dir)...)
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if s.HighwayKey != nil {
+		cmd.Stdin = strings.NewReader(hex.EncodeToString(s.HighwayKey) + "\n")
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("dupes: starting the agent for %s: %v", root, err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	start, err := s.AgentStart()
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReaderSize(out, 64<<10))
+	var r AgentRecord
+	if err := dec.Decode(&r); err != nil {
+		return fmt.Errorf("dupes: the agent for %s did not start: %v", root, agentStopped(cmd, err))
+	}
+	if r.Type != AGENT_START {
+		return fmt.Errorf("dupes: the agent for %s sent a %q record first", root, r.Type)
+	}
+	if r.HashScheme != start.HashScheme {
+		return fmt.Errorf("dupes: the agent for %s hashes with %s, not %s", root, r.HashScheme, start.HashScheme)
+	}
+	if r.EmptyHash != start.EmptyHash {
+		return fmt.Errorf("dupes: the agent for %s uses another HighwayHash key", root)
+	}
+	var files int64
+	for {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		r = AgentRecord{}
+		if err := dec.Decode(&r); err != nil {
+			return fmt.Errorf("dupes: the agent for %s stopped before finishing: %v", root, agentStopped(cmd, err))
+		}
+		switch r.Type {
+		case AGENT_END:
+			if r.Files != files {
+				return fmt.Errorf("dupes: the agent for %s sent %d files of %d", root, files, r.Files)
+			}
+			return nil
+		case AGENT_ERROR:
+			s.stats.Entries++
+			s.skip(agentPath(archive, r.Path), r.Op, errors.New(r.Error))
+			continue
+		case AGENT_FILE:
+		default:
+			// Records of types added by later versions.
+			continue
+		}
+		files++
+		s.stats.Entries++
+		if s.Progress != nil {
+			s.Progress(s.stats)
+		}
+		modTime, _ := time.Parse(time.RFC3339Nano, r.ModTime)
+		rel := strings.TrimPrefix(r.Path, strings.TrimSuffix(dir, "/")+"/")
+		if !s.memberIncluded(&archiveMember{name: rel, size: r.Size}, modTime) {
+			continue
+		}
+		m := &archiveMember{archive: archive, name: r.Path, kind: archiveAgent, size: r.Size, hash: r.Hash}
+		w.addMember(root, agentPath(archive, r.Path), m, modTime)
+		added := &w.files[len(w.files)-1].file
+		for _, link := range r.Links {
+			added.Links = append(added.Links, agentPath(archive, link))
+			s.stats.Files++
+			s.stats.Bytes += r.Size
+			s.stats.Links++
+		}
+	}
+}
+
+// agentStopped returns why the agent run by cmd stopped writing records,
+// having failed to read one with err: its exit status, if it has exited.
+func agentStopped(cmd *exec.Cmd, err error) error {
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if waitErr := cmd.Wait(); waitErr != nil {
+		return waitErr
+	}
+	return err
+}
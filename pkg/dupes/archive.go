@@ -0,0 +1,397 @@
+package dupes
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"math"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Separator between the path of an archive and the path of a member within
+// it, as in "photos.zip!2019/beach.jpg".
+const ARCHIVE_SEPARATOR = "!"
+
+// Kinds of archive whose members can be scanned.
+const (
+	archiveZip = iota
+	archiveTar
+	archiveTarGz
+	// archiveS3 is an object in an S3 bucket, the bucket standing for
+	// the archive; see walkS3.
+	archiveS3
+	// archiveSFTP is a file on another host, read over SFTP, the host
+	// standing for the archive; see walkSFTP.
+	archiveSFTP
+	// archiveAgent is a file on another host hashed by an agent there,
+	// which cannot be read here; see walkAgent.
+	archiveAgent
+)
+
+// archiveKind returns the kind of archive at path, judged by its name.
+func archiveKind(p string) (int, bool) {
+	name := strings.ToLower(p)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip, true
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar, true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz, true
+	}
+	return 0, false
+}
+
+// archiveMember locates a file inside an archive. Members of uncompressed
+// tars are read in place from offset; members of compressed tars cannot be
+// reached without decompressing everything before them, so they are hashed
+// while the archive is listed, and hash holds the result. S3 objects keep
+// their ETag, for matchETags. The layers of a docker save tarball are tars
+// within it, so their members also have the start and length of the layer
+// in archive; length is 0 for a tar that is the whole file.
+type archiveMember struct {
+	archive string
+	name    string
+	kind    int
+	offset  int64
+	size    int64
+	hash    string
+	etag    string
+	start   int64
+	length  int64
+}
+
+// archives holds the state of the Scanner's archive members: where each is
+// found, by path, and the zip archives opened to read them, the client S3
+// objects are read with, the SFTP sessions with remote hosts, and the
+// images saved from Docker to temporary files.
+type archives struct {
+	mu      sync.Mutex
+	members map[string]*archiveMember
+	zips    map[string]*zipArchive
+	s3      *s3Client
+	sftp    map[string]*sftpConn
+	temps   []string
+}
+
+// zipArchive is an open zip archive and its members by name.
+type zipArchive struct {
+	r     *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+// member returns the archive member at path, or nil if path is not one.
+func (a *archives) member(path string) *archiveMember {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.members[path]
+}
+
+// close closes every zip archive opened, ends the SFTP sessions, and
+// removes the saved images.
+func (a *archives) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, z := range a.zips {
+		z.r.Close()
+	}
+	a.zips = nil
+	for _, c := range a.sftp {
+		c.close()
+	}
+	a.sftp = nil
+	for _, p := range a.temps {
+		os.Remove(p)
+	}
+	a.temps = nil
+}
+
+// openZip returns the open zip archive at path, opening it if need be.
+func (a *archives) openZip(path string) (*zipArchive, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if z, ok := a.zips[path]; ok {
+		return z, nil
+	}
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	z := &zipArchive{r: r, files: make(map[string]*zip.File)}
+	for _, f := range r.File {
+		z.files[f.Name] = f
+	}
+	if a.zips == nil {
+		a.zips = make(map[string]*zipArchive)
+	}
+	a.zips[path] = z
+	return z, nil
+}
+
+// open opens the file at path for reading, whether it is an ordinary file,
+// a member of an archive if Archives is set, or an S3 object or SFTP file.
+func (s *Scanner) open(path string) (io.ReadCloser, error) {
+	m := s.archives.member(path)
+	if m == nil {
+		return os.Open(path)
+	}
+	switch m.kind {
+	case archiveZip:
+		z, err := s.archives.openZip(m.archive)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := z.files[m.name]
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return f.Open()
+	case archiveTar:
+		f, err := os.Open(m.archive)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, m.offset, m.size), f}, nil
+	case archiveS3:
+		return s.openS3Object(m)
+	case archiveSFTP:
+		return s.openSFTP(m)
+	case archiveAgent:
+		return nil, &os.PathError{Op: "open", Path: path, Err: errAgentFile}
+	}
+	return openTarGzMember(m)
+}
+
+// openTarGzMember opens a member of a compressed tar by reading the
+// archive up to it.
+func openTarGzMember(m *archiveMember) (io.ReadCloser, error) {
+	f, err := os.Open(m.archive)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	if m.length > 0 {
+		r = io.NewSectionReader(f, m.start, m.length)
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			f.Close()
+			if err == io.EOF {
+				err = os.ErrNotExist
+			}
+			return nil, &os.PathError{Op: "open", Path: m.archive + ARCHIVE_SEPARATOR + m.name, Err: err}
+		}
+		if hdr.Name == m.name {
+			return struct {
+				io.Reader
+				io.Closer
+			}{tr, f}, nil
+		}
+	}
+}
+
+// walkArchive adds the regular files inside the archive at path, found
+// under root, to the walk.
+func (w *walker) walkArchive(root string, path string, kind int) {
+	s := w.s
+	add := func(m *archiveMember, fi os.FileInfo) {
+		if s.memberIncluded(m, fi.ModTime()) {
+			w.addMember(root, path+ARCHIVE_SEPARATOR+m.name, m, fi.ModTime())
+		}
+	}
+
+	var err error
+	switch kind {
+	case archiveZip:
+		err = w.walkZip(path, add)
+	default:
+		err = w.walkTar(path, kind, add)
+	}
+	if err != nil {
+		s.skip(path, "read", err)
+	}
+}
+
+// addMember adds the archive member m, found under root, to the walk as
+// the file at path.
+func (w *walker) addMember(root string, path string, m *archiveMember, modTime time.Time) {
+	s := w.s
+	if w.full() {
+		return
+	}
+	s.archives.mu.Lock()
+	s.archives.members[path] = m
+	s.archives.mu.Unlock()
+	s.stats.Files++
+	s.stats.Bytes += m.size
+	s.stats.Members++
+	w.files = append(w.files, candidate{
+		seq: s.stats.Files,
+		file: File{Path: path, Root: root, Size: m.size, ModTime: modTime,
+			Reference: w.reference, Archive: m.archive},
+	})
+	w.full()
+}
+
+// walkZip lists the members of the zip archive at path.
+func (w *walker) walkZip(p string, add func(*archiveMember, os.FileInfo)) error {
+	z, err := zip.OpenReader(p)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		add(&archiveMember{archive: p, name: f.Name, kind: archiveZip, size: int64(f.UncompressedSize64)}, f.FileInfo())
+	}
+	return nil
+}
+
+// walkTar lists the members of the tar at path, hashing them as it goes if
+// the tar is compressed.
+func (w *walker) walkTar(p string, kind int, add func(*archiveMember, os.FileInfo)) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return w.walkTarSection(f, 0, 0, kind, func(m *archiveMember, hdr *tar.Header) {
+		add(m, hdr.FileInfo())
+	})
+}
+
+// walkTarSection lists the members of the tar stored in f from start for
+// length bytes, or to the end if length is 0, as walkTar does.
+func (w *walker) walkTarSection(f *os.File, start int64, length int64, kind int, add func(*archiveMember, *tar.Header)) error {
+	n := length
+	if n == 0 {
+		n = math.MaxInt64 - start
+	}
+	section := io.NewSectionReader(f, start, n)
+	var r io.Reader = section
+	if kind == archiveTarGz {
+		gz, err := gzip.NewReader(section)
+		if err != nil {
+			return err
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		if err := w.ctx.Err(); err != nil {
+			return nil
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || isSparse(hdr) {
+			continue
+		}
+		m := &archiveMember{archive: f.Name(), name: hdr.Name, kind: kind, size: hdr.Size, start: start, length: length}
+		if kind == archiveTar {
+			// The tar reader reads whole headers and nothing more, so the
+			// member's data starts at the current offset.
+			if m.offset, err = section.Seek(0, io.SeekCurrent); err != nil {
+				return err
+			}
+			m.offset += start
+		} else if w.s.memberIncluded(m, hdr.ModTime) {
+			if w.s.chunked(m.size) {
+				m.hash, err = w.s.hashChunkedReader(tr, m.size)
+			} else {
+				m.hash, err = w.s.hashReader(tr)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		add(m, hdr)
+	}
+}
+
+// hiddenMember reports whether the archive member called name is, or is
+// inside, a directory or file whose name starts with a dot.
+func hiddenMember(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isSparse reports whether a tar member is stored as a sparse file, whose
+// data cannot be read in place.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// memberIncluded reports whether an archive member last modified at
+// modTime passes the filters. Exclude patterns apply to its path within the
+// archive, less any leading ./, and its MIME type is judged by its
+// extension alone.
+func (s *Scanner) memberIncluded(m *archiveMember, modTime time.Time) bool {
+	name := strings.TrimPrefix(m.name, "./")
+	if !s.sizeIncluded(m.size) || !s.extIncluded(name) || !s.timeIncluded(modTime) {
+		return false
+	}
+	if s.SkipHidden && hiddenMember(name) {
+		return false
+	}
+	if len(s.MIME) > 0 && !s.mimeMatches(strings.SplitN(mime.TypeByExtension(path.Ext(name)), ";", 2)[0]) {
+		return false
+	}
+	return !s.memberExcluded(name)
+}
+
+// memberExcluded reports whether an Exclude pattern matches the archive
+// member, or remote file or directory, at name, relative to its archive or
+// root. Patterns without a slash match its base name.
+func (s *Scanner) memberExcluded(name string) bool {
+	for _, p := range s.Exclude {
+		match := path.Base(name)
+		if strings.Contains(p, "/") {
+			match = name
+		}
+		if ok, _ := path.Match(p, match); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package dupes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default similarity, from 0 to 1, above which SimilarAudio groups two
+// recordings: the fraction of fingerprint bits that agree.
+const DEFAULT_AUDIO_SIMILARITY = 0.85
+
+// Seconds of each recording SimilarAudio fingerprints, and by how many
+// seconds the durations of two recordings may differ for them to match.
+const (
+	audioFingerprintLength = 120
+	audioDurationSlack     = 3
+)
+
+// Fingerprint items by which one recording may be shifted against another
+// when comparing them, to allow for differing amounts of leading silence.
+// Each item covers about an eighth of a second.
+const audioMaxShift = 16
+
+// audioExts lists the extensions of the audio files SimilarAudio
+// fingerprints.
+var audioExts = map[string]bool{
+	".mp3": true, ".flac": true, ".ogg": true, ".oga": true, ".opus": true, ".m4a": true,
+	".aac": true, ".wav": true, ".aif": true, ".aiff": true, ".wma": true, ".ape": true,
+}
+
+// ErrNoFpcalc is returned by SimilarAudio if Chromaprint's fpcalc tool is
+// not installed.
+var ErrNoFpcalc = errors.New("dupes: fpcalc not found; install Chromaprint to compare audio")
+
+// AudioGroup is a set of audio files that sound alike although their
+// content differs, such as the same song encoded as MP3 and FLAC, or with
+// different tags.
+type AudioGroup struct {
+	Files []File
+	// Similarity is the lowest similarity, from 0 to 1, between two
+	// recordings of the group.
+	Similarity float64
+}
+
+// audioPrint is the Chromaprint fingerprint of a recording.
+type audioPrint struct {
+	Duration    float64  `json:"duration"`
+	Fingerprint []uint32 `json:"fingerprint"`
+}
+
+// SimilarAudio returns the groups of audio files found by the most recent
+// scan whose acoustic fingerprints are at least minSimilarity alike, in walk
+// order. Fingerprints are computed by Chromaprint's fpcalc tool, which must
+// be on the PATH. Groups whose files are all exact duplicates of each other
+// are left out, as Scan already reports them. Files fpcalc cannot decode
+// are reported to OnError and skipped.
+func (s *Scanner) SimilarAudio(ctx context.Context, minSimilarity float64) ([]AudioGroup, error) {
+	fpcalc, err := exec.LookPath("fpcalc")
+	if err != nil {
+		return nil, ErrNoFpcalc
+	}
+
+	var tracks []candidate
+	for _, c := range s.files {
+		if c.file.Archive == "" && audioExts[strings.ToLower(filepath.Ext(c.file.Path))] {
+			tracks = append(tracks, c)
+		}
+	}
+	queue := make(chan candidate)
+	go func() {
+		defer close(queue)
+		for _, c := range tracks {
+			select {
+			case queue <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	prints := make(map[int64]audioPrint)
+	fingerprint := func(c candidate) hashResult {
+		out, err := exec.CommandContext(ctx, fpcalc, "-raw", "-json", "-length", strconv.Itoa(audioFingerprintLength), c.file.Path).Output()
+		if err != nil {
+			if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+				err = errors.New(strings.TrimSpace(string(ee.Stderr)))
+			}
+			return hashResult{candidate: c, op: "fingerprint", err: &os.PathError{Op: "fingerprint", Path: c.file.Path, Err: err}}
+		}
+		return hashResult{candidate: c, hash: string(out)}
+	}
+	s.hashAll(ctx, queue, fingerprint, func(r hashResult) {
+		if ctx.Err() != nil {
+			return
+		}
+		if r.err != nil {
+			s.skip(r.file.Path, r.op, r.err)
+			return
+		}
+		var p audioPrint
+		if err := json.Unmarshal([]byte(r.hash), &p); err != nil || len(p.Fingerprint) == 0 {
+			if err == nil {
+				err = errors.New("empty fingerprint")
+			}
+			s.skip(r.file.Path, "fingerprint", &os.PathError{Op: "fingerprint", Path: r.file.Path, Err: err})
+			return
+		}
+		prints[r.seq] = p
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only recordings of about the same length can match, so compare each
+	// with those following it in order of duration.
+	byDuration := make([]candidate, 0, len(prints))
+	for _, c := range tracks {
+		if _, ok := prints[c.seq]; ok {
+			byDuration = append(byDuration, c)
+		}
+	}
+	sort.SliceStable(byDuration, func(i, j int) bool {
+		return prints[byDuration[i].seq].Duration < prints[byDuration[j].seq].Duration
+	})
+	set := make(disjointSet)
+	for _, c := range byDuration {
+		set.add(c.seq)
+	}
+	for i, a := range byDuration {
+		pa := prints[a.seq]
+		for _, b := range byDuration[i+1:] {
+			pb := prints[b.seq]
+			if pb.Duration-pa.Duration > audioDurationSlack {
+				break
+			}
+			if set.find(a.seq) != set.find(b.seq) && audioSimilarity(pa.Fingerprint, pb.Fingerprint) >= minSimilarity {
+				set.union(a.seq, b.seq)
+			}
+		}
+	}
+
+	var groups []AudioGroup
+	for _, cands := range set.groups(tracks) {
+		if s.allSameContent(cands) {
+			continue
+		}
+		g := AudioGroup{Similarity: 1}
+		for i, a := range cands {
+			g.Files = append(g.Files, a.file)
+			for _, b := range cands[i+1:] {
+				if sim := audioSimilarity(prints[a.seq].Fingerprint, prints[b.seq].Fingerprint); sim < g.Similarity {
+					g.Similarity = sim
+				}
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// audioSimilarity returns the fraction of bits that agree between two raw
+// Chromaprint fingerprints, at the best alignment within audioMaxShift
+// items.
+func audioSimilarity(a, b []uint32) float64 {
+	best := 0.0
+	for shift := -audioMaxShift; shift <= audioMaxShift; shift++ {
+		var differing, compared int
+		for i := range a {
+			j := i + shift
+			if j < 0 || j >= len(b) {
+				continue
+			}
+			differing += bits.OnesCount32(a[i] ^ b[j])
+			compared++
+		}
+		// Require most of the shorter fingerprint to overlap, so a short
+		// lucky alignment cannot make two recordings match.
+		if compared == 0 || float64(compared) < 0.8*math.Min(float64(len(a)), float64(len(b))) {
+			continue
+		}
+		if sim := 1 - float64(differing)/float64(32*compared); sim > best {
+			best = sim
+		}
+	}
+	return best
+}
@@ -0,0 +1,242 @@
+package dupes
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Default number of bytes Bench reads from the files it samples.
+const DEFAULT_BENCH_SAMPLE = 128 << 20
+
+// Shortest time Bench hashes the sample with each hash for, going over it
+// again as often as needed, so that fast hashes are timed reliably.
+const BENCH_MIN_DURATION = time.Second
+
+// BenchResult is how fast Bench measured a hash to be.
+type BenchResult struct {
+	// Hash is the hash spec measured, as Scanner.Hash takes it.
+	Hash string
+	// Bytes is the amount of data hashed, and Duration the time it took,
+	// with every worker hashing at once.
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Rate returns the bytes hashed per second.
+func (r BenchResult) Rate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// BenchReport is what Bench measured on a sample of files.
+type BenchReport struct {
+	// Files and Bytes are the number of files sampled and the amount of
+	// their data read.
+	Files int
+	Bytes int64
+	// Read is the time reading the sample took, with every worker reading
+	// at once, which bounds how fast a scan of the files can go whatever
+	// its hash.
+	Read    time.Duration
+	Results []BenchResult
+}
+
+// ReadRate returns the bytes read per second.
+func (r BenchReport) ReadRate() float64 {
+	if r.Read <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Read.Seconds()
+}
+
+// Bench walks roots as Scan does, reads a sample of the files found, up to
+// sampleBytes of them in all, and measures how fast each spec of specs, as
+// Scanner.Hash takes them, hashes the sample on this machine. The sample
+// is drawn from the files at random, taking no more than an eighth of
+// sampleBytes from any one, and held in memory, so that the hashes are
+// timed apart from the disks; each is timed with the scanner's workers
+// hashing files at once, each file with fresh hashes, as a scan does.
+// Files that cannot be read are reported to OnError and skipped.
+func (s *Scanner) Bench(ctx context.Context, specs []string, sampleBytes int64, roots ...string) (BenchReport, error) {
+	var report BenchReport
+	if err := s.reset(); err != nil {
+		return report, err
+	}
+	defer s.archives.close()
+	for _, spec := range specs {
+		if err := ValidateHash(spec); err != nil {
+			return report, err
+		}
+	}
+	key, err := s.highwayKey()
+	if err != nil {
+		return report, err
+	}
+	if sampleBytes <= 0 {
+		sampleBytes = DEFAULT_BENCH_SAMPLE
+	}
+
+	files, err := s.walk(ctx, roots)
+	if err != nil {
+		return report, err
+	}
+	files = s.collapseLinks(files)
+	s.files = files
+
+	// The same files are sampled each run, so that runs can be compared.
+	order := rand.New(rand.NewSource(1)).Perm(len(files))
+	var chosen []candidate
+	lengths := make(map[int64]int64)
+	remaining := sampleBytes
+	for _, i := range order {
+		if remaining <= 0 {
+			break
+		}
+		c := files[i]
+		n := c.file.Size
+		if n > sampleBytes/8 {
+			n = sampleBytes / 8
+		}
+		if n > remaining {
+			n = remaining
+		}
+		if n <= 0 {
+			continue
+		}
+		chosen = append(chosen, c)
+		lengths[c.seq] = n
+		remaining -= n
+	}
+
+	queue := make(chan candidate)
+	go func() {
+		defer close(queue)
+		for _, c := range chosen {
+			select {
+			case queue <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var sample [][]byte
+	start := time.Now()
+	s.hashAll(ctx, queue, func(c candidate) hashResult {
+		data, err := s.readSample(c.file.Path, lengths[c.seq])
+		return hashResult{candidate: c, hash: string(data), op: "read", err: err}
+	}, func(r hashResult) {
+		if ctx.Err() != nil {
+			return
+		}
+		if r.err != nil {
+			s.skip(r.file.Path, r.op, r.err)
+			return
+		}
+		sample = append(sample, []byte(r.hash))
+		report.Files++
+		report.Bytes += int64(len(r.hash))
+	})
+	report.Read = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if len(sample) == 0 {
+		return report, nil
+	}
+
+	for _, spec := range specs {
+		r, err := s.benchHash(ctx, spec, key, sample)
+		if err != nil {
+			return report, err
+		}
+		report.Results = append(report.Results, r)
+	}
+	return report, nil
+}
+
+// readSample returns the first n bytes of the file at path.
+func (s *Scanner) readSample(path string, n int64) ([]byte, error) {
+	f, err := s.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, n)
+	read, err := io.ReadFull(s.throttle(f), data)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// The file shrank since it was walked.
+		err = nil
+	} else if err != nil {
+		err = &os.PathError{Op: "read", Path: path, Err: err}
+	}
+	return data[:read], err
+}
+
+// benchHash times hashing each file of sample with spec, over and over
+// until BENCH_MIN_DURATION has passed, with the scanner's workers.
+func (s *Scanner) benchHash(ctx context.Context, spec string, key []byte, sample [][]byte) (BenchResult, error) {
+	r := BenchResult{Hash: spec}
+	bufferSize := s.ReadBufferSize
+	if bufferSize <= 0 {
+		bufferSize = DEFAULT_READ_BUFFER
+	}
+	var mu sync.Mutex
+	var failed error
+	start := time.Now()
+	for r.Duration < BENCH_MIN_DURATION {
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		queue := make(chan []byte)
+		var wg sync.WaitGroup
+		for i := 0; i < s.workers(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for data := range queue {
+					hashes, err := newHashesOf(spec, key)
+					if err != nil {
+						mu.Lock()
+						failed = err
+						mu.Unlock()
+						continue
+					}
+					writers := make([]io.Writer, len(hashes))
+					for i, h := range hashes {
+						writers[i] = h
+					}
+					w := io.MultiWriter(writers...)
+					// Written a buffer at a time, as files are read.
+					for p := data; len(p) > 0; {
+						n := len(p)
+						if n > bufferSize {
+							n = bufferSize
+						}
+						w.Write(p[:n])
+						p = p[n:]
+					}
+					for _, h := range hashes {
+						h.Sum(nil)
+					}
+				}
+			}()
+		}
+		for _, data := range sample {
+			queue <- data
+			r.Bytes += int64(len(data))
+		}
+		close(queue)
+		wg.Wait()
+		if failed != nil {
+			return r, failed
+		}
+		r.Duration = time.Since(start)
+	}
+	return r, nil
+}
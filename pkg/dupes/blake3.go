@@ -0,0 +1,221 @@
+package dupes
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// BLAKE3 block and chunk lengths, in bytes, and the domain flags of its
+// compression function.
+const (
+	blake3BlockLen   = 64
+	blake3ChunkLen   = 1024
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+// blake3IV is the BLAKE3 initialisation vector, that of SHA-256.
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+// blake3Schedule holds the order the message words are used in by each of
+// the seven rounds, the previous round's order permuted.
+var blake3Schedule = func() (s [7][16]int) {
+	permutation := [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+	for i := range s[0] {
+		s[0][i] = i
+	}
+	for r := 1; r < len(s); r++ {
+		for i, p := range permutation {
+			s[r][i] = s[r-1][p]
+		}
+	}
+	return s
+}()
+
+// blake3 is the BLAKE3 hash with its default 32 byte output, written from
+// the specification's reference implementation so as not to need a
+// dependency; it is not keyed and does not use SIMD instructions.
+type blake3 struct {
+	// cv is the chaining value of the chunk being hashed, chunk its
+	// number, and blocks the number of its blocks compressed so far; the
+	// last, block, is kept back until more input shows it is not the
+	// chunk's final block.
+	cv       [8]uint32
+	chunk    uint64
+	blocks   int
+	block    [blake3BlockLen]byte
+	blockLen int
+	// stack holds the chaining values of the subtrees completed so far,
+	// one for each bit set in the number of chunks hashed.
+	stack [][8]uint32
+}
+
+// newBlake3 returns a new BLAKE3 hash.
+func newBlake3() hash.Hash {
+	h := &blake3{}
+	h.Reset()
+	return h
+}
+
+func (h *blake3) Size() int      { return 32 }
+func (h *blake3) BlockSize() int { return blake3BlockLen }
+
+func (h *blake3) Reset() {
+	h.cv = blake3IV
+	h.chunk = 0
+	h.blocks = 0
+	h.blockLen = 0
+	h.stack = h.stack[:0]
+}
+
+func (h *blake3) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if h.blocks*blake3BlockLen+h.blockLen == blake3ChunkLen {
+			// The chunk is complete and more input follows.
+			cv := h.chunkOutput().chainingValue()
+			h.chunk++
+			h.pushChunk(cv, h.chunk)
+			h.cv = blake3IV
+			h.blocks = 0
+			h.blockLen = 0
+		}
+		if h.blockLen == blake3BlockLen {
+			var m [16]uint32
+			blake3Words(&m, h.block[:])
+			out := blake3Compress(&h.cv, &m, h.chunk, blake3BlockLen, h.startFlag())
+			copy(h.cv[:], out[:8])
+			h.blocks++
+			h.blockLen = 0
+		}
+		c := copy(h.block[h.blockLen:], p)
+		h.blockLen += c
+		p = p[c:]
+	}
+	return n, nil
+}
+
+func (h *blake3) Sum(b []byte) []byte {
+	out := h.chunkOutput()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		out = blake3ParentOutput(&h.stack[i], out.chainingValue())
+	}
+	words := blake3Compress(&out.cv, &out.block, 0, out.blockLen, out.flags|blake3Root)
+	var sum [32]byte
+	for i, w := range words[:8] {
+		binary.LittleEndian.PutUint32(sum[4*i:], w)
+	}
+	return append(b, sum[:]...)
+}
+
+// startFlag returns blake3ChunkStart if the next block compressed is the
+// first of its chunk.
+func (h *blake3) startFlag() uint32 {
+	if h.blocks == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+// pushChunk adds the chaining value of a completed chunk, the total'th, to
+// the stack, first merging it with every subtree it completes.
+func (h *blake3) pushChunk(cv [8]uint32, total uint64) {
+	for total&1 == 0 {
+		left := h.stack[len(h.stack)-1]
+		h.stack = h.stack[:len(h.stack)-1]
+		cv = blake3ParentOutput(&left, cv).chainingValue()
+		total >>= 1
+	}
+	h.stack = append(h.stack, cv)
+}
+
+// chunkOutput returns the output of the chunk being hashed, as if its last
+// block were final.
+func (h *blake3) chunkOutput() blake3Output {
+	out := blake3Output{
+		cv:       h.cv,
+		counter:  h.chunk,
+		blockLen: uint32(h.blockLen),
+		flags:    h.startFlag() | blake3ChunkEnd,
+	}
+	var block [blake3BlockLen]byte
+	copy(block[:], h.block[:h.blockLen])
+	blake3Words(&out.block, block[:])
+	return out
+}
+
+// blake3Output holds the inputs of the compression that produces a node's
+// chaining value, or, with the root flag, the hash.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o blake3Output) chainingValue() (cv [8]uint32) {
+	out := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], out[:8])
+	return cv
+}
+
+// blake3ParentOutput returns the output of the parent node of the subtrees
+// with the chaining values left and right.
+func blake3ParentOutput(left *[8]uint32, right [8]uint32) blake3Output {
+	out := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(out.block[:8], left[:])
+	copy(out.block[8:], right[:])
+	return out
+}
+
+// blake3Words reads the 16 little-endian words of block into m.
+func blake3Words(m *[16]uint32, block []byte) {
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(block[4*i:])
+	}
+}
+
+// blake3Compress is the BLAKE3 compression function.
+func blake3Compress(cv *[8]uint32, m *[16]uint32, counter uint64, blockLen uint32, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	for r := range blake3Schedule {
+		p := &blake3Schedule[r]
+		blake3G(&s, 0, 4, 8, 12, m[p[0]], m[p[1]])
+		blake3G(&s, 1, 5, 9, 13, m[p[2]], m[p[3]])
+		blake3G(&s, 2, 6, 10, 14, m[p[4]], m[p[5]])
+		blake3G(&s, 3, 7, 11, 15, m[p[6]], m[p[7]])
+		blake3G(&s, 0, 5, 10, 15, m[p[8]], m[p[9]])
+		blake3G(&s, 1, 6, 11, 12, m[p[10]], m[p[11]])
+		blake3G(&s, 2, 7, 8, 13, m[p[12]], m[p[13]])
+		blake3G(&s, 3, 4, 9, 14, m[p[14]], m[p[15]])
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+// blake3G is the quarter-round of the compression function, mixing the
+// message words x and y into four words of the state s.
+func blake3G(s *[16]uint32, a, b, c, d int, x, y uint32) {
+	s[a] += s[b] + x
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + y
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
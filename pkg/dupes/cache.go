@@ -0,0 +1,174 @@
+package dupes
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheVersion is bumped whenever the cache's format or hashing changes, so
+// stale caches are discarded rather than misread.
+const cacheVersion = 1
+
+// Cache stores file hashes between scans, so that unchanged files do not
+// have to be read again. A file's cached hash is reused only if its size,
+// modification time and inode all still match, and it was computed with the
+// same hash algorithm. A Cache is safe for concurrent use.
+type Cache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+type cacheEntry struct {
+	Size      int64
+	ModTime   int64
+	Inode     uint64
+	Algorithm string
+	Hash      string
+}
+
+type cacheFile struct {
+	Version int
+	Entries map[string]cacheEntry
+}
+
+// OpenCache loads the cache stored at path. A missing file yields an empty
+// cache that will be created by Save.
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{path: path, entries: make(map[string]cacheEntry)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cf cacheFile
+	if err := gob.NewDecoder(f).Decode(&cf); err != nil {
+		return nil, fmt.Errorf("reading cache %s: %v", path, err)
+	}
+	if cf.Version == cacheVersion && cf.Entries != nil {
+		c.entries = cf.Entries
+	}
+	return c, nil
+}
+
+// Save writes the cache back to the file it was opened from, if it has
+// changed. The file is replaced atomically.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(cacheFile{Version: cacheVersion, Entries: c.entries})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Len returns the number of hashes in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Algorithms returns the number of hashes in the cache computed with each
+// hash algorithm.
+func (c *Cache) Algorithms() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int)
+	for _, e := range c.entries {
+		counts[e.Algorithm]++
+	}
+	return counts
+}
+
+// Prune removes the hashes of files that no longer exist or have changed
+// since they were hashed, and returns how many were removed. The cache is
+// not written until Save is called.
+func (c *Cache) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for path, e := range c.entries {
+		info, err := os.Stat(path)
+		if err == nil {
+			_, ino := fileID(path, info)
+			if info.Size() == e.Size && info.ModTime().UnixNano() == e.ModTime && ino == e.Inode {
+				continue
+			}
+		}
+		delete(c.entries, path)
+		removed++
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	return removed
+}
+
+// lookup returns the cached hash of cand's file computed with algorithm, if
+// it is still valid.
+func (c *Cache) lookup(cand candidate, algorithm string) (string, bool) {
+	key := cacheKey(cand.file.Path)
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if !ok || e.Algorithm != algorithm || e.Size != cand.file.Size ||
+		e.ModTime != cand.file.ModTime.UnixNano() || e.Inode != cand.ino {
+		return "", false
+	}
+	return e.Hash, true
+}
+
+// store records the hash of cand's file computed with algorithm.
+func (c *Cache) store(cand candidate, algorithm string, hash string) {
+	key := cacheKey(cand.file.Path)
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{
+		Size:      cand.file.Size,
+		ModTime:   cand.file.ModTime.UnixNano(),
+		Inode:     cand.ino,
+		Algorithm: algorithm,
+		Hash:      hash,
+	}
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// cacheKey returns the absolute path of path, so the cache is shared between
+// scans run from different working directories.
+func cacheKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
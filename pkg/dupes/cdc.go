@@ -0,0 +1,294 @@
+package dupes
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+
+	"github.com/OneOfOne/xxhash"
+)
+
+// Default similarity, from 0 to 1, above which SimilarContent groups two
+// files: the fraction of their content they share.
+const DEFAULT_CDC_SIMILARITY = 0.8
+
+// Default average size of the content-defined chunks SimilarContent splits
+// files into.
+const DEFAULT_CDC_CHUNK = 16 << 10
+
+// Files containing a chunk beyond which the chunk no longer suggests that
+// two files are alike: it is one many files contain, such as a run of
+// zeros.
+const cdcMaxPostings = 256
+
+// cdcGear is the table of random values the gear hash that finds chunk
+// boundaries adds up, one per byte value, made by splitmix64.
+var cdcGear = func() (gear [256]uint64) {
+	x := uint64(0x6a09e667f3bcc908)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		gear[i] = z ^ z>>31
+	}
+	return gear
+}()
+
+// ContentGroup is a set of files that share most of their content without
+// being identical, such as a log and a longer copy of it, or an archive
+// and the same archive with files appended.
+type ContentGroup struct {
+	Files []File
+	// Similarity is the lowest similarity, from 0 to 1, between two files
+	// of the group.
+	Similarity float64
+}
+
+// cdcChunk is one of the distinct chunks of a file: the hash of its
+// content, and its size.
+type cdcChunk struct {
+	sum  uint64
+	size uint32
+}
+
+// cdcFile is the chunks of a file, by sum, and their total size.
+type cdcFile struct {
+	chunks []cdcChunk
+	size   int64
+}
+
+// SimilarContent returns the groups of files found by the most recent scan
+// that share at least minSimilarity of their content, in walk order. Each
+// file is split into chunks at boundaries chosen by its content with
+// FastCDC, averaging avgChunk bytes, so that an insertion or deletion only
+// changes the chunks around it; the similarity of two files is the size of
+// the chunks they share divided by the size of the chunks either has.
+// Files smaller than avgChunk, and members of archives, are left out, as
+// are groups whose files are all exact duplicates of each other. Files
+// that cannot be read are reported to OnError and skipped.
+func (s *Scanner) SimilarContent(ctx context.Context, avgChunk int, minSimilarity float64) ([]ContentGroup, error) {
+	if avgChunk < 256 {
+		return nil, errors.New("dupes: the average chunk size must be at least 256 bytes")
+	}
+	var cands []candidate
+	for _, c := range s.files {
+		if c.file.Archive == "" && c.file.Size >= int64(avgChunk) {
+			cands = append(cands, c)
+		}
+	}
+	queue := make(chan candidate)
+	go func() {
+		defer close(queue)
+		for _, c := range cands {
+			select {
+			case queue <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	files := make(map[int64]cdcFile)
+	chunk := func(c candidate) hashResult {
+		chunks, err := s.cdcChunks(c.file.Path, avgChunk)
+		return hashResult{candidate: c, hash: encodeCDCChunks(chunks), op: "read", err: err}
+	}
+	s.hashAll(ctx, queue, chunk, func(r hashResult) {
+		if ctx.Err() != nil {
+			return
+		}
+		if r.err != nil {
+			s.skip(r.file.Path, r.op, r.err)
+			return
+		}
+		f := cdcFile{chunks: decodeCDCChunks(r.hash)}
+		for _, c := range f.chunks {
+			f.size += int64(c.size)
+		}
+		files[r.seq] = f
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Compare each file with the earlier ones it shares a chunk with,
+	// found through the files seen containing each chunk.
+	postings := make(map[uint64][]int64)
+	set := make(disjointSet)
+	for _, c := range cands {
+		f, ok := files[c.seq]
+		if !ok {
+			continue
+		}
+		set.add(c.seq)
+		compared := make(map[int64]bool)
+		for _, ch := range f.chunks {
+			for _, seq := range postings[ch.sum] {
+				if compared[seq] {
+					continue
+				}
+				compared[seq] = true
+				// Two files share at most the smaller one, so a pair of
+				// very different sizes cannot match.
+				small, large := f.size, files[seq].size
+				if small > large {
+					small, large = large, small
+				}
+				if float64(small) < minSimilarity*float64(large) {
+					continue
+				}
+				if set.find(seq) != set.find(c.seq) && cdcSimilarity(f, files[seq]) >= minSimilarity {
+					set.union(seq, c.seq)
+				}
+			}
+			if len(postings[ch.sum]) < cdcMaxPostings {
+				postings[ch.sum] = append(postings[ch.sum], c.seq)
+			}
+		}
+	}
+
+	var groups []ContentGroup
+	for _, members := range set.groups(cands) {
+		if s.allSameContent(members) {
+			continue
+		}
+		g := ContentGroup{Similarity: 1}
+		for i, a := range members {
+			g.Files = append(g.Files, a.file)
+			for _, b := range members[i+1:] {
+				if sim := cdcSimilarity(files[a.seq], files[b.seq]); sim < g.Similarity {
+					g.Similarity = sim
+				}
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// cdcSimilarity returns the size of the chunks files a and b share divided
+// by the size of the chunks either has.
+func cdcSimilarity(a, b cdcFile) float64 {
+	if a.size == 0 || b.size == 0 {
+		return 0
+	}
+	var shared int64
+	for i, j := 0, 0; i < len(a.chunks) && j < len(b.chunks); {
+		switch {
+		case a.chunks[i].sum < b.chunks[j].sum:
+			i++
+		case a.chunks[i].sum > b.chunks[j].sum:
+			j++
+		default:
+			shared += int64(a.chunks[i].size)
+			i++
+			j++
+		}
+	}
+	return float64(shared) / float64(a.size+b.size-shared)
+}
+
+// cdcChunks splits the file at path into content-defined chunks averaging
+// avg bytes, between a quarter and eight times that, and returns the
+// distinct ones sorted by sum. Boundaries are found with FastCDC's gear
+// hash and normalized chunking, which makes chunks smaller than avg rarer.
+func (s *Scanner) cdcChunks(path string, avg int) ([]cdcChunk, error) {
+	f, err := s.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := s.throttle(f)
+
+	minSize, maxSize := avg/4, avg*8
+	bitsAvg := bits.Len(uint(avg)) - 1
+	// Boundaries are where the top bits of the hash, which depend on the
+	// last 64 bytes, are zero: more of them before the average size, and
+	// fewer after it.
+	maskS := ^uint64(0) << (64 - (bitsAvg + 2))
+	maskL := ^uint64(0) << (64 - (bitsAvg - 2))
+
+	h := xxhash.New64()
+	seen := make(map[uint64]bool)
+	var chunks []cdcChunk
+	buf := make([]byte, maxSize)
+	n := 0
+	eof := false
+	for {
+		if !eof {
+			read, err := io.ReadFull(r, buf[n:])
+			n += read
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return nil, &os.PathError{Op: "read", Path: path, Err: err}
+			}
+		}
+		if n == 0 {
+			break
+		}
+		cut := cdcCut(buf[:n], minSize, avg, maskS, maskL)
+		h.Reset()
+		h.Write(buf[:cut])
+		if sum := h.Sum64(); !seen[sum] {
+			seen[sum] = true
+			chunks = append(chunks, cdcChunk{sum: sum, size: uint32(cut)})
+		}
+		n = copy(buf, buf[cut:n])
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].sum < chunks[j].sum })
+	return chunks, nil
+}
+
+// cdcCut returns the length of the chunk data starts with, data being no
+// longer than the largest chunk, or all of it at the end of the file.
+func cdcCut(data []byte, minSize int, avg int, maskS uint64, maskL uint64) int {
+	if len(data) <= minSize {
+		return len(data)
+	}
+	normal := avg
+	if normal > len(data) {
+		normal = len(data)
+	}
+	var h uint64
+	i := minSize
+	for ; i < normal; i++ {
+		h = h<<1 + cdcGear[data[i]]
+		if h&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < len(data); i++ {
+		h = h<<1 + cdcGear[data[i]]
+		if h&maskL == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+// encodeCDCChunks encodes chunks as a string, to be passed through hashAll.
+func encodeCDCChunks(chunks []cdcChunk) string {
+	b := make([]byte, 0, 12*len(chunks))
+	for _, c := range chunks {
+		b = binary.LittleEndian.AppendUint64(b, c.sum)
+		b = binary.LittleEndian.AppendUint32(b, c.size)
+	}
+	return string(b)
+}
+
+// decodeCDCChunks decodes chunks encoded by encodeCDCChunks.
+func decodeCDCChunks(s string) []cdcChunk {
+	chunks := make([]cdcChunk, 0, len(s)/12)
+	for ; len(s) >= 12; s = s[12:] {
+		chunks = append(chunks, cdcChunk{
+			sum:  binary.LittleEndian.Uint64([]byte(s[:8])),
+			size: binary.LittleEndian.Uint32([]byte(s[8:12])),
+		})
+	}
+	return chunks
+}
@@ -0,0 +1,199 @@
+package dupes
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// checkpointVersion is bumped whenever the checkpoint format changes.
+const checkpointVersion = 1
+
+// Default interval between checkpoint saves during a scan.
+const DEFAULT_CHECKPOINT_INTERVAL = 30 * time.Second
+
+// Checkpoint records the progress of a scan, the files walked and the
+// hashes computed, so that an interrupted scan can be resumed without
+// walking the trees or hashing those files again. The scan saves it when the
+// walk completes and every Interval while hashing; the caller is responsible
+// for saving it when the scan ends. Files changed after they were
+// checkpointed are not noticed by the resumed scan.
+type Checkpoint struct {
+	// Interval is the time between saves while hashing. Zero means
+	// DEFAULT_CHECKPOINT_INTERVAL.
+	Interval time.Duration
+
+	path     string
+	mu       sync.Mutex
+	state    checkpointState
+	lastSave time.Time
+}
+
+type checkpointState struct {
+	Version    int
+	Roots      []string
+	References []string
+	Algorithm  string
+	// Walked is set once the walk is complete and Files holds its result.
+	Walked bool
+	Files  []checkpointFile
+	Hashes map[string]string
+}
+
+type checkpointFile struct {
+	Seq       int64
+	Path      string
+	Root      string
+	Size      int64
+	ModTime   time.Time
+	Dev       uint64
+	Ino       uint64
+	Reference bool
+	Links     []string
+}
+
+// NewCheckpoint returns an empty checkpoint that will be saved to path,
+// replacing any checkpoint already there.
+func NewCheckpoint(path string) *Checkpoint {
+	return &Checkpoint{path: path, state: checkpointState{Hashes: make(map[string]string)}}
+}
+
+// ResumeCheckpoint loads the checkpoint saved at path. Scanning with it
+// continues the scan that saved it, which must have been of the same
+// directories with the same hash algorithm.
+func ResumeCheckpoint(path string) (*Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Checkpoint{path: path}
+	if err := gob.NewDecoder(f).Decode(&c.state); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %v", path, err)
+	}
+	if c.state.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint %s was written by an incompatible version", path)
+	}
+	if c.state.Hashes == nil {
+		c.state.Hashes = make(map[string]string)
+	}
+	return c, nil
+}
+
+// Save writes the checkpoint to its file. The file is replaced atomically.
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSave = time.Now()
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	c.state.Version = checkpointVersion
+	err = gob.NewEncoder(f).Encode(&c.state)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Remove deletes the checkpoint's file, once the scan it records is done.
+func (c *Checkpoint) Remove() error {
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// start prepares the checkpoint for a scan of roots by s. A new checkpoint
+// records the scan's settings; a resumed one must match them.
+func (c *Checkpoint) start(s *Scanner, roots []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Roots == nil {
+		c.state.Roots = roots
+		c.state.References = s.References
+		c.state.Algorithm = s.hashIdentity()
+		return nil
+	}
+	if !reflect.DeepEqual(c.state.Roots, roots) || !reflect.DeepEqual(c.state.References, s.References) {
+		return fmt.Errorf("checkpoint %s is for a scan of different directories", c.path)
+	}
+	if c.state.Algorithm != s.hashIdentity() {
+		return fmt.Errorf("checkpoint %s is for a scan with hash %s", c.path, c.state.Algorithm)
+	}
+	return nil
+}
+
+// walked returns the files of the checkpointed walk, if it was complete.
+func (c *Checkpoint) walked() ([]candidate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.state.Walked {
+		return nil, false
+	}
+	files := make([]candidate, len(c.state.Files))
+	for i, f := range c.state.Files {
+		files[i] = candidate{
+			seq: f.Seq,
+			file: File{Path: f.Path, Root: f.Root, Size: f.Size, ModTime: f.ModTime,
+				Reference: f.Reference, Links: f.Links},
+			dev: f.Dev,
+			ino: f.Ino,
+		}
+	}
+	return files, true
+}
+
+// setWalked records the result of a completed walk.
+func (c *Checkpoint) setWalked(files []candidate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Files = make([]checkpointFile, len(files))
+	for i, cand := range files {
+		f := cand.file
+		c.state.Files[i] = checkpointFile{Seq: cand.seq, Path: f.Path, Root: f.Root, Size: f.Size,
+			ModTime: f.ModTime, Dev: cand.dev, Ino: cand.ino, Reference: f.Reference, Links: f.Links}
+	}
+	c.state.Walked = true
+}
+
+// lookup returns the hash recorded for path.
+func (c *Checkpoint) lookup(path string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.state.Hashes[path]
+	return h, ok
+}
+
+// store records the hash of path, and saves the checkpoint if Interval has
+// passed since it was last saved. Errors from those saves are ignored; the
+// final Save reports them.
+func (c *Checkpoint) store(path string, hash string) {
+	c.mu.Lock()
+	c.state.Hashes[path] = hash
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DEFAULT_CHECKPOINT_INTERVAL
+	}
+	due := time.Since(c.lastSave) >= interval
+	c.mu.Unlock()
+	if due {
+		c.Save()
+	}
+}
@@ -0,0 +1,108 @@
+package dupes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Default size of the chunks Scanner.ChunkThreshold hashes large files in.
+const DEFAULT_HASH_CHUNK = 64 << 20
+
+// chunkSize returns the scanner's ChunkSize, or DEFAULT_HASH_CHUNK if unset.
+func (s *Scanner) chunkSize() int64 {
+	if s.ChunkSize <= 0 {
+		return DEFAULT_HASH_CHUNK
+	}
+	return s.ChunkSize
+}
+
+// chunked reports whether files of size bytes are hashed in chunks.
+func (s *Scanner) chunked(size int64) bool {
+	return s.ChunkThreshold > 0 && size > s.ChunkThreshold
+}
+
+// HashScheme describes how the scanner hashes files, so that its hashes can
+// be reproduced: the hash spec, followed, if large files are hashed in
+// chunks, by the chunk size and the size files must exceed to be chunked,
+// as in "sha256/chunk=67108864/over=1073741824".
+func (s *Scanner) HashScheme() string {
+	if s.ChunkThreshold <= 0 {
+		return s.hashAlgorithm()
+	}
+	return fmt.Sprintf("%s/chunk=%d/over=%d", s.hashAlgorithm(), s.chunkSize(), s.ChunkThreshold)
+}
+
+// combineChunks returns the hash of a chunked file from the hashes of its
+// chunks: the hash of their list, one per line.
+func (s *Scanner) combineChunks(sums []string) (string, error) {
+	return s.hashReader(strings.NewReader(strings.Join(sums, "\n") + "\n"))
+}
+
+// hashChunkedFile hashes the file at path as hashFile does if it is large
+// enough to be chunked, reading up to one chunk per worker at once, and
+// reports whether it was. Archive members are left to hashFile.
+func (s *Scanner) hashChunkedFile(ctx context.Context, path string) (sum string, chunked bool, err error) {
+	if s.archives.member(path) != nil {
+		return "", false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !s.chunked(info.Size()) {
+		return "", false, nil
+	}
+
+	size, chunk := info.Size(), s.chunkSize()
+	sums := make([]string, (size+chunk-1)/chunk)
+	errs := make([]error, len(sums))
+	var wg sync.WaitGroup
+	for i := range sums {
+		// Chunks share the scanner's slots, so however many large files are
+		// hashed at once no more than Workers chunks are read.
+		select {
+		case s.chunkSlots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return "", true, ctx.Err()
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-s.chunkSlots }()
+			off := int64(i) * chunk
+			sums[i], errs[i] = s.hashReader(ctxReader{ctx, io.NewSectionReader(f, off, chunk)})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return "", true, err
+		}
+	}
+	sum, err = s.combineChunks(sums)
+	return sum, true, err
+}
+
+// hashChunkedReader returns the hash of the size bytes read from r,
+// chunked as hashChunkedFile would, but reading the chunks one after the
+// other. It is used for archive members, which can only be read in order,
+// so that they match the files they duplicate.
+func (s *Scanner) hashChunkedReader(r io.Reader, size int64) (string, error) {
+	chunk := s.chunkSize()
+	var sums []string
+	for off := int64(0); off < size; off += chunk {
+		sum, err := s.hashReader(io.LimitReader(r, chunk))
+		if err != nil {
+			return "", err
+		}
+		sums = append(sums, sum)
+	}
+	return s.combineChunks(sums)
+}
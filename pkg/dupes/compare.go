@@ -0,0 +1,54 @@
+package dupes
+
+import "context"
+
+// Comparison is the content-based difference between two directory trees.
+type Comparison struct {
+	// Both holds the groups of identical files with members in both trees.
+	Both []DupeGroup
+	// OnlyA and OnlyB hold the files whose content has no copy in the
+	// other tree, in walk order.
+	OnlyA []File
+	OnlyB []File
+}
+
+// Compare scans the trees at a and b and reports which files are present in
+// both, by content, and which are unique to either. Files that could not be
+// read are left out. If a sampling limit stops hashing early, files that
+// were not hashed are reported as unique to their tree.
+func (s *Scanner) Compare(ctx context.Context, a string, b string) (Comparison, error) {
+	var cmp Comparison
+	groups, err := s.Scan(ctx, a, b)
+	if err != nil {
+		return cmp, err
+	}
+
+	inBoth := make(map[string]bool)
+	for _, g := range groups {
+		var hasA, hasB bool
+		for _, f := range g.Files {
+			hasA = hasA || f.Root == a
+			hasB = hasB || f.Root == b
+		}
+		if !hasA || !hasB {
+			continue
+		}
+		cmp.Both = append(cmp.Both, g)
+		for _, f := range g.Files {
+			inBoth[f.Path] = true
+		}
+	}
+
+	for _, c := range s.files {
+		f := c.file
+		if inBoth[f.Path] || s.skipped[f.Path] {
+			continue
+		}
+		if f.Root == a {
+			cmp.OnlyA = append(cmp.OnlyA, f)
+		} else {
+			cmp.OnlyB = append(cmp.OnlyB, f)
+		}
+	}
+	return cmp, nil
+}
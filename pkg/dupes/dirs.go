@@ -0,0 +1,179 @@
+package dupes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirGroup is a set of directories whose trees are identical: the same
+// names, with the same content, at every level.
+type DirGroup struct {
+	// Digest is derived from the names and hashes of everything in the
+	// tree.
+	Digest string
+	Dirs   []string
+	// Files and Size are the number of files in each tree and their total
+	// size in bytes.
+	Files int
+	Size  int64
+}
+
+// WastedBytes returns the space that could be reclaimed by keeping a single
+// copy of the tree.
+func (g *DirGroup) WastedBytes() int64 {
+	return g.Size * int64(len(g.Dirs)-1)
+}
+
+// dirNode accumulates the contents of one directory for DuplicateDirs.
+type dirNode struct {
+	path    string
+	depth   int
+	entries []string
+	// unique is set if the tree contains a file that was not hashed,
+	// which therefore has no copy anywhere.
+	unique bool
+	files  int
+	size   int64
+	digest string
+}
+
+// DuplicateDirs returns the groups of identical directory trees found by the
+// most recent scan, largest first. Only the files scanned are considered, so
+// excluded and empty files are ignored. A directory is not reported if its
+// parent is itself part of a duplicate group.
+func (s *Scanner) DuplicateDirs() []DirGroup {
+	nodes := make(map[string]*dirNode)
+	node := func(root, dir string) *dirNode {
+		n, ok := nodes[dir]
+		if !ok {
+			rel, _ := filepath.Rel(root, dir)
+			n = &dirNode{path: dir, depth: strings.Count(filepath.ToSlash(rel), "/")}
+			if rel != "." {
+				n.depth++
+			}
+			nodes[dir] = n
+		}
+		return n
+	}
+
+	addFile := func(root, path string, size int64) {
+		dir := filepath.Dir(path)
+		n := node(root, dir)
+		hash, ok := s.hashes[path]
+		if !ok {
+			n.unique = true
+		}
+		n.entries = append(n.entries, fmt.Sprintf("f %s %s", filepath.Base(path), hash))
+		n.files++
+		n.size += size
+		// Make sure every directory up to the root exists, so that trees
+		// holding only subdirectories are compared too.
+		for dir != root && len(dir) > len(root) {
+			node(root, filepath.Dir(dir))
+			dir = filepath.Dir(dir)
+		}
+	}
+	roots := make(map[string]bool)
+	for _, c := range s.files {
+		roots[c.file.Root] = true
+	}
+	for _, c := range s.files {
+		if c.file.Archive != "" {
+			continue
+		}
+		addFile(c.file.Root, c.file.Path, c.file.Size)
+		// A link may have been found under a different root.
+		for _, link := range c.file.Links {
+			if root := rootOf(roots, link); root != "" {
+				addFile(root, link, c.file.Size)
+			}
+		}
+	}
+
+	// Digest the deepest directories first, so each parent can include the
+	// digests of its children.
+	ordered := make([]*dirNode, 0, len(nodes))
+	for _, n := range nodes {
+		ordered = append(ordered, n)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].depth != ordered[j].depth {
+			return ordered[i].depth > ordered[j].depth
+		}
+		return ordered[i].path < ordered[j].path
+	})
+	for _, n := range ordered {
+		sort.Strings(n.entries)
+		sum := sha256.Sum256([]byte(strings.Join(n.entries, "\n")))
+		n.digest = hex.EncodeToString(sum[:])
+		if n.depth == 0 {
+			continue
+		}
+		if parent, ok := nodes[filepath.Dir(n.path)]; ok {
+			parent.entries = append(parent.entries, fmt.Sprintf("d %s %s", filepath.Base(n.path), n.digest))
+			parent.unique = parent.unique || n.unique
+			parent.files += n.files
+			parent.size += n.size
+		}
+	}
+
+	byDigest := make(map[string][]*dirNode)
+	for _, n := range ordered {
+		if !n.unique && n.files > 0 {
+			byDigest[n.digest] = append(byDigest[n.digest], n)
+		}
+	}
+	inGroup := make(map[string]bool)
+	for _, members := range byDigest {
+		if len(members) > 1 {
+			for _, n := range members {
+				inGroup[n.path] = true
+			}
+		}
+	}
+
+	var groups []DirGroup
+	for digest, members := range byDigest {
+		if len(members) < 2 {
+			continue
+		}
+		nested := true
+		for _, n := range members {
+			if n.depth == 0 || !inGroup[filepath.Dir(n.path)] {
+				nested = false
+			}
+		}
+		if nested {
+			continue
+		}
+		g := DirGroup{Digest: digest, Files: members[0].files, Size: members[0].size}
+		for _, n := range members {
+			g.Dirs = append(g.Dirs, n.path)
+		}
+		sort.Strings(g.Dirs)
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].WastedBytes() != groups[j].WastedBytes() {
+			return groups[i].WastedBytes() > groups[j].WastedBytes()
+		}
+		return groups[i].Digest < groups[j].Digest
+	})
+	return groups
+}
+
+// rootOf returns the longest of roots containing path, or "" if none does.
+func rootOf(roots map[string]bool, path string) string {
+	best := ""
+	for root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && len(root) > len(best) {
+			best = root
+		}
+	}
+	return best
+}
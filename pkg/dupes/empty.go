@@ -0,0 +1,77 @@
+package dupes
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// noteEmpty wraps walkFn, which walks dir, to keep track for FindEmpty of
+// the directories found and of those with something in them other than
+// empty directories. Anything but a directory walked in full counts as
+// content: files, filtered or not, symlinks, and directories left out.
+func (w *walker) noteEmpty(dir string, walkFn filepath.WalkFunc) filepath.WalkFunc {
+	if !w.s.FindEmpty {
+		return walkFn
+	}
+	return func(path string, info os.FileInfo, err error) error {
+		if path != dir {
+			if err == nil && info.IsDir() {
+				w.dirs = append(w.dirs, path)
+			} else {
+				w.markNonEmpty(filepath.Dir(path))
+			}
+		}
+		result := walkFn(path, info, err)
+		if result == filepath.SkipDir && info != nil && info.IsDir() {
+			w.markNonEmpty(path)
+		}
+		return result
+	}
+}
+
+// markNonEmpty records that dir, and so every directory above it, is not
+// empty.
+func (w *walker) markNonEmpty(dir string) {
+	if w.nonEmpty == nil {
+		w.nonEmpty = make(map[string]bool)
+	}
+	for !w.nonEmpty[dir] {
+		w.nonEmpty[dir] = true
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
+
+// emptyDirs returns the directories walked that hold nothing but empty
+// directories, in walk order. Those inside another are left out, as
+// removing it removes them.
+func (w *walker) emptyDirs() []string {
+	empty := make(map[string]bool)
+	var top []string
+	for _, d := range w.dirs {
+		if w.nonEmpty[d] {
+			continue
+		}
+		empty[d] = true
+		if !empty[filepath.Dir(d)] {
+			top = append(top, d)
+		}
+	}
+	return top
+}
+
+// EmptyFiles returns the paths of the zero-byte files the most recent scan
+// left out, if FindEmpty is set, in walk order.
+func (s *Scanner) EmptyFiles() []string {
+	return s.emptyFiles
+}
+
+// EmptyDirs returns the directories below the roots of the most recent
+// scan that hold nothing but empty directories, if FindEmpty is set, in
+// walk order. A directory inside one reported is not listed itself.
+func (s *Scanner) EmptyDirs() []string {
+	return s.emptyDirs
+}
@@ -0,0 +1,249 @@
+package dupes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// validateFilters reports any malformed filter options, so a bad pattern
+// fails the scan up front rather than silently matching nothing.
+func (s *Scanner) validateFilters() error {
+	for _, p := range s.Exclude {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return &os.PathError{Op: "exclude", Path: p, Err: err}
+		}
+	}
+	for _, p := range s.MIME {
+		if _, err := pathpkg.Match(p, ""); err != nil || !strings.Contains(p, "/") {
+			return fmt.Errorf("dupes: invalid MIME type pattern %q", p)
+		}
+	}
+	if s.MinSize < 0 || s.MaxSize < 0 || (s.MaxSize > 0 && s.MaxSize < s.MinSize) {
+		return fmt.Errorf("dupes: invalid size range %d-%d", s.MinSize, s.MaxSize)
+	}
+	if !s.ModifiedAfter.IsZero() && !s.ModifiedBefore.IsZero() && !s.ModifiedAfter.Before(s.ModifiedBefore) {
+		return fmt.Errorf("dupes: invalid modification time range %s-%s",
+			s.ModifiedAfter.Format(time.RFC3339), s.ModifiedBefore.Format(time.RFC3339))
+	}
+	if len(s.Owners) > 0 && !ownersSupported {
+		return errors.New("dupes: files cannot be filtered by owner on this platform")
+	}
+	if s.MaxDepth < 0 {
+		return fmt.Errorf("dupes: invalid maximum depth %d", s.MaxDepth)
+	}
+	return nil
+}
+
+// include reports whether the entry at path, found under root, should be
+// scanned. Excluding or ignoring a directory prunes its whole subtree.
+// Entries left out are reported to OnFiltered.
+func (s *Scanner) include(root string, path string, info os.FileInfo) bool {
+	if path == root {
+		return true
+	}
+	reason := s.filterReason(root, path, info)
+	if reason != "" {
+		s.filtered(path, reason)
+	}
+	if reason == "empty" && s.FindEmpty {
+		s.emptyFiles = append(s.emptyFiles, path)
+	}
+	return reason == ""
+}
+
+// filtered reports to OnFiltered that the entry at path was left out.
+func (s *Scanner) filtered(path string, reason string) {
+	if s.OnFiltered != nil {
+		s.OnFiltered(path, reason)
+	}
+}
+
+// filterReason returns why the entry at path, found under root, is left
+// out of the scan, or "" if it is not.
+func (s *Scanner) filterReason(root string, path string, info os.FileInfo) string {
+	if p := s.excluded(root, path, info); p != "" {
+		return "excluded by " + p
+	}
+	if s.ignored(root, path, info) {
+		return "ignored"
+	}
+	if s.SkipHidden && isHidden(path, info) {
+		return "hidden"
+	}
+	if s.SkipUnreadable && !readable(info) {
+		return "not readable"
+	}
+	if info.IsDir() {
+		if s.MaxDepth > 0 && depth(root, path) >= s.MaxDepth {
+			return "below the maximum depth"
+		}
+		return ""
+	}
+	if !info.Mode().IsRegular() {
+		// Left out by the walk, whatever their size.
+		return ""
+	}
+	switch {
+	case info.Size() == 0 && !s.IncludeEmpty:
+		return "empty"
+	case !s.sizeIncluded(info.Size()):
+		return "outside the size range"
+	case !s.timeIncluded(info.ModTime()):
+		return "outside the modification time range"
+	case !s.ownerIncluded(info):
+		return "owned by another user"
+	case !s.extIncluded(path):
+		return "extension not included"
+	}
+	if len(s.MIME) > 0 {
+		ct, err := contentType(path)
+		if err != nil {
+			s.skip(path, "read", err)
+			return "unreadable"
+		}
+		if !s.mimeMatches(ct) {
+			return "MIME type " + ct + " not included"
+		}
+	}
+	return ""
+}
+
+// depth returns how many levels path is below root: 0 for root itself and
+// 1 for its entries.
+func depth(root string, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
+
+// extIncluded reports whether the extension of path passes the extension
+// filters.
+func (s *Scanner) extIncluded(path string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	matches := func(exts []string) bool {
+		for _, e := range exts {
+			if strings.ToLower(strings.TrimPrefix(e, ".")) == ext {
+				return true
+			}
+		}
+		return false
+	}
+	if len(s.IncludeExt) > 0 && !matches(s.IncludeExt) {
+		return false
+	}
+	return !matches(s.ExcludeExt)
+}
+
+// mimeMatches reports whether the MIME type ct matches one of the MIME
+// patterns.
+func (s *Scanner) mimeMatches(ct string) bool {
+	for _, p := range s.MIME {
+		if ok, _ := pathpkg.Match(p, ct); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contentType returns the MIME type of the file at path, without
+// parameters, as sniffed by http.DetectContentType from its first 512
+// bytes. If the content is not recognised, the type registered for the
+// file's extension is used, if any.
+func contentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	ct := http.DetectContentType(head[:n])
+	if ct == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(path)); byExt != "" {
+			ct = byExt
+		}
+	}
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	return strings.TrimSpace(ct), nil
+}
+
+// ownerIncluded reports whether the file described by info passes the
+// owner filter.
+func (s *Scanner) ownerIncluded(info os.FileInfo) bool {
+	if len(s.Owners) == 0 {
+		return true
+	}
+	uid, ok := fileOwner(info)
+	if !ok {
+		return false
+	}
+	for _, owner := range s.Owners {
+		if uid == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// timeIncluded reports whether a file last modified at t passes the
+// modification time filters.
+func (s *Scanner) timeIncluded(t time.Time) bool {
+	if !s.ModifiedAfter.IsZero() && !t.After(s.ModifiedAfter) {
+		return false
+	}
+	return s.ModifiedBefore.IsZero() || t.Before(s.ModifiedBefore)
+}
+
+// sizeIncluded reports whether a file of the given size passes the size
+// filters.
+func (s *Scanner) sizeIncluded(size int64) bool {
+	if size == 0 {
+		return s.IncludeEmpty
+	}
+	if size < s.MinSize {
+		return false
+	}
+	if s.MaxSize > 0 && size > s.MaxSize {
+		return false
+	}
+	return true
+}
+
+// excluded returns the first of the Exclude patterns that path matches, or
+// "" if none does. Patterns without a slash match the entry's name;
+// patterns with a slash match its slash-separated path relative to root.
+func (s *Scanner) excluded(root string, path string, info os.FileInfo) string {
+	var rel string
+	for _, p := range s.Exclude {
+		name := info.Name()
+		if strings.Contains(p, "/") {
+			if rel == "" {
+				r, err := filepath.Rel(root, path)
+				if err != nil {
+					continue
+				}
+				rel = filepath.ToSlash(r)
+			}
+			name = rel
+		}
+		if ok, _ := filepath.Match(p, name); ok {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package dupes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a single member of a duplicate group.
+type File struct {
+	Path string
+	// Root is the scanned root the file was found under.
+	Root    string
+	Size    int64
+	ModTime time.Time
+	// Reference is set if the file was found under one of the Scanner's
+	// References, and so must not be acted on.
+	Reference bool
+	// Links lists the other paths found that refer to this same file, as
+	// hard links or followed symlinks. They share its storage, so are not
+	// counted as duplicates.
+	Links []string
+	// Archive is the path of the archive the file was found in, if it is a
+	// member of one rather than a file of its own, s3://bucket for an
+	// object in an S3 bucket, sftp://user@host or agent://user@host for
+	// a file on another host, or the image and layer, as in
+	// oci:build/image!layer2, for a file in a container image. Such files
+	// cannot be acted on.
+	Archive string
+}
+
+// DupeGroup is a set of files with identical content.
+type DupeGroup struct {
+	// ID identifies the group across runs; see groupID.
+	ID string
+	// Hash is the content hash shared by every file in the group.
+	Hash  string
+	Files []File
+}
+
+// Size returns the size in bytes of each file in the group.
+func (g *DupeGroup) Size() int64 {
+	if len(g.Files) == 0 {
+		return 0
+	}
+	return g.Files[0].Size
+}
+
+// Duplicates returns the number of files in the group that are redundant:
+// every file outside the references if the group contains reference files,
+// and all but one file otherwise.
+func (g *DupeGroup) Duplicates() int {
+	refs := 0
+	for _, f := range g.Files {
+		if f.Reference {
+			refs++
+		}
+	}
+	if refs > 0 {
+		return len(g.Files) - refs
+	}
+	if len(g.Files) < 2 {
+		return 0
+	}
+	return len(g.Files) - 1
+}
+
+// WastedBytes returns the space that could be reclaimed by removing the
+// group's duplicates.
+func (g *DupeGroup) WastedBytes() int64 {
+	return g.Size() * int64(g.Duplicates())
+}
+
+// groupID derives an identifier for a duplicate group that stays stable
+// across runs and as members join the group. It is the first 16 hex digits
+// of the SHA-256 of the content hash, a colon, and the group's distinct file
+// sizes in ascending decimal order joined by commas, e.g. "<hash>:1024".
+func groupID(hash string, files []File) string {
+	seen := make(map[int64]bool)
+	var sizes []int64
+	for _, f := range files {
+		if seen[f.Size] {
+			continue
+		}
+		seen[f.Size] = true
+		sizes = append(sizes, f.Size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	sizeStrings := make([]string, len(sizes))
+	for i, size := range sizes {
+		sizeStrings[i] = strconv.FormatInt(size, 10)
+	}
+	sum := sha256.Sum256([]byte(hash + ":" + strings.Join(sizeStrings, ",")))
+	return hex.EncodeToString(sum[:8])
+}
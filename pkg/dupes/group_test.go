@@ -0,0 +1,37 @@
+package dupes
+
+import "testing"
+
+func TestGroupID(t *testing.T) {
+	files := func(sizes ...int64) []File {
+		var fs []File
+		for _, size := range sizes {
+			fs = append(fs, File{Size: size})
+		}
+		return fs
+	}
+	for _, tc := range []struct {
+		hash  string
+		files []File
+		want  string
+	}{
+		// The first 16 hex digits of the SHA-256 of "abc:1024".
+		{"abc", files(1024), "208c50d05bd18fa2"},
+		{"abc", files(1024, 1024, 1024), "208c50d05bd18fa2"},
+		// Sizes are listed once each, in ascending order.
+		{"abc", files(10, 5, 10), "1414c93a0b3e1c1f"},
+		{"abc", files(5, 10), "1414c93a0b3e1c1f"},
+		{"abc", nil, "16a3ed4144494134"},
+	} {
+		if got := groupID(tc.hash, tc.files); got != tc.want {
+			t.Errorf("groupID(%q, %v) = %s; want %s", tc.hash, tc.files, got, tc.want)
+		}
+	}
+
+	if groupID("abc", files(1024)) == groupID("abd", files(1024)) {
+		t.Error("groupID does not depend on the hash")
+	}
+	if groupID("abc", files(1024)) == groupID("abc", files(1025)) {
+		t.Error("groupID does not depend on the size")
+	}
+}
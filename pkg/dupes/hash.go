@@ -0,0 +1,227 @@
+package dupes
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/minio/highwayhash"
+)
+
+// Key used as seed for the HighwayHash algorithm.
+// This is hardcoded to ensure consistent hashes for files across runs;
+// Scanner.HighwayKey replaces it.
+const HH_KEY = "E9ECA1531393D174DFEA70CC5BAA4FCE5FC599D08ECB36B9961489985A64D3AE"
+
+// Default size of the buffer used when reading and hashing files.
+// This matches the buffer size io.Copy allocates internally.
+const DEFAULT_READ_BUFFER = 32 * 1024
+
+// Share of Scanner.MaxMemory, as a divisor, that read buffers may take.
+const MEMORY_BUFFER_SHARE = 4
+
+// Default hash algorithm: xxHash for speed, paired with HighwayHash so a
+// collision of a single hash cannot produce a false positive.
+const DEFAULT_HASH = "xxhash+highway"
+
+// Length in bytes of a HighwayHash key.
+const HH_KEY_SIZE = 32
+
+// hashConstructors maps the algorithm names accepted in a hash spec to
+// functions creating a new hash of that kind. Only HighwayHash uses the
+// key.
+var hashConstructors = map[string]func(key []byte) (hash.Hash, error){
+	"xxhash": func(key []byte) (hash.Hash, error) {
+		return xxhash.New64(), nil
+	},
+	"highway": func(key []byte) (hash.Hash, error) {
+		return highwayhash.New(key)
+	},
+	"md5": func(key []byte) (hash.Hash, error) {
+		return md5.New(), nil
+	},
+	"sha1": func(key []byte) (hash.Hash, error) {
+		return sha1.New(), nil
+	},
+	"sha256": func(key []byte) (hash.Hash, error) {
+		return sha256.New(), nil
+	},
+	"blake3": func(key []byte) (hash.Hash, error) {
+		return newBlake3(), nil
+	},
+}
+
+// ParseHighwayKey decodes a HighwayHash key given as 64 hex digits.
+func ParseHighwayKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != HH_KEY_SIZE {
+		return nil, fmt.Errorf("HighwayHash key must be %d hex digits", 2*HH_KEY_SIZE)
+	}
+	return key, nil
+}
+
+// ValidateHash checks that spec names one or two supported algorithms
+// joined by "+", e.g. "sha256" or "xxhash+blake3".
+func ValidateHash(spec string) error {
+	names := strings.Split(spec, "+")
+	if len(names) > 2 {
+		return fmt.Errorf("hash %q combines more than two algorithms", spec)
+	}
+	for _, name := range names {
+		if _, ok := hashConstructors[name]; !ok {
+			return fmt.Errorf("unknown hash algorithm %q", name)
+		}
+	}
+	return nil
+}
+
+// hashAlgorithm returns the scanner's hash spec, or DEFAULT_HASH if unset.
+func (s *Scanner) hashAlgorithm() string {
+	if s.Hash == "" {
+		return DEFAULT_HASH
+	}
+	return s.Hash
+}
+
+// highwayKey returns the scanner's HighwayHash key, or HH_KEY if unset.
+func (s *Scanner) highwayKey() ([]byte, error) {
+	if s.HighwayKey == nil {
+		return ParseHighwayKey(HH_KEY)
+	}
+	if len(s.HighwayKey) != HH_KEY_SIZE {
+		return nil, fmt.Errorf("HighwayHash key must be %d bytes", HH_KEY_SIZE)
+	}
+	return s.HighwayKey, nil
+}
+
+// hashIdentity names the hashes the scanner produces for the cache and
+// checkpoints. It is the HashScheme, followed by a fingerprint of the
+// HighwayHash key if a custom key is in use, so hashes made with different
+// keys are never mixed up. The fingerprint does not reveal the key.
+func (s *Scanner) hashIdentity() string {
+	scheme := s.HashScheme()
+	if s.HighwayKey == nil || !strings.Contains("+"+s.hashAlgorithm()+"+", "+highway+") {
+		return scheme
+	}
+	sum := sha256.Sum256(s.HighwayKey)
+	return scheme + "@" + hex.EncodeToString(sum[:8])
+}
+
+// newHashes returns a fresh hash for each algorithm of the scanner's hash
+// spec.
+func (s *Scanner) newHashes() ([]hash.Hash, error) {
+	key, err := s.highwayKey()
+	if err != nil {
+		return nil, err
+	}
+	return newHashesOf(s.hashAlgorithm(), key)
+}
+
+// newHashesOf returns a fresh hash for each algorithm of spec, keying
+// HighwayHash with key.
+func newHashesOf(spec string, key []byte) ([]hash.Hash, error) {
+	var hashes []hash.Hash
+	for _, name := range strings.Split(spec, "+") {
+		newHash, ok := hashConstructors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown hash algorithm %q", name)
+		}
+		h, err := newHash(key)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// copyBuffered copies src to dst through a buffer taken from the scanner's
+// pool, at no more than MaxReadRate. src is wrapped so io.CopyBuffer cannot
+// bypass the buffer via WriterTo.
+func (s *Scanner) copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	bp := s.bufferPool.Get().(*[]byte)
+	defer s.bufferPool.Put(bp)
+	return io.CopyBuffer(dst, struct{ io.Reader }{s.throttle(src)}, *bp)
+}
+
+// ctxReader is a Reader that fails with its context's error once the
+// context is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// hashFile streams the file at path through every hash of the scanner's
+// algorithm in a single pass and returns the hex digests concatenated.
+// Memory use is bounded by the read buffer regardless of the file's size,
+// unless s.Mmap maps the file instead. Files over s.ChunkThreshold are
+// hashed in chunks, in parallel. Reading stops with ctx's error if ctx is
+// cancelled.
+func (s *Scanner) hashFile(ctx context.Context, path string) (string, error) {
+	if s.ChunkThreshold > 0 {
+		if sum, chunked, err := s.hashChunkedFile(ctx, path); chunked {
+			return sum, err
+		}
+		if m := s.archives.member(path); m != nil && s.chunked(m.size) {
+			f, err := s.open(path)
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			return s.hashChunkedReader(ctxReader{ctx, f}, m.size)
+		}
+	}
+	if s.Mmap {
+		if sum, mapped, err := s.hashMapped(ctx, path); mapped {
+			return sum, err
+		}
+	}
+	f, err := s.open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return s.hashReader(ctxReader{ctx, f})
+}
+
+// hashReader returns the hash of everything read from r, as hashFile does
+// for a file.
+func (s *Scanner) hashReader(r io.Reader) (string, error) {
+	hashes, err := s.newHashes()
+	if err != nil {
+		return "", err
+	}
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
+	}
+
+	if _, err := s.copyBuffered(io.MultiWriter(writers...), r); err != nil {
+		return "", err
+	}
+
+	var digest strings.Builder
+	for _, h := range hashes {
+		digest.WriteString(hexSum(h))
+	}
+	return digest.String(), nil
+}
+
+// hexSum returns the hex digest of h.
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,97 @@
+package dupes
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkHashFile hashes one file with the default hash pair through the
+// pooled buffer of copyBuffered, with the default buffer and the sizes
+// --read-buffer is commonly given. The file is read once before timing, so
+// it is served from the page cache and the runs compare the buffer sizes
+// rather than the disk.
+func BenchmarkHashFile(b *testing.B) {
+	const size = 16 << 20
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(b.TempDir(), "file")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name   string
+		buffer int
+	}{
+		{"default", 0},
+		{"4K", 4 << 10},
+		{"256K", 256 << 10},
+		{"1M", 1 << 20},
+		{"4M", 4 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			s := &Scanner{ReadBufferSize: bc.buffer}
+			if err := s.reset(); err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+			if _, err := s.hashFile(ctx, path); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.hashFile(ctx, path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestBlake3Vectors checks blake3 against the official BLAKE3 test vectors,
+// whose input of each length is the bytes 0 to 250 repeated, written whole
+// and in pieces that split blocks and chunks unevenly. The expected hashes
+// are the first 32 bytes of the vectors' extended output.
+func TestBlake3Vectors(t *testing.T) {
+	vectors := []struct {
+		len  int
+		hash string
+	}{
+		{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{1023, "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11"},
+		{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+		{3072, "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2"},
+		{102400, "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+	}
+	for _, v := range vectors {
+		input := make([]byte, v.len)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		for _, piece := range []int{v.len, 1, 63, 64, 65, 1000, 1024, 4097} {
+			h := newBlake3()
+			for rest := input; ; {
+				n := piece
+				if n > len(rest) {
+					n = len(rest)
+				}
+				h.Write(rest[:n])
+				if rest = rest[n:]; len(rest) == 0 {
+					break
+				}
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != v.hash {
+				t.Errorf("blake3 of %d bytes written %d at a time = %s; want %s", v.len, piece, got, v.hash)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package dupes
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Name of the file listing, in gitignore syntax, the entries of its
+// directory tree that Scan skips.
+const DUPESIGNORE_FILE = ".dupesignore"
+
+// ignoreRule is a single pattern from an ignore file. The pattern is matched
+// against the slash-separated path of an entry relative to base, the
+// directory holding the ignore file. A rule from an ignore file above the
+// scanned root has base set to the root and prefix to the root's path
+// relative to the ignore file's directory.
+type ignoreRule struct {
+	base    string
+	prefix  string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// matches reports whether the rule applies to the entry at path.
+func (r ignoreRule) matches(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if r.prefix != "" {
+		rel = r.prefix + "/" + rel
+	}
+	return r.re.MatchString(rel)
+}
+
+// ignored reports whether path, found under root, is excluded by a
+// .dupesignore file or, if RespectGitignore is set, by git's ignore rules.
+// As in git, the last matching pattern decides, and patterns in deeper
+// directories take precedence.
+func (s *Scanner) ignored(root string, path string, info os.FileInfo) bool {
+	if s.RespectGitignore && info.IsDir() && info.Name() == ".git" {
+		return true
+	}
+	rules := s.ignoreRules(root, filepath.Dir(path))
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].matches(path, info.IsDir()) {
+			return !rules[i].negate
+		}
+	}
+	return false
+}
+
+// ignoreRules returns the rules that apply to the entries of dir, a
+// directory at or below root, in increasing order of precedence.
+func (s *Scanner) ignoreRules(root string, dir string) []ignoreRule {
+	root, dir = filepath.Clean(root), filepath.Clean(dir)
+	key := root + "\x00" + dir
+	if rules, ok := s.ignores[key]; ok {
+		return rules
+	}
+	if s.ignores == nil {
+		s.ignores = make(map[string][]ignoreRule)
+	}
+
+	var rules []ignoreRule
+	if parent := filepath.Dir(dir); dir == root || parent == dir {
+		rules = s.rootIgnoreRules(root)
+	} else {
+		rules = s.ignoreRules(root, parent)
+	}
+	// Copy before appending so the parent's rules are never overwritten.
+	rules = rules[:len(rules):len(rules)]
+	if s.RespectGitignore {
+		if fi, err := os.Stat(filepath.Join(dir, ".git")); err == nil && fi.IsDir() {
+			rules = append(rules, s.readIgnoreFile(filepath.Join(dir, ".git", "info", "exclude"), dir, "")...)
+		}
+		rules = append(rules, s.readIgnoreFile(filepath.Join(dir, ".gitignore"), dir, "")...)
+	}
+	rules = append(rules, s.readIgnoreFile(filepath.Join(dir, DUPESIGNORE_FILE), dir, "")...)
+	s.ignores[key] = rules
+	return rules
+}
+
+// rootIgnoreRules returns the git rules that apply to root from outside it:
+// the user's global excludes file and, if root is below the top of a git
+// work tree, the repository's exclude file and the .gitignore files of the
+// directories from the top of the work tree down to root's parent.
+func (s *Scanner) rootIgnoreRules(root string) []ignoreRule {
+	if !s.RespectGitignore {
+		return nil
+	}
+	var rules []ignoreRule
+	if global := globalGitignore(); global != "" {
+		rules = append(rules, s.readIgnoreFile(global, root, "")...)
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil || exists(filepath.Join(abs, ".git")) {
+		return rules
+	}
+	var above []string
+	top := filepath.Dir(abs)
+	for !exists(filepath.Join(top, ".git")) {
+		if top == filepath.Dir(top) {
+			// Not in a work tree.
+			return rules
+		}
+		above = append(above, top)
+		top = filepath.Dir(top)
+	}
+	above = append(above, top)
+	if fi, err := os.Stat(filepath.Join(top, ".git")); err == nil && fi.IsDir() {
+		rules = append(rules, s.readIgnoreFile(filepath.Join(top, ".git", "info", "exclude"), root, relPrefix(top, abs))...)
+	}
+	for i := len(above) - 1; i >= 0; i-- {
+		rules = append(rules, s.readIgnoreFile(filepath.Join(above[i], ".gitignore"), root, relPrefix(above[i], abs))...)
+	}
+	return rules
+}
+
+// exists reports whether there is a file or directory at path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// relPrefix returns the slash-separated path of dir relative to base.
+func relPrefix(base string, dir string) string {
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// globalGitignore returns the path of git's default global excludes file,
+// or "" if there is none.
+func globalGitignore() string {
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		config = filepath.Join(home, ".config")
+	}
+	return filepath.Join(config, "git", "ignore")
+}
+
+// readIgnoreFile parses the ignore file at path into rules relative to
+// base. A missing file has no rules; one that cannot be read is reported
+// and ignored.
+func (s *Scanner) readIgnoreFile(path string, base string, prefix string) []ignoreRule {
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.skip(path, "read", err)
+		}
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		if r, ok := parseIgnoreLine(lines.Text()); ok {
+			r.base, r.prefix = base, prefix
+			rules = append(rules, r)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		s.skip(path, "read", err)
+	}
+	return rules
+}
+
+// parseIgnoreLine parses a line of an ignore file in gitignore syntax.
+// Blank lines and comments yield no rule.
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	var r ignoreRule
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return r, false
+	}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return r, false
+	}
+	// A pattern with a slash other than at its end is relative to the
+	// ignore file's directory; any other matches a name at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/") && (i == 0 || line[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case line[i:] == "**" && i > 0 && line[i-1] == '/':
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			re.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return r, false
+	}
+	r.re = compiled
+	return r, true
+}
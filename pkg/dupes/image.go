@@ -0,0 +1,336 @@
+package dupes
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Prefixes of the roots that name a container image, as skopeo names them:
+// an OCI image layout directory, as in "oci:build/image", or
+// "oci:build/image:v2" for the image tagged v2 in it; a tarball written by
+// docker save, as in "docker-archive:app.tar"; or an image of the local
+// Docker daemon, as in "docker-daemon:nginx:1.27", which is saved to a
+// temporary file first.
+const (
+	OCI_SCHEME            = "oci:"
+	DOCKER_ARCHIVE_SCHEME = "docker-archive:"
+	DOCKER_DAEMON_SCHEME  = "docker-daemon:"
+)
+
+// Annotation of an OCI index entry giving the image's tag.
+const ociRefName = "org.opencontainers.image.ref.name"
+
+// Digests of OCI blobs, which name their files under blobs/.
+var ociDigest = regexp.MustCompile(`^([a-z0-9]+):([a-f0-9]+)$`)
+
+// IsImage reports whether path is a container image root, or a file in
+// one, rather than a local path.
+func IsImage(path string) bool {
+	return strings.HasPrefix(path, OCI_SCHEME) || strings.HasPrefix(path, DOCKER_ARCHIVE_SCHEME) || strings.HasPrefix(path, DOCKER_DAEMON_SCHEME)
+}
+
+// imageLayer is where a layer of an image is stored: the whole file, or
+// length bytes from start within a docker save tarball.
+type imageLayer struct {
+	file   string
+	start  int64
+	length int64
+}
+
+// ociDescriptor is an entry of an OCI index or image manifest.
+type ociDescriptor struct {
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+// ociManifest is an OCI index, listing manifests, or an image manifest,
+// listing layers.
+type ociManifest struct {
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// dockerManifest is an entry of the manifest.json of a docker save tarball.
+type dockerManifest struct {
+	Layers []string `json:"Layers"`
+}
+
+// walkImage adds the files in the layers of the image root to the walk,
+// as members of the archive root!layerN, the bottom layer being layer1,
+// so that files duplicated between layers, such as those a later layer
+// overwrites, are found as well as copies of local files. Whiteouts,
+// which mark files a layer deletes, are skipped.
+func (w *walker) walkImage(root string) error {
+	s := w.s
+	layers, err := s.imageLayers(root)
+	if err != nil {
+		return err
+	}
+	for i, l := range layers {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		archive := root + ARCHIVE_SEPARATOR + "layer" + strconv.Itoa(i+1)
+		if err := w.walkLayer(root, archive, l); err != nil {
+			s.skip(archive, "read", err)
+		}
+	}
+	return nil
+}
+
+// walkLayer adds the files of the image layer l to the walk.
+func (w *walker) walkLayer(root string, archive string, l imageLayer) error {
+	if l.start > 0 && l.length == 0 {
+		// An empty layer within a tarball, not to be read to its end.
+		return nil
+	}
+	f, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, l.start); err != nil && err != io.EOF {
+		return err
+	}
+	kind := archiveTar
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		kind = archiveTarGz
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return errors.New("dupes: zstd-compressed layers are not supported")
+	}
+	return w.walkTarSection(f, l.start, l.length, kind, func(m *archiveMember, hdr *tar.Header) {
+		if strings.HasPrefix(path.Base(m.name), ".wh.") || !w.s.memberIncluded(m, hdr.ModTime) {
+			return
+		}
+		w.addMember(root, archive+ARCHIVE_SEPARATOR+strings.TrimPrefix(m.name, "./"), m, hdr.ModTime)
+		w.files[len(w.files)-1].file.Archive = archive
+	})
+}
+
+// imageLayers returns the layers of the image root, bottom first, saving
+// it from the Docker daemon if need be.
+func (s *Scanner) imageLayers(root string) ([]imageLayer, error) {
+	switch {
+	case strings.HasPrefix(root, OCI_SCHEME):
+		dir := strings.TrimPrefix(root, OCI_SCHEME)
+		tag := ""
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if i := strings.LastIndex(dir, ":"); i > 0 && !strings.ContainsAny(dir[i:], `/\`) {
+				dir, tag = dir[:i], dir[i+1:]
+			}
+		}
+		return ociLayers(dir, tag)
+	case strings.HasPrefix(root, DOCKER_ARCHIVE_SCHEME):
+		return dockerArchiveLayers(strings.TrimPrefix(root, DOCKER_ARCHIVE_SCHEME))
+	}
+	p, err := s.archives.saveImage(strings.TrimPrefix(root, DOCKER_DAEMON_SCHEME))
+	if err != nil {
+		return nil, err
+	}
+	return dockerArchiveLayers(p)
+}
+
+// saveImage saves the image ref from the Docker daemon to a temporary file
+// with docker save, or DUPES_DOCKER_COMMAND save, returning its path. The
+// file is removed when the archives are closed.
+func (a *archives) saveImage(ref string) (string, error) {
+	f, err := os.CreateTemp("", "dupes-image-*.tar")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	a.mu.Lock()
+	a.temps = append(a.temps, f.Name())
+	a.mu.Unlock()
+
+	args := []string{"docker"}
+	if command := strings.Fields(os.Getenv("DUPES_DOCKER_COMMAND")); len(command) > 0 {
+		args = command
+	}
+	args = append(args, "save", "-o", f.Name(), ref)
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("dupes: saving image %s: %v: %s", ref, err, msg)
+		}
+		return "", fmt.Errorf("dupes: saving image %s: %v", ref, err)
+	}
+	return f.Name(), nil
+}
+
+// ociLayers returns the layers of the image in the OCI layout at dir
+// tagged tag, or of its only or first image if tag is empty. Of an image
+// built for several platforms, the one for this machine is taken.
+func ociLayers(dir string, tag string) ([]imageLayer, error) {
+	var m ociManifest
+	if err := readJSONFile(filepath.Join(dir, "index.json"), &m); err != nil {
+		return nil, err
+	}
+	for depth := 0; len(m.Manifests) > 0; depth++ {
+		if depth == 4 {
+			return nil, fmt.Errorf("dupes: the indexes of %s are nested too deeply", dir)
+		}
+		d, err := chooseManifest(m.Manifests, tag)
+		if err != nil {
+			return nil, fmt.Errorf("dupes: %s: %v", dir, err)
+		}
+		// Only the top index names tags.
+		tag = ""
+		blob, err := ociBlob(dir, d.Digest)
+		if err != nil {
+			return nil, err
+		}
+		m = ociManifest{}
+		if err := readJSONFile(blob, &m); err != nil {
+			return nil, err
+		}
+	}
+	if tag != "" {
+		return nil, fmt.Errorf("dupes: %s has no image tagged %s", dir, tag)
+	}
+	var layers []imageLayer
+	for _, d := range m.Layers {
+		blob, err := ociBlob(dir, d.Digest)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, imageLayer{file: blob})
+	}
+	return layers, nil
+}
+
+// chooseManifest returns the entry of an OCI index tagged tag, or, if tag
+// is empty, the one for this platform or else the first for any platform.
+func chooseManifest(manifests []ociDescriptor, tag string) (ociDescriptor, error) {
+	if tag != "" {
+		for _, d := range manifests {
+			if d.Annotations[ociRefName] == tag {
+				return d, nil
+			}
+		}
+		return ociDescriptor{}, fmt.Errorf("no image tagged %s", tag)
+	}
+	for _, d := range manifests {
+		if d.Platform != nil && d.Platform.OS == runtime.GOOS && d.Platform.Architecture == runtime.GOARCH {
+			return d, nil
+		}
+	}
+	for _, d := range manifests {
+		// Attestations built alongside an image have an unknown platform.
+		if d.Platform == nil || d.Platform.OS != "unknown" {
+			return d, nil
+		}
+	}
+	return manifests[0], nil
+}
+
+// ociBlob returns the path of the blob with digest in the OCI layout at
+// dir.
+func ociBlob(dir string, digest string) (string, error) {
+	match := ociDigest.FindStringSubmatch(digest)
+	if match == nil {
+		return "", fmt.Errorf("dupes: %s: invalid digest %q", dir, digest)
+	}
+	return filepath.Join(dir, "blobs", match[1], match[2]), nil
+}
+
+// readJSONFile decodes the JSON file at p into v.
+func readJSONFile(p string, v interface{}) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("dupes: %s: %v", p, err)
+	}
+	return nil
+}
+
+// dockerArchiveLayers returns the layers of the first image in the docker
+// save tarball at p, which are tars stored uncompressed within it, or
+// compressed ones by newer versions of Docker.
+func dockerArchiveLayers(p string) ([]imageLayer, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	type entry struct {
+		start, length int64
+		link          string
+	}
+	entries := make(map[string]entry)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == tar.ErrHeader && len(entries) == 0 {
+			return nil, fmt.Errorf("dupes: %s is not a docker save tarball; decompress it first if it is compressed", p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			start, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			entries[name] = entry{start: start, length: hdr.Size}
+		case tar.TypeSymlink:
+			// Layers shared by several images are linked to one copy.
+			entries[name] = entry{link: path.Join(path.Dir(name), hdr.Linkname)}
+		}
+	}
+
+	resolve := func(name string) (entry, bool) {
+		e, ok := entries[path.Clean(name)]
+		if ok && e.link != "" {
+			e, ok = entries[e.link]
+		}
+		return e, ok && e.link == ""
+	}
+	manifest, ok := resolve("manifest.json")
+	if !ok {
+		return nil, fmt.Errorf("dupes: %s has no manifest.json", p)
+	}
+	var images []dockerManifest
+	if err := json.NewDecoder(io.NewSectionReader(f, manifest.start, manifest.length)).Decode(&images); err != nil {
+		return nil, fmt.Errorf("dupes: %s: manifest.json: %v", p, err)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("dupes: %s holds no image", p)
+	}
+	var layers []imageLayer
+	for _, name := range images[0].Layers {
+		e, ok := resolve(name)
+		if !ok {
+			return nil, fmt.Errorf("dupes: %s has no layer %s", p, name)
+		}
+		layers = append(layers, imageLayer{file: p, start: e.start, length: e.length})
+	}
+	return layers, nil
+}
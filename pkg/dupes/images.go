@@ -0,0 +1,311 @@
+package dupes
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Default perceptual hash algorithm used by SimilarImages.
+const DEFAULT_IMAGE_HASH = "dhash"
+
+// Default similarity, from 0 to 1, above which SimilarImages groups two
+// images: at most 6 of the 64 bits of their perceptual hashes differ.
+const DEFAULT_IMAGE_SIMILARITY = 0.9
+
+// imageHashes maps the names accepted by SimilarImages to functions
+// computing a 64-bit perceptual hash of an image.
+var imageHashes = map[string]func(image.Image) uint64{
+	"dhash": dHash,
+	"phash": pHash,
+}
+
+// imageExts lists the extensions of the image formats that can be decoded.
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// ImageGroup is a set of images that look alike although their content
+// differs, such as resized or re-encoded copies of a photo.
+type ImageGroup struct {
+	Files []File
+	// Similarity is the lowest similarity, from 0 to 1, between two images
+	// of the group.
+	Similarity float64
+}
+
+// ValidateImageHash checks that name is a supported perceptual hash.
+func ValidateImageHash(name string) error {
+	if _, ok := imageHashes[name]; !ok {
+		return fmt.Errorf("unknown image hash %q", name)
+	}
+	return nil
+}
+
+// SimilarImages returns the groups of JPEG, PNG and GIF images found by the
+// most recent scan whose perceptual hashes are at least minSimilarity
+// alike, computed with the named algorithm ("dhash" or "phash"), in walk
+// order. Groups whose files are all exact duplicates of each other are left
+// out, as Scan already reports them. Images that cannot be decoded are
+// reported to OnError and skipped.
+func (s *Scanner) SimilarImages(ctx context.Context, algorithm string, minSimilarity float64) ([]ImageGroup, error) {
+	if err := ValidateImageHash(algorithm); err != nil {
+		return nil, err
+	}
+	phash := imageHashes[algorithm]
+	maxDistance := int(math.Floor((1 - minSimilarity) * 64))
+
+	var images []candidate
+	for _, c := range s.files {
+		if c.file.Archive == "" && imageExts[strings.ToLower(filepath.Ext(c.file.Path))] {
+			images = append(images, c)
+		}
+	}
+	queue := make(chan candidate)
+	go func() {
+		defer close(queue)
+		for _, c := range images {
+			select {
+			case queue <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	hashes := make(map[int64]uint64)
+	hash := func(c candidate) hashResult {
+		h, err := imageHashFile(c.file.Path, phash)
+		return hashResult{candidate: c, hash: strconv.FormatUint(h, 16), op: "decode", err: err}
+	}
+	s.hashAll(ctx, queue, hash, func(r hashResult) {
+		if r.err != nil {
+			s.skip(r.file.Path, r.op, r.err)
+			return
+		}
+		hashes[r.seq], _ = strconv.ParseUint(r.hash, 16, 64)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Join each image to every earlier one within maxDistance, finding
+	// them with a BK-tree rather than comparing every pair.
+	set := make(disjointSet)
+	var tree *bkNode
+	for _, c := range images {
+		h, ok := hashes[c.seq]
+		if !ok {
+			continue
+		}
+		set.add(c.seq)
+		for _, near := range tree.query(h, maxDistance) {
+			set.union(near, c.seq)
+		}
+		tree = tree.insert(h, c.seq)
+	}
+
+	var groups []ImageGroup
+	for _, cands := range set.groups(images) {
+		if s.allSameContent(cands) {
+			continue
+		}
+		g := ImageGroup{Similarity: 1}
+		for i, a := range cands {
+			g.Files = append(g.Files, a.file)
+			for _, b := range cands[i+1:] {
+				if sim := similarity(hashes[a.seq], hashes[b.seq]); sim < g.Similarity {
+					g.Similarity = sim
+				}
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// allSameContent reports whether the scan found every file of cands to have
+// the same hash.
+func (s *Scanner) allSameContent(cands []candidate) bool {
+	first, ok := s.hashes[cands[0].file.Path]
+	if !ok {
+		return false
+	}
+	for _, c := range cands[1:] {
+		if h, ok := s.hashes[c.file.Path]; !ok || h != first {
+			return false
+		}
+	}
+	return true
+}
+
+// similarity returns the fraction of bits that two perceptual hashes share.
+func similarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+// imageHashFile decodes the image at path and returns its perceptual hash.
+func imageHashFile(path string, phash func(image.Image) uint64) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, &os.PathError{Op: "decode", Path: path, Err: err}
+	}
+	return phash(img), nil
+}
+
+// grayscale shrinks img to w by h pixels, averaging the luminance of the
+// pixels each one covers.
+func grayscale(img image.Image, w, h int) [][]float64 {
+	b := img.Bounds()
+	luma := func(x, y int) float64 {
+		switch m := img.(type) {
+		case *image.YCbCr:
+			return float64(m.Y[m.YOffset(x, y)])
+		case *image.Gray:
+			return float64(m.Pix[m.PixOffset(x, y)])
+		}
+		r, g, bl, _ := img.At(x, y).RGBA()
+		return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+	}
+	out := make([][]float64, h)
+	for ty := 0; ty < h; ty++ {
+		out[ty] = make([]float64, w)
+		y0 := b.Min.Y + ty*b.Dy()/h
+		y1 := b.Min.Y + (ty+1)*b.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+		for tx := 0; tx < w; tx++ {
+			x0 := b.Min.X + tx*b.Dx()/w
+			x1 := b.Min.X + (tx+1)*b.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+			var sum float64
+			for y := y0; y < y1 && y < b.Max.Y; y++ {
+				for x := x0; x < x1 && x < b.Max.X; x++ {
+					sum += luma(x, y)
+				}
+			}
+			out[ty][tx] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
+
+// dHash is the difference hash: each bit records whether a pixel of the
+// image shrunk to 9x8 is brighter than its right-hand neighbour.
+func dHash(img image.Image) uint64 {
+	px := grayscale(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if px[y][x] > px[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// pHash is the DCT-based perceptual hash: each bit records whether one of
+// the 8x8 lowest frequencies of the image shrunk to 32x32 is above their
+// mean, leaving out the constant term.
+func pHash(img image.Image) uint64 {
+	const n = 32
+	px := grayscale(img, n, n)
+	var cos [8][n]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	var coeffs [64]float64
+	var sum float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var c float64
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					c += px[y][x] * cos[u][x] * cos[v][y]
+				}
+			}
+			coeffs[v*8+u] = c
+			if u != 0 || v != 0 {
+				sum += c
+			}
+		}
+	}
+	mean := sum / 63
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > mean {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// bkNode is a node of a BK-tree of perceptual hashes, which finds the
+// hashes within a Hamming distance of another without checking them all.
+type bkNode struct {
+	hash     uint64
+	seq      int64
+	children map[int]*bkNode
+}
+
+// insert adds hash to the tree and returns its root.
+func (n *bkNode) insert(hash uint64, seq int64) *bkNode {
+	if n == nil {
+		return &bkNode{hash: hash, seq: seq}
+	}
+	for node := n; ; {
+		d := bits.OnesCount64(node.hash ^ hash)
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{hash: hash, seq: seq}
+			return n
+		}
+		node = child
+	}
+}
+
+// query returns the seq of every hash in the tree within maxDistance of
+// hash.
+func (n *bkNode) query(hash uint64, maxDistance int) []int64 {
+	if n == nil {
+		return nil
+	}
+	var found []int64
+	stack := []*bkNode{n}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := bits.OnesCount64(node.hash ^ hash)
+		if d <= maxDistance {
+			found = append(found, node.seq)
+		}
+		for cd, child := range node.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return found
+}
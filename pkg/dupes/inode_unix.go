@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package dupes
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the device and inode numbers of the file described by info.
+func fileID(path string, info os.FileInfo) (dev uint64, ino uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Ino)
+	}
+	return 0, 0
+}
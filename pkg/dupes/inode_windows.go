@@ -0,0 +1,31 @@
+package dupes
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the volume serial number and file index of the file at
+// path, the Windows equivalent of a device and inode number. os.FileInfo
+// does not expose them, so the file is opened to query them. It returns
+// zeros if the file cannot be opened.
+func fileID(path string, info os.FileInfo) (dev uint64, ino uint64) {
+	p, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, 0
+	}
+	h, err := syscall.CreateFile(p, 0,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_BACKUP_SEMANTICS|syscall.FILE_FLAG_OPEN_REPARSE_POINT, 0)
+	if err != nil {
+		return 0, 0
+	}
+	defer syscall.CloseHandle(h)
+
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &d); err != nil {
+		return 0, 0
+	}
+	return uint64(d.VolumeSerialNumber), uint64(d.FileIndexHigh)<<32 | uint64(d.FileIndexLow)
+}
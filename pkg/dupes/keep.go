@@ -0,0 +1,264 @@
+package dupes
+
+import (
+	"fmt"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A Keeper chooses which file of a duplicate group is kept when the others
+// are acted on. KeepStrategy and KeepRules are Keepers.
+type Keeper interface {
+	// ChooseGroup returns the index of the file in g to keep.
+	ChooseGroup(g *DupeGroup) int
+}
+
+// A Sparer is a Keeper that also leaves some of the other files of a group
+// alone: Resolve does not act on the files Spare reports true for.
+type Sparer interface {
+	Keeper
+	Spare(f File) bool
+}
+
+// KeepStrategy decides which file of a duplicate group is kept when the
+// others are acted on.
+type KeepStrategy string
+
+const (
+	// KeepFirst keeps the first file found by the walk.
+	KeepFirst KeepStrategy = "first"
+	// KeepOldest keeps the file with the oldest modification time.
+	KeepOldest KeepStrategy = "oldest"
+	// KeepNewest keeps the file with the newest modification time.
+	KeepNewest KeepStrategy = "newest"
+	// KeepShortestPath keeps the file with the shortest path.
+	KeepShortestPath KeepStrategy = "shortest-path"
+)
+
+// ParseKeepStrategy returns the KeepStrategy named by s.
+func ParseKeepStrategy(s string) (KeepStrategy, error) {
+	switch k := KeepStrategy(s); k {
+	case KeepFirst, KeepOldest, KeepNewest, KeepShortestPath:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown keep strategy %q", s)
+}
+
+// Choose returns the index of the file in files to keep. Ties go to the file
+// found first.
+func (k KeepStrategy) Choose(files []File) int {
+	keep := 0
+	for i, f := range files[1:] {
+		i++
+		switch k {
+		case KeepOldest:
+			if f.ModTime.Before(files[keep].ModTime) {
+				keep = i
+			}
+		case KeepNewest:
+			if f.ModTime.After(files[keep].ModTime) {
+				keep = i
+			}
+		case KeepShortestPath:
+			if len(f.Path) < len(files[keep].Path) {
+				keep = i
+			}
+		}
+	}
+	return keep
+}
+
+// ChooseGroup returns the index of the file in g to keep. If g contains
+// reference files, the kept file is chosen among them, and otherwise among
+// its archive members, if any.
+func (k KeepStrategy) ChooseGroup(g *DupeGroup) int {
+	return KeepRules{Strategy: k}.ChooseGroup(g)
+}
+
+// KeepRules chooses the file to keep by the directories the files are in,
+// and then by Strategy among the files ranked highest. It can also spare
+// files by pattern, so it is a Sparer.
+type KeepRules struct {
+	Strategy KeepStrategy
+	// Protect lists glob patterns, as understood by filepath.Match, for
+	// files that are never acted on. A protected file is kept rather than
+	// the others whatever the other rules prefer. A pattern without a
+	// slash matches any file or directory name in the path; one with a
+	// slash matches the absolute path of the file or of a directory it is
+	// in, so "/photos/originals" protects everything below it.
+	Protect []string
+	// Prefer lists directories whose files are kept rather than those
+	// elsewhere, most preferred first.
+	Prefer []string
+	// Avoid lists directories whose files are only kept if every file of
+	// the group is in one of them.
+	Avoid []string
+	// KeepMatch lists patterns for paths that are never acted on. A file
+	// matching one is kept rather than the others.
+	KeepMatch []*regexp.Regexp
+	// DeleteMatch, if not empty, lists patterns for the only paths that are
+	// acted on. The file kept is chosen among those matching none of them,
+	// or among them all if every file matches, so one always survives.
+	DeleteMatch []*regexp.Regexp
+}
+
+// ChooseGroup returns the index of the file in g to keep. As with
+// KeepStrategy.ChooseGroup, a reference file or else an archive member is
+// kept if g has any; the rules then choose among those.
+func (r KeepRules) ChooseGroup(g *DupeGroup) int {
+	idx := make([]int, len(g.Files))
+	for i := range idx {
+		idx[i] = i
+	}
+	for _, preferred := range []func(File) bool{
+		func(f File) bool { return f.Reference },
+		r.Protected,
+		func(f File) bool { return f.Archive != "" },
+		func(f File) bool { return matchesAny(r.KeepMatch, f.Path) },
+		func(f File) bool { return len(r.DeleteMatch) > 0 && !matchesAny(r.DeleteMatch, f.Path) },
+	} {
+		var matched []int
+		for _, i := range idx {
+			if preferred(g.Files[i]) {
+				matched = append(matched, i)
+			}
+		}
+		if len(matched) > 0 {
+			idx = matched
+			break
+		}
+	}
+
+	if len(r.Prefer) > 0 || len(r.Avoid) > 0 {
+		best := -1
+		var ranked []int
+		for _, i := range idx {
+			rank := r.rank(g.Files[i].Path)
+			if best < 0 || rank < best {
+				best = rank
+				ranked = ranked[:0]
+			}
+			if rank == best {
+				ranked = append(ranked, i)
+			}
+		}
+		idx = ranked
+	}
+
+	files := make([]File, len(idx))
+	for j, i := range idx {
+		files[j] = g.Files[i]
+	}
+	return idx[r.Strategy.Choose(files)]
+}
+
+// Spare reports whether f is left alone whichever file is kept, because it
+// is protected, matches KeepMatch or does not match DeleteMatch.
+func (r KeepRules) Spare(f File) bool {
+	return r.Protected(f) || matchesAny(r.KeepMatch, f.Path) ||
+		len(r.DeleteMatch) > 0 && !matchesAny(r.DeleteMatch, f.Path)
+}
+
+// Protected reports whether f, or one of its Links, matches one of the
+// Protect patterns. Its hard links share its content, so acting on any of
+// them would change or delete a protected path.
+func (r KeepRules) Protected(f File) bool {
+	if len(r.Protect) == 0 {
+		return false
+	}
+	if r.protectedPath(f.Path) {
+		return true
+	}
+	for _, link := range f.Links {
+		if r.protectedPath(link) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectedPath reports whether path matches one of the Protect patterns.
+func (r KeepRules) protectedPath(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	abs = filepath.ToSlash(abs)
+	for _, p := range r.Protect {
+		p = filepath.ToSlash(p)
+		if !strings.Contains(p, "/") {
+			for _, name := range strings.Split(abs, "/") {
+				if ok, _ := pathpkg.Match(p, name); ok && name != "" {
+					return true
+				}
+			}
+			continue
+		}
+		if !pathpkg.IsAbs(p) {
+			if a, err := filepath.Abs(filepath.FromSlash(p)); err == nil {
+				p = filepath.ToSlash(a)
+			}
+		}
+		for dir, parent := abs, ""; dir != parent; dir, parent = pathpkg.Dir(dir), dir {
+			if ok, _ := pathpkg.Match(p, dir); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllProtected reports whether every file of g, with its links, is
+// protected, so no file of it can be acted on.
+func (r KeepRules) AllProtected(g *DupeGroup) bool {
+	for _, f := range g.Files {
+		if !r.Protected(f) {
+			return false
+		}
+	}
+	return len(r.Protect) > 0
+}
+
+// matchesAny reports whether path matches one of patterns.
+func matchesAny(patterns []*regexp.Regexp, path string) bool {
+	for _, p := range patterns {
+		if p.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// rank orders the file at path by the rules, lowest first: its position in
+// Prefer if it is in one of those directories, then files in neither list,
+// then files in an Avoid directory.
+func (r KeepRules) rank(path string) int {
+	for i, dir := range r.Prefer {
+		if within(dir, path) {
+			return i
+		}
+	}
+	for _, dir := range r.Avoid {
+		if within(dir, path) {
+			return len(r.Prefer) + 1
+		}
+	}
+	return len(r.Prefer)
+}
+
+// within reports whether path is inside the directory dir, comparing their
+// absolute paths.
+func within(dir string, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
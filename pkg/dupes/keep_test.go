@@ -0,0 +1,111 @@
+package dupes
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestKeepRulesChooseGroup(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	group := &DupeGroup{Files: []File{
+		{Path: "/backup/photos/a.jpg", ModTime: day(3)},
+		{Path: "/home/me/photos/originals/a.jpg", ModTime: day(2)},
+		{Path: "/home/me/a.jpg", ModTime: day(4)},
+		{Path: "/tmp/downloads/a copy.jpg", ModTime: day(1)},
+	}}
+	res := func(patterns ...string) []*regexp.Regexp {
+		var list []*regexp.Regexp
+		for _, p := range patterns {
+			list = append(list, regexp.MustCompile(p))
+		}
+		return list
+	}
+	for _, tc := range []struct {
+		name  string
+		rules KeepRules
+		want  int
+	}{
+		{"first", KeepRules{}, 0},
+		{"oldest", KeepRules{Strategy: KeepOldest}, 3},
+		{"newest", KeepRules{Strategy: KeepNewest}, 2},
+		{"shortest path", KeepRules{Strategy: KeepShortestPath}, 2},
+		{"prefer", KeepRules{Prefer: []string{"/home/me"}}, 1},
+		{"prefer the first directory given", KeepRules{Prefer: []string{"/tmp", "/home/me"}}, 3},
+		{"prefer, then the strategy", KeepRules{Strategy: KeepNewest, Prefer: []string{"/home/me"}}, 2},
+		{"avoid", KeepRules{Avoid: []string{"/backup", "/home"}}, 3},
+		{"keep match", KeepRules{Strategy: KeepNewest, KeepMatch: res("copy")}, 3},
+		{"delete match", KeepRules{DeleteMatch: res("^/backup/", "^/tmp/")}, 1},
+		{"delete match of every file", KeepRules{Strategy: KeepOldest, DeleteMatch: res(".")}, 3},
+		{"protect by name", KeepRules{Strategy: KeepNewest, Protect: []string{"originals"}}, 1},
+		{"protect by path", KeepRules{Protect: []string{"/tmp/downloads"}}, 3},
+		{"protect by glob", KeepRules{Protect: []string{"* copy.jpg"}}, 3},
+		{"protect over prefer", KeepRules{Prefer: []string{"/backup"}, Protect: []string{"/home/*/photos"}}, 1},
+		{"protect nothing", KeepRules{Protect: []string{"*.png", "/srv"}}, 0},
+	} {
+		if got := tc.rules.ChooseGroup(group); got != tc.want {
+			t.Errorf("%s: ChooseGroup = %d (%s); want %d (%s)", tc.name, got, group.Files[got].Path, tc.want, group.Files[tc.want].Path)
+		}
+	}
+}
+
+func TestKeepRulesReference(t *testing.T) {
+	g := &DupeGroup{Files: []File{
+		{Path: "/home/me/originals/a.jpg"},
+		{Path: "/ref/a.jpg", Reference: true},
+		{Path: "/archive.zip!a.jpg", Archive: "/archive.zip"},
+	}}
+	rules := KeepRules{Protect: []string{"originals"}}
+	if got := rules.ChooseGroup(g); got != 1 {
+		t.Errorf("ChooseGroup = %d; want the reference file, 1", got)
+	}
+	g.Files[1].Reference = false
+	if got := rules.ChooseGroup(g); got != 0 {
+		t.Errorf("ChooseGroup = %d; want the protected file, 0", got)
+	}
+	if got := (KeepRules{}).ChooseGroup(g); got != 2 {
+		t.Errorf("ChooseGroup = %d; want the archive member, 2", got)
+	}
+}
+
+func TestKeepRulesSpare(t *testing.T) {
+	rules := KeepRules{
+		Protect:     []string{"/srv/keep", "*.raw"},
+		KeepMatch:   []*regexp.Regexp{regexp.MustCompile("_originals/")},
+		DeleteMatch: []*regexp.Regexp{regexp.MustCompile("^/tmp/"), regexp.MustCompile("^/srv/")},
+	}
+	for _, tc := range []struct {
+		path      string
+		protected bool
+		spare     bool
+	}{
+		{"/tmp/a.jpg", false, false},
+		{"/tmp/a.raw", true, true},
+		{"/srv/keep/a.jpg", true, true},
+		{"/srv/keep/sub/a.jpg", true, true},
+		{"/srv/keeper/a.jpg", false, false},
+		{"/tmp/_originals/a.jpg", false, true},
+		{"/home/me/a.jpg", false, true},
+	} {
+		f := File{Path: tc.path}
+		if got := rules.Protected(f); got != tc.protected {
+			t.Errorf("Protected(%s) = %v; want %v", tc.path, got, tc.protected)
+		}
+		if got := rules.Spare(f); got != tc.spare {
+			t.Errorf("Spare(%s) = %v; want %v", tc.path, got, tc.spare)
+		}
+	}
+}
+
+func TestKeepRulesAllProtected(t *testing.T) {
+	g := &DupeGroup{Files: []File{{Path: "/srv/a.raw"}, {Path: "/tmp/b.raw"}}}
+	if !(KeepRules{Protect: []string{"*.raw"}}).AllProtected(g) {
+		t.Error("AllProtected = false; want true when every file matches")
+	}
+	if (KeepRules{Protect: []string{"/srv"}}).AllProtected(g) {
+		t.Error("AllProtected = true; want false when one file does not match")
+	}
+	if (KeepRules{}).AllProtected(g) {
+		t.Error("AllProtected = true; want false without Protect patterns")
+	}
+}
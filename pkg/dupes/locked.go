@@ -0,0 +1,47 @@
+package dupes
+
+import (
+	"context"
+	"time"
+)
+
+// Delay before each retry of the files found locked by another process.
+const LOCKED_RETRY_DELAY = time.Second
+
+// lockedFiles collects the files found locked by another process while
+// hashing, to be tried again once the other files are done.
+type lockedFiles struct {
+	files []candidate
+	// attempt is the number of retries made so far.
+	attempt int
+}
+
+// setAside reports whether r failed because its file is locked and there
+// are retries left, in which case the file is kept for the next one.
+func (l *lockedFiles) setAside(s *Scanner, r hashResult) bool {
+	if r.err == nil || l.attempt >= s.RetryLocked || !isLocked(r.err) {
+		return false
+	}
+	l.files = append(l.files, r.candidate)
+	return true
+}
+
+// retry hashes the files set aside again, LOCKED_RETRY_DELAY after the
+// previous attempt, until none are set aside or RetryLocked retries have
+// been made, passing each result to handle. Files still locked after the
+// last retry are handled like any other that cannot be read.
+func (l *lockedFiles) retry(ctx context.Context, s *Scanner, hash func(candidate) hashResult, handle func(hashResult)) {
+	for len(l.files) > 0 && l.attempt < s.RetryLocked {
+		l.attempt++
+		select {
+		case <-time.After(LOCKED_RETRY_DELAY):
+		case <-ctx.Done():
+			return
+		}
+		files := l.files
+		l.files = nil
+		for _, c := range files {
+			handle(hash(c))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package dupes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Manifest hashes every file under roots, not just those that could have a
+// duplicate, and passes each file's path and hash to emit, in the order
+// the files were walked. A file's hard links follow it, with the same hash.
+// The hash must be a single algorithm, and large files not chunked, so
+// that the hashes are those other tools compute. Files that cannot be
+// read are skipped and recorded in Errors, and the scan's Stats are kept
+// as for Scan.
+func (s *Scanner) Manifest(ctx context.Context, emit func(path string, sum string), roots ...string) error {
+	if strings.Contains(s.hashAlgorithm(), "+") {
+		return errors.New("dupes: a manifest needs a single hash algorithm, not " + s.hashAlgorithm())
+	}
+	if s.ChunkThreshold > 0 {
+		return errors.New("dupes: a manifest cannot hash files in chunks")
+	}
+	return s.HashEvery(ctx, func(f File, sum string) {
+		emit(f.Path, sum)
+		for _, link := range f.Links {
+			emit(link, sum)
+		}
+	}, roots...)
+}
+
+// HashEvery hashes every file under roots as Manifest does, but with any
+// hash and chunking, passing each file, with its Links, and its hash to
+// emit in walk order.
+func (s *Scanner) HashEvery(ctx context.Context, emit func(f File, sum string), roots ...string) error {
+	if s.Checkpoint != nil {
+		return errors.New("dupes: Manifest and HashEvery cannot be used with a Checkpoint")
+	}
+	start := time.Now()
+	if err := s.reset(); err != nil {
+		return err
+	}
+	defer s.archives.close()
+
+	files, err := s.walk(ctx, roots)
+	if err != nil {
+		return err
+	}
+	files = s.collapseLinks(files)
+	s.files = files
+	s.stats.WalkTime = time.Since(start)
+	hashStart := time.Now()
+	s.stats.Candidates = int64(len(files))
+	position := make(map[string]int, len(files))
+	for i, c := range files {
+		s.stats.CandidateBytes += c.file.Size
+		position[c.file.Path] = i
+	}
+
+	queue := make(chan candidate)
+	go func() {
+		defer close(queue)
+		for _, c := range files {
+			select {
+			case queue <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// Files are hashed in parallel, so each result is held until those of
+	// every file walked before it have been emitted. A skipped file is
+	// held as nil.
+	done := make(map[int]*hashResult)
+	next := 0
+	hash := func(c candidate) hashResult { return s.fullHash(ctx, c) }
+	var locked lockedFiles
+	handle := func(r hashResult) {
+		if r.err != nil && r.err == ctx.Err() {
+			return
+		}
+		if locked.setAside(s, r) {
+			return
+		}
+		s.stats.Hashed++
+		s.stats.HashedBytes += r.file.Size
+		if s.Progress != nil {
+			defer s.Progress(s.stats)
+		}
+		if r.err != nil {
+			s.skip(r.file.Path, r.op, r.err)
+			done[position[r.file.Path]] = nil
+		} else {
+			if r.cached {
+				s.stats.Cached++
+			} else if s.Cache != nil {
+				s.Cache.store(r.candidate, s.hashIdentity(), r.hash)
+			}
+			done[position[r.file.Path]] = &r
+		}
+		for {
+			held, ok := done[next]
+			if !ok {
+				break
+			}
+			delete(done, next)
+			next++
+			if held == nil {
+				continue
+			}
+			emit(held.file, held.hash)
+		}
+	}
+	s.hashAll(ctx, queue, hash, handle)
+	locked.retry(ctx, s, hash, handle)
+	s.stats.HashTime = time.Since(hashStart)
+	return ctx.Err()
+}
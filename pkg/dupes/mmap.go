@@ -0,0 +1,78 @@
+package dupes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// Smallest file that Scanner.Mmap maps into memory rather than reads. The
+// cost of mapping outweighs that of a few reads for smaller files.
+const MMAP_MIN_SIZE = 1 << 20
+
+// errChangedWhileMapped is returned if a mapped file shrinks while it is
+// hashed, which makes reading the missing pages fault.
+var errChangedWhileMapped = errors.New("file changed while it was read")
+
+// hashMapped hashes the file at path as hashFile does, but by mapping it
+// into memory, which saves copying its data through a buffer. It reports
+// false, without an error, if the file is not worth mapping or cannot be
+// mapped, so the caller should read it instead.
+func (s *Scanner) hashMapped(ctx context.Context, path string) (sum string, mapped bool, err error) {
+	if s.archives.member(path) != nil {
+		return "", false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() < MMAP_MIN_SIZE || int64(int(info.Size())) != info.Size() {
+		return "", false, nil
+	}
+	data, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return "", false, nil
+	}
+	defer unmapFile(data)
+
+	hashes, err := s.newHashes()
+	if err != nil {
+		return "", true, err
+	}
+	// A page of a file truncated meanwhile cannot be read, which would
+	// crash the program rather than fail the read.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			sum, err = "", &os.PathError{Op: "read", Path: path, Err: errChangedWhileMapped}
+		}
+	}()
+	chunk := s.ReadBufferSize
+	if chunk <= 0 {
+		chunk = DEFAULT_READ_BUFFER
+	}
+	for off := 0; off < len(data); off += chunk {
+		if err := ctx.Err(); err != nil {
+			return "", true, err
+		}
+		end := off + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		for _, h := range hashes {
+			h.Write(data[off:end])
+		}
+		if s.limiter != nil {
+			s.limiter.wait(end - off)
+		}
+	}
+	var digest strings.Builder
+	for _, h := range hashes {
+		digest.WriteString(hexSum(h))
+	}
+	return digest.String(), true, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package dupes
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile reports that files are not mapped on this platform, so they are
+// read instead.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("dupes: memory mapping is not supported")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
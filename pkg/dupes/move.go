@@ -0,0 +1,88 @@
+package dupes
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MoveAction moves duplicate files into the quarantine directory Dir, under
+// their absolute path, so that they can be reviewed and restored. For
+// example /home/me/a.jpg is moved to Dir/home/me/a.jpg, and C:\a.jpg to
+// Dir\C\a.jpg. Existing files in Dir are never overwritten.
+type MoveAction struct {
+	Dir string
+}
+
+func (m MoveAction) Apply(keep File, dupe File) error {
+	if err := checkNotSameFile(keep, dupe); err != nil {
+		return err
+	}
+	dest, err := m.Destination(dupe)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return moveFile(dupe.Path, dest)
+}
+
+// Destination returns the path dupe is moved to.
+func (m MoveAction) Destination(dupe File) (string, error) {
+	abs, err := filepath.Abs(dupe.Path)
+	if err != nil {
+		return "", err
+	}
+	vol := filepath.VolumeName(abs)
+	rel := strings.TrimLeft(abs[len(vol):], string(filepath.Separator))
+	vol = strings.Trim(strings.NewReplacer(":", "", `\`, string(filepath.Separator)).Replace(vol), string(filepath.Separator))
+	return filepath.Join(m.Dir, vol, rel), nil
+}
+
+// moveFile moves src to dest, which must not exist. If they are on different
+// filesystems the file is copied, preserving its mode and modification time,
+// and src removed once the copy is complete.
+func moveFile(src string, dest string) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return &os.PathError{Op: "move", Path: dest, Err: os.ErrExist}
+	}
+	err := os.Rename(src, dest)
+	if !isCrossDevice(err) {
+		return err
+	}
+	if err := copyFile(src, dest); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to dest, which must not exist, preserving its mode
+// and modification time. A partial copy is removed.
+func copyFile(src string, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	os.Chtimes(dest, info.ModTime(), info.ModTime())
+	return nil
+}
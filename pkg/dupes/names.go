@@ -0,0 +1,108 @@
+package dupes
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Suffixes and prefixes that copying a file, or keeping versions of it by
+// hand, adds to its name, as in "photo (1).jpg", "photo - Copy.jpg",
+// "Copy of photo.jpg" and "photo_final_v2.jpg".
+var (
+	copySuffix = regexp.MustCompile(`(?i)(\s*[(\[]\d+[)\]]|[ _-]+(copy|final|v\d+|ver\d+|version\s*\d+|rev\d+|old|new|backup|bak|orig|original|edited|draft|duplicate)(\s*[(\[]?\d+[)\]]?)?)$`)
+	copyPrefix = regexp.MustCompile(`(?i)^copy(\s*\(\d+\))? of `)
+)
+
+// Extensions editors and tools add to the names of backup copies, after
+// the file's own extension, as in "notes.txt.bak".
+var backupExts = map[string]bool{".bak": true, ".old": true, ".orig": true, ".backup": true}
+
+// NameGroup is a set of files in one directory whose names differ only by
+// the suffixes copying adds, such as "photo.jpg", "photo (1).jpg" and
+// "photo - Copy.jpg", although their content differs.
+type NameGroup struct {
+	// Name is the name the files share once those suffixes are removed,
+	// spelt as the original's is if it is one of them.
+	Name  string
+	Files []File
+	// SizeRatio is the size of the smallest file divided by that of the
+	// largest, from 0 to 1: the closer to 1, the likelier the files are
+	// accidental copies of one another.
+	SizeRatio float64
+}
+
+// SimilarNames returns the groups of files found by the most recent scan
+// that share a directory and a name once the suffixes copying adds are
+// removed, comparing names without regard to case. Groups are ranked by
+// SizeRatio, highest first, and otherwise in walk order. Groups whose
+// files are all exact duplicates of each other are left out, as Scan
+// already reports them.
+func (s *Scanner) SimilarNames() []NameGroup {
+	members := make(map[string][]candidate)
+	names := make(map[string]string)
+	var order []string
+	for _, c := range s.files {
+		base := filepath.Base(c.file.Path)
+		name := copyName(base)
+		key := filepath.Dir(c.file.Path) + string(filepath.Separator) + strings.ToLower(name)
+		if _, ok := members[key]; !ok {
+			order = append(order, key)
+			names[key] = name
+		}
+		// Name the group after the original, if it is among the files.
+		if base == name {
+			names[key] = name
+		}
+		members[key] = append(members[key], c)
+	}
+
+	var groups []NameGroup
+	for _, key := range order {
+		cands := members[key]
+		if len(cands) < 2 || s.allSameContent(cands) {
+			continue
+		}
+		g := NameGroup{Name: names[key]}
+		smallest, largest := cands[0].file.Size, cands[0].file.Size
+		for _, c := range cands {
+			g.Files = append(g.Files, c.file)
+			if c.file.Size < smallest {
+				smallest = c.file.Size
+			}
+			if c.file.Size > largest {
+				largest = c.file.Size
+			}
+		}
+		g.SizeRatio = 1
+		if largest > 0 {
+			g.SizeRatio = float64(smallest) / float64(largest)
+		}
+		groups = append(groups, g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].SizeRatio > groups[j].SizeRatio
+	})
+	return groups
+}
+
+// copyName returns name without the suffixes and prefixes copying adds,
+// keeping its extension.
+func copyName(name string) string {
+	name = strings.TrimSuffix(name, "~")
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if backupExts[strings.ToLower(ext)] && filepath.Ext(stem) != "" {
+		ext = filepath.Ext(stem)
+		stem = strings.TrimSuffix(stem, ext)
+	}
+	for {
+		trimmed := copyPrefix.ReplaceAllString(copySuffix.ReplaceAllString(stem, ""), "")
+		if trimmed == stem || trimmed == "" {
+			break
+		}
+		stem = trimmed
+	}
+	return stem + ext
+}
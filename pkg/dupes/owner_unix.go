@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package dupes
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Whether files have owners that Scanner.Owners can match.
+const ownersSupported = true
+
+// fileOwner returns the user ID of the owner of the file described by
+// info.
+func fileOwner(info os.FileInfo) (uid uint32, ok bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, true
+	}
+	return 0, false
+}
+
+// credentials are the IDs the process's access to files is checked
+// against, read once.
+var credentials struct {
+	once   sync.Once
+	uid    int
+	groups map[int]bool
+}
+
+// readable reports whether the process may read the entry described by
+// info, and list it if it is a directory, going by its permission bits.
+func readable(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	credentials.once.Do(func() {
+		credentials.uid = os.Geteuid()
+		credentials.groups = map[int]bool{os.Getegid(): true}
+		if groups, err := os.Getgroups(); err == nil {
+			for _, g := range groups {
+				credentials.groups[g] = true
+			}
+		}
+	})
+	if credentials.uid == 0 {
+		return true
+	}
+	perm := uint32(info.Mode().Perm())
+	var need uint32 = 04
+	if info.IsDir() {
+		need = 05
+	}
+	switch {
+	case int(st.Uid) == credentials.uid:
+		perm >>= 6
+	case credentials.groups[int(st.Gid)]:
+		perm >>= 3
+	}
+	return perm&need == need
+}
@@ -0,0 +1,18 @@
+package dupes
+
+import "os"
+
+// Files on Windows have security descriptors rather than owner IDs, so
+// Scanner.Owners cannot be used.
+const ownersSupported = false
+
+func fileOwner(info os.FileInfo) (uid uint32, ok bool) {
+	return 0, false
+}
+
+// readable reports that the entry described by info may be read: Windows
+// access control lists are not judged up front, so files they deny are
+// reported when reading them fails.
+func readable(info os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package dupes
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// longPath returns path, which needs no special form to be long.
+func longPath(path string) string {
+	return path
+}
+
+// pathKey returns the form of the absolute path used to tell whether two
+// paths name the same file.
+func pathKey(path string) string {
+	return path
+}
+
+// isHidden reports whether the entry at path is hidden: whether its name
+// starts with a dot.
+func isHidden(path string, info os.FileInfo) bool {
+	return strings.HasPrefix(info.Name(), ".")
+}
+
+// isCrossDevice reports whether err is the failure to link or rename a
+// file onto another filesystem.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// isLinkUnsupported reports whether err is the failure to create a hard
+// link on a filesystem without them.
+func isLinkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// isLocked reports whether err is the failure to read a file another
+// process holds a mandatory lock on.
+func isLocked(err error) bool {
+	return errors.Is(err, syscall.EAGAIN)
+}
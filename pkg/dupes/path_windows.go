@@ -0,0 +1,71 @@
+package dupes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Windows error codes not defined by the syscall package.
+const (
+	errorInvalidFunction  syscall.Errno = 1
+	errorNotSameDevice    syscall.Errno = 17
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+	errorNotSupported     syscall.Errno = 50
+)
+
+// longPath returns path in the \\?\ form that lets Windows APIs reach
+// paths longer than MAX_PATH, such as \\?\C:\dir or \\?\UNC\server\share.
+// The os package does this itself; it is needed for syscalls made
+// directly. path is returned unchanged if it cannot be made absolute.
+func longPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// pathKey returns the form of the absolute path used to tell whether two
+// paths name the same file. NTFS ignores case in names, so C:\Photos and
+// c:\photos have the same key.
+func pathKey(path string) string {
+	return strings.ToLower(path)
+}
+
+// isHidden reports whether the entry at path is hidden: whether it has the
+// hidden attribute or, as on Unix, its name starts with a dot.
+func isHidden(path string, info os.FileInfo) bool {
+	if strings.HasPrefix(info.Name(), ".") {
+		return true
+	}
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && attrs.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
+
+// isCrossDevice reports whether err is the failure to link or rename a
+// file onto another volume.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV) || errors.Is(err, errorNotSameDevice)
+}
+
+// isLinkUnsupported reports whether err is the failure to create a hard
+// link on a filesystem without them, such as FAT32 and exFAT.
+func isLinkUnsupported(err error) bool {
+	return errors.Is(err, errorInvalidFunction) || errors.Is(err, errorNotSupported)
+}
+
+// isLocked reports whether err is the failure to read a file another
+// process has opened without sharing it, or has locked a range of.
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,32 @@
+package dupes
+
+import "errors"
+
+// Errors returned by ReflinkAction.
+var (
+	ErrReflinkUnsupported = errors.New("dupes: filesystem cannot share data between files")
+	ErrContentDiffers     = errors.New("dupes: duplicate no longer has the same content as the kept file")
+)
+
+// ReflinkAction makes duplicate files share the kept file's data on disk,
+// on copy-on-write filesystems such as btrfs and XFS. The kernel compares
+// the files itself before sharing anything, and each path stays a separate
+// file with its own inode, permissions and times, so a later change to one
+// copy does not affect the others. Duplicates on filesystems that cannot
+// share data, or on another platform than Linux, are skipped with
+// ErrReflinkUnsupported, and those on another filesystem with
+// ErrCrossDevice.
+type ReflinkAction struct{}
+
+func (ReflinkAction) Apply(keep File, dupe File) error {
+	if keep.Archive != "" {
+		return ErrInArchive
+	}
+	if err := checkNotSameFile(keep, dupe); err != nil {
+		return err
+	}
+	if dupe.Size == 0 {
+		return nil
+	}
+	return dedupeRange(keep.Path, dupe.Path, dupe.Size)
+}
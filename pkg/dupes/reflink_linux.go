@@ -0,0 +1,89 @@
+package dupes
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// FIDEDUPERANGE ioctl, from linux/fs.h.
+const fideduperange = 0xc0189436
+
+// Status of a dedupe range request whose files differ.
+const fileDedupeRangeDiffers = 1
+
+// Bytes asked to be shared per FIDEDUPERANGE call. Filesystems may share
+// less at a time, and report how much they did.
+const dedupeChunk = 16 << 20
+
+// fileDedupeRange is struct file_dedupe_range with a single
+// file_dedupe_range_info.
+type fileDedupeRange struct {
+	srcOffset    uint64
+	srcLength    uint64
+	destCount    uint16
+	reserved1    uint16
+	reserved2    uint32
+	destFd       int64
+	destOffset   uint64
+	bytesDeduped uint64
+	status       int32
+	reserved     uint32
+}
+
+// dedupeRange shares the first size bytes of the file at src with the file
+// at dest, which must hold the same bytes, using FIDEDUPERANGE.
+func dedupeRange(src string, dest string, size int64) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// Permission to write dest is checked by the kernel, but the file need
+	// not be opened for writing, which would change nothing.
+	d, err := os.Open(dest)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	for offset := uint64(0); offset < uint64(size); {
+		r := fileDedupeRange{
+			srcOffset:  offset,
+			srcLength:  uint64(size) - offset,
+			destCount:  1,
+			destFd:     int64(d.Fd()),
+			destOffset: offset,
+		}
+		if r.srcLength > dedupeChunk {
+			r.srcLength = dedupeChunk
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, s.Fd(), fideduperange, uintptr(unsafe.Pointer(&r)))
+		if errno != 0 {
+			return dedupeError(dest, errno)
+		}
+		switch {
+		case r.status == fileDedupeRangeDiffers:
+			return ErrContentDiffers
+		case r.status < 0:
+			return dedupeError(dest, syscall.Errno(-r.status))
+		case r.bytesDeduped == 0:
+			return &os.PathError{Op: "reflink", Path: dest, Err: errors.New("no data was shared")}
+		}
+		offset += r.bytesDeduped
+	}
+	return nil
+}
+
+// dedupeError returns the error for a dedupe of dest that failed with
+// errno.
+func dedupeError(dest string, errno syscall.Errno) error {
+	switch errno {
+	case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.EINVAL:
+		return ErrReflinkUnsupported
+	case syscall.EXDEV:
+		return ErrCrossDevice
+	}
+	return &os.PathError{Op: "reflink", Path: dest, Err: errno}
+}
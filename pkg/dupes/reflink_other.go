@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package dupes
+
+// dedupeRange reports that sharing data between files is not supported
+// outside Linux.
+func dedupeRange(src string, dest string, size int64) error {
+	return ErrReflinkUnsupported
+}
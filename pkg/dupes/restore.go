@@ -0,0 +1,30 @@
+package dupes
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// RestoreMoved moves a file that MoveAction moved to dest back to its
+// original path, which must not exist again. Its directory is recreated if
+// it has since been removed.
+func RestoreMoved(path string, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return moveFile(dest, path)
+}
+
+// RestoreCopy makes path a file of its own again, holding a copy of kept:
+// it recreates a duplicate that DeleteAction or TrashAction removed, or
+// replaces the link that HardlinkAction or SymlinkAction left in its place.
+// The copy has kept's mode and modification time. If path exists it is
+// only replaced once the copy is complete.
+func RestoreCopy(path string, kept string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return replace(path, func(tmp string) error {
+		return copyFile(kept, tmp)
+	})
+}
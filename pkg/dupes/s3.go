@@ -0,0 +1,397 @@
+package dupes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prefix of the roots that name an S3 bucket, and the objects under a
+// prefix in it, as in "s3://backups/photos".
+const S3_SCHEME = "s3://"
+
+// Region S3 requests are signed for unless AWS_REGION or AWS_DEFAULT_REGION
+// is set.
+const DEFAULT_S3_REGION = "us-east-1"
+
+// SHA-256 of an empty request body, as S3 requires it in every request.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// isS3 reports whether path is an S3 root or object, rather than a local
+// path.
+func isS3(path string) bool {
+	return strings.HasPrefix(path, S3_SCHEME)
+}
+
+// parseS3URL splits an S3 root into its bucket and the prefix of the keys
+// below it. Like a directory, the prefix only matches whole path segments,
+// so "s3://b/photos" does not include "photos2/".
+func parseS3URL(root string) (bucket string, prefix string, err error) {
+	rest := strings.TrimPrefix(root, S3_SCHEME)
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		bucket, rest = rest, ""
+	} else {
+		bucket, rest = rest[:i], strings.TrimLeft(rest[i+1:], "/")
+	}
+	if bucket == "" {
+		return "", "", fmt.Errorf("dupes: invalid S3 root %q: no bucket", root)
+	}
+	if rest != "" && !strings.HasSuffix(rest, "/") {
+		rest += "/"
+	}
+	return bucket, rest, nil
+}
+
+// s3Client makes the requests of S3 roots, signed with AWS Signature
+// Version 4 if credentials are set in AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, and anonymous otherwise.
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL points it at another service
+// implementing the S3 API, such as MinIO, with path-style requests.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+}
+
+// s3Object is an object listed by ListObjectsV2.
+type s3Object struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+// s3Error is an error response from S3.
+type s3Error struct {
+	Status  string
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return "S3 returned " + e.Status
+	}
+	return fmt.Sprintf("S3 returned %s: %s: %s", e.Status, e.Code, e.Message)
+}
+
+// newS3Client returns a client configured from the environment, as the AWS
+// command line tools are.
+func newS3Client() (*s3Client, error) {
+	c := &s3Client{
+		region:    os.Getenv("AWS_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.region == "" {
+		c.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if c.region == "" {
+		c.region = DEFAULT_S3_REGION
+	}
+	if (c.accessKey == "") != (c.secretKey == "") {
+		return nil, errors.New("dupes: set both AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or neither")
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("dupes: invalid S3 endpoint %q", endpoint)
+		}
+		c.endpoint = u
+	}
+	return c, nil
+}
+
+// objectURL returns the URL of the object key in bucket, or of the bucket
+// itself if key is empty. Buckets on AWS are addressed by host name unless
+// their names contain dots, which would not match its TLS certificates.
+func (c *s3Client) objectURL(bucket string, key string) *url.URL {
+	u := &url.URL{Scheme: "https"}
+	var p string
+	switch {
+	case c.endpoint != nil:
+		u.Scheme, u.Host = c.endpoint.Scheme, c.endpoint.Host
+		p = strings.TrimSuffix(c.endpoint.Path, "/") + "/" + bucket
+		if key != "" {
+			p += "/" + key
+		}
+	case strings.Contains(bucket, "."):
+		u.Host = "s3." + c.region + ".amazonaws.com"
+		p = "/" + bucket
+		if key != "" {
+			p += "/" + key
+		}
+	default:
+		u.Host = bucket + ".s3." + c.region + ".amazonaws.com"
+		p = "/" + key
+	}
+	u.Path = p
+	u.RawPath = s3Escape(p, true)
+	return u
+}
+
+// s3Escape percent-encodes s as Signature Version 4 requires: every byte
+// but letters, digits and -._~, and / if slash is set.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// get sends a GET request for the object key in bucket, or for the bucket
+// if key is empty, and returns the response if it succeeded.
+func (c *s3Client) get(ctx context.Context, bucket string, key string, query url.Values) (*http.Response, error) {
+	u := c.objectURL(bucket, key)
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, len(keys))
+	for i, k := range keys {
+		params[i] = s3Escape(k, false) + "=" + s3Escape(query.Get(k), false)
+	}
+	u.RawQuery = strings.Join(params, "&")
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.accessKey != "" {
+		c.sign(req, time.Now().UTC())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		e := &s3Error{Status: resp.Status}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(e)
+		return nil, e
+	}
+	return resp, nil
+}
+
+// sign adds the headers signing req for the client's credentials at now.
+func (c *s3Client) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, req.URL.EscapedPath(), req.URL.RawQuery)
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		fmt.Fprintf(&canonical, "%s:%s\n", h, strings.TrimSpace(v))
+	}
+	signed := strings.Join(headers, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signed, emptySHA256)
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + c.secretKey)
+	for _, part := range []string{date, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// list calls fn with each object in bucket whose key starts with prefix, in
+// key order, a page of ListObjectsV2 at a time.
+func (c *s3Client) list(ctx context.Context, bucket string, prefix string, fn func(s3Object)) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.get(ctx, bucket, "", query)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []s3Object
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, o := range page.Contents {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fn(o)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// s3Client returns the client S3 roots are read with, creating it the
+// first time.
+func (a *archives) s3Client() (*s3Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.s3 == nil {
+		c, err := newS3Client()
+		if err != nil {
+			return nil, err
+		}
+		a.s3 = c
+	}
+	return a.s3, nil
+}
+
+// openS3Object streams the body of an object listed by walkS3.
+func (s *Scanner) openS3Object(m *archiveMember) (io.ReadCloser, error) {
+	c, err := s.archives.s3Client()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.get(context.Background(), strings.TrimPrefix(m.archive, S3_SCHEME), m.name, nil)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: m.archive + "/" + m.name, Err: err}
+	}
+	return resp.Body, nil
+}
+
+// walkS3 adds the objects under the S3 root to the walk. They are found as
+// archive members are, the bucket standing for the archive, and filtered
+// by their keys relative to the root's prefix. Objects in the Glacier and
+// Deep Archive storage classes cannot be read without restoring them, and
+// are left out.
+func (w *walker) walkS3(root string) error {
+	s := w.s
+	bucket, prefix, err := parseS3URL(root)
+	if err != nil {
+		return err
+	}
+	c, err := s.archives.s3Client()
+	if err != nil {
+		return err
+	}
+	archive := S3_SCHEME + bucket
+	return c.list(w.ctx, bucket, prefix, func(o s3Object) {
+		s.stats.Entries++
+		if s.Progress != nil {
+			defer s.Progress(s.stats)
+		}
+		path := archive + "/" + o.Key
+		// Zero-byte keys ending in a slash are how consoles create
+		// folders.
+		if strings.HasSuffix(o.Key, "/") {
+			return
+		}
+		if o.StorageClass == "GLACIER" || o.StorageClass == "DEEP_ARCHIVE" {
+			s.filtered(path, "in the "+o.StorageClass+" storage class")
+			return
+		}
+		if !s.memberIncluded(&archiveMember{name: strings.TrimPrefix(o.Key, prefix), size: o.Size}, o.LastModified) {
+			return
+		}
+		m := &archiveMember{archive: archive, name: o.Key, kind: archiveS3, size: o.Size, etag: strings.Trim(o.ETag, `"`)}
+		w.addMember(root, path, m, o.LastModified)
+	})
+}
+
+// md5ETag reports whether etag is the MD5 of its object's content, as it
+// is for objects uploaded in a single part. Those uploaded in parts have
+// ETags ending in the number of parts, such as "-3".
+func md5ETag(etag string) bool {
+	if len(etag) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(etag)
+	return err == nil
+}
+
+// matchETags returns the candidates that may still have a duplicate once
+// the ETags of S3 objects are compared. An object whose size is shared
+// only with other objects, all uploaded in a single part, cannot have a
+// duplicate unless one has the same ETag, so it is never downloaded.
+// Objects encrypted with SSE-KMS or SSE-C have ETags that are not the MD5
+// of their content, so a bucket holding any should not be scanned alone.
+func (s *Scanner) matchETags(candidates []candidate) []candidate {
+	onlyObjects := make(map[int64]bool)
+	etags := make(map[string]int)
+	for _, c := range candidates {
+		m := s.archives.member(c.file.Path)
+		if _, ok := onlyObjects[c.file.Size]; !ok {
+			onlyObjects[c.file.Size] = true
+		}
+		if m == nil || m.kind != archiveS3 || !md5ETag(m.etag) {
+			onlyObjects[c.file.Size] = false
+			continue
+		}
+		etags[fmt.Sprint(c.file.Size, ":", m.etag)]++
+	}
+	kept := candidates[:0:0]
+	for _, c := range candidates {
+		if onlyObjects[c.file.Size] {
+			if m := s.archives.member(c.file.Path); etags[fmt.Sprint(c.file.Size, ":", m.etag)] < 2 {
+				s.stats.Rejected++
+				s.stats.Hashed++
+				s.stats.HashedBytes += c.file.Size
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	if s.Progress != nil && len(kept) < len(candidates) {
+		s.Progress(s.stats)
+	}
+	return kept
+}
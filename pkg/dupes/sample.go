@@ -0,0 +1,161 @@
+package dupes
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/OneOfOne/xxhash"
+)
+
+// Default number of bytes sampled at each end of a file by the partial hash.
+const DEFAULT_PARTIAL_HASH = 64 * 1024
+
+// partialHashSize returns the scanner's PartialHash, or
+// DEFAULT_PARTIAL_HASH if unset.
+func (s *Scanner) partialHashSize() int64 {
+	if s.PartialHash == 0 {
+		return DEFAULT_PARTIAL_HASH
+	}
+	return s.PartialHash
+}
+
+// sample hashes the first and last bytes of the large candidates and
+// returns the candidates that may still have a duplicate, in walk order.
+// Files no larger than two samples are read in full anyway, and size groups
+// with a member whose full hash is already known are left alone.
+func (s *Scanner) sample(ctx context.Context, candidates []candidate) []candidate {
+	n := s.partialHashSize()
+	if n <= 0 {
+		return candidates
+	}
+
+	known := make(map[int64]bool)
+	for _, c := range candidates {
+		if _, ok := s.knownHash(c); ok {
+			known[c.file.Size] = true
+		}
+	}
+	var kept, eligible []candidate
+	for _, c := range candidates {
+		// Archive members cannot be read at an offset, except for
+		// files read over SFTP.
+		if c.file.Size > 2*n && !known[c.file.Size] && s.readsAt(c.file) {
+			eligible = append(eligible, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if len(eligible) == 0 {
+		return candidates
+	}
+
+	queue := make(chan candidate)
+	// limit is set if a sampling limit stops the sampling, and the
+	// candidates not sampled by then are dropped.
+	var limit error
+	go func() {
+		defer close(queue)
+		for i, c := range eligible {
+			if s.MaxFiles > 0 && int64(i) >= s.MaxFiles {
+				limit = ErrMaxFiles
+				return
+			}
+			if s.MaxDuration > 0 && !s.started.IsZero() && time.Since(s.started) >= s.MaxDuration {
+				limit = ErrMaxDuration
+				return
+			}
+			select {
+			case queue <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	bySample := make(map[string][]candidate)
+	partial := func(c candidate) hashResult {
+		h, err := s.partialHashFile(ctx, c.file.Path, c.file.Size, n)
+		return hashResult{candidate: c, hash: h, op: "read", err: err}
+	}
+	s.hashAll(ctx, queue, partial, func(r hashResult) {
+		if r.err != nil && r.err == ctx.Err() {
+			return
+		}
+		// A locked file is left for the full hash, which retries it.
+		if r.err != nil && s.RetryLocked > 0 && isLocked(r.err) {
+			kept = append(kept, r.candidate)
+			return
+		}
+		s.stats.Sampled++
+		if r.err != nil {
+			s.stats.Hashed++
+			s.stats.HashedBytes += r.file.Size
+			s.skip(r.file.Path, r.op, r.err)
+			if s.Progress != nil {
+				s.Progress(s.stats)
+			}
+			return
+		}
+		key := strconv.FormatInt(r.file.Size, 10) + ":" + r.hash
+		bySample[key] = append(bySample[key], r.candidate)
+	})
+
+	for _, group := range bySample {
+		if len(group) > 1 {
+			kept = append(kept, group...)
+			continue
+		}
+		s.stats.Rejected++
+		s.stats.Hashed++
+		s.stats.HashedBytes += group[0].file.Size
+	}
+	if limit != nil {
+		s.stats.Limit = limit
+	}
+	if s.Progress != nil {
+		s.Progress(s.stats)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].seq < kept[j].seq })
+	return kept
+}
+
+// readsAt reports whether f can be read at an offset, as partialHashFile
+// reads it.
+func (s *Scanner) readsAt(f File) bool {
+	if f.Archive == "" {
+		return true
+	}
+	m := s.archives.member(f.Path)
+	return m != nil && m.kind == archiveSFTP
+}
+
+// partialHashFile returns the xxHash of the first and last n bytes of the
+// file at path, which is size bytes long.
+func (s *Scanner) partialHashFile(ctx context.Context, path string, size int64, n int64) (string, error) {
+	var f interface {
+		io.ReaderAt
+		io.Closer
+	}
+	var err error
+	if m := s.archives.member(path); m != nil {
+		f, err = s.openSFTP(m)
+	} else {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := xxhash.New64()
+	head := io.NewSectionReader(f, 0, n)
+	tail := io.NewSectionReader(f, size-n, n)
+	if _, err := s.copyBuffered(h, ctxReader{ctx, io.MultiReader(head, tail)}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,1233 @@
+// Package dupes finds duplicate files by content.
+//
+// By default files are compared by both xxHash and HighwayHash, so a
+// collision of a single hash cannot produce a false positive. Both hashes
+// are computed in one streaming pass over each file.
+package dupes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/go-trie-tst"
+)
+
+// Errors recorded in Stats.Limit when a sampling limit, or StopAt, stops
+// hashing.
+var (
+	ErrMaxFiles    = errors.New("dupes: file limit reached")
+	ErrMaxDuration = errors.New("dupes: duration limit reached")
+	ErrStopped     = errors.New("dupes: stopped at a duplicate")
+)
+
+// Scanner walks directory trees and groups files with identical content.
+// The zero value is ready to use.
+type Scanner struct {
+	// ReadBufferSize is the size of the buffers used to read and hash
+	// files. Zero means DEFAULT_READ_BUFFER.
+	ReadBufferSize int
+
+	// MaxFiles and MaxDuration are sampling limits for Scan and ScanFiles.
+	// MaxFiles stops the walk once that many files have been found, and
+	// the hashing once that many candidates have been hashed. MaxDuration
+	// stops the scan once it has run that long, whether it is walking,
+	// sampling or hashing. Either way the files processed so far are
+	// grouped, Stats reports what fraction of them was hashed, and
+	// Stats.Limit which limit was reached. Zero means no limit.
+	MaxFiles    int64
+	MaxDuration time.Duration
+
+	// RetryLocked is the number of times a file locked by another process,
+	// as files in use often are on Windows, is tried again. Locked files
+	// are set aside until the others have been hashed, and retried
+	// LOCKED_RETRY_DELAY apart; those still locked are skipped, and
+	// reported to OnError with the operation "locked".
+	RetryLocked int
+
+	// MaxReadRate, if positive, limits the rate at which files are read, by
+	// all workers together, to that many bytes per second, so that a scan
+	// leaves the disks to others.
+	MaxReadRate int64
+
+	// Mmap hashes files of at least MMAP_MIN_SIZE by mapping them into
+	// memory instead of reading them, which saves a copy of their data and
+	// many system calls. Files that cannot be mapped, and every file on
+	// platforms without mmap, are read as usual.
+	Mmap bool
+
+	// ChunkThreshold, if positive, hashes each file larger than it as
+	// chunks of ChunkSize bytes, read in parallel, and combines the chunks'
+	// hashes into the file's, so that a single huge file does not hold up
+	// a scan while the other workers are idle. Such hashes differ from
+	// those of the whole file; HashScheme records how they were made.
+	ChunkThreshold int64
+
+	// ChunkSize is the size of those chunks. Zero means
+	// DEFAULT_HASH_CHUNK.
+	ChunkSize int64
+
+	// OnError is called for each file that is skipped because it could not
+	// be read. op is the failed operation, e.g. "open" or "read".
+	OnError func(path string, op string, err error)
+
+	// OnFiltered is called for each file or directory the walk leaves out
+	// because of a filter or its type, with the reason, e.g. "excluded by
+	// *.tmp" or "symbolic link". The contents of a directory left out are
+	// not walked, so are not reported.
+	OnFiltered func(path string, reason string)
+
+	// Progress is called after each entry is walked and each file is
+	// hashed.
+	Progress func(Stats)
+
+	// Hash selects the hash algorithms files are compared by: one or two of
+	// "xxhash", "highway", "sha256" and "blake3", joined by "+". Empty
+	// means DEFAULT_HASH.
+	Hash string
+
+	// HighwayKey is the 32-byte key HighwayHash is seeded with. Nil means
+	// HH_KEY. Hashes, and so group IDs, differ between keys.
+	HighwayKey []byte
+
+	// PartialHash is the number of bytes hashed at the start and at the end
+	// of each large candidate before it is read in full, so that files
+	// differing there are rejected cheaply. Zero means
+	// DEFAULT_PARTIAL_HASH, and a negative value disables the stage.
+	PartialHash int64
+
+	// Workers is the number of files hashed concurrently. Zero means one
+	// per CPU.
+	Workers int
+
+	// MaxMemory, if positive, bounds the memory the scan reads files into:
+	// fewer files are hashed at once than Workers if their read buffers
+	// would take more than 1/MEMORY_BUFFER_SHARE of it. The rest is left
+	// for the lists of files and hashes, which grow with the number of
+	// files; a program can hold the whole process to the limit with
+	// debug.SetMemoryLimit, as the dupes command does.
+	MaxMemory int64
+
+	// Exclude lists glob patterns, as understood by filepath.Match, for
+	// paths to skip. A pattern without a slash matches any file or
+	// directory name, so "node_modules" or "*.tmp" apply at every level. A
+	// pattern with a slash matches the path relative to the scanned root,
+	// e.g. "photos/cache". Excluded directories are not descended into.
+	Exclude []string
+
+	// MinSize and MaxSize bound the size in bytes of the files scanned. A
+	// MaxSize of zero means no upper bound.
+	MinSize int64
+	MaxSize int64
+
+	// ModifiedAfter and ModifiedBefore, if not zero, bound the last
+	// modification times of the files scanned.
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	// MaxDepth limits how many levels below each root the walk descends:
+	// 1 scans only the files directly in a root. Zero means no limit.
+	MaxDepth int
+
+	// IncludeExt, if not empty, restricts the scan to files with one of the
+	// listed extensions, and ExcludeExt skips files with any of them.
+	// Extensions are given without the dot and compared case-insensitively.
+	IncludeExt []string
+	ExcludeExt []string
+
+	// MIME, if not empty, restricts the scan to files whose content type,
+	// sniffed from their first bytes, matches one of the listed patterns,
+	// e.g. "image/*" or "video/mp4". Files whose content is not recognised
+	// are typed by their extension instead.
+	MIME []string
+
+	// Cache, if set, supplies the hashes of files unchanged since an
+	// earlier scan and records the hashes of the rest. The caller is
+	// responsible for saving it.
+	Cache *Cache
+
+	// References lists directories scanned along with the roots whose
+	// files are never acted on. When set, only groups containing both a
+	// reference file and a file outside the references are reported, so
+	// the result is the files that already have a copy in a reference.
+	References []string
+
+	// FollowSymlinks follows symbolic links to files and directories.
+	// Every directory is walked at most once, so links cannot cause loops.
+	// By default symlinks are skipped, except for the roots themselves.
+	FollowSymlinks bool
+
+	// OneFileSystem keeps the walk on the filesystem of each root, like
+	// du -x: directories and files on other devices, such as mount points
+	// below a root, are skipped.
+	OneFileSystem bool
+
+	// Checkpoint, if set, records the scan's progress so that it can be
+	// resumed, and supplies the progress of the scan it was saved by.
+	Checkpoint *Checkpoint
+
+	// RespectGitignore skips the entries git would ignore in work trees:
+	// those matched by .gitignore files, the repository's info/exclude file
+	// and the user's global excludes file, along with .git directories.
+	// DUPESIGNORE_FILE files, in the same syntax, are always honoured, and
+	// take precedence over git's rules in the same directory.
+	RespectGitignore bool
+
+	// Archives also scans the files inside zip and tar archives, including
+	// gzip-compressed tars, so they are compared with loose files and with
+	// each other. Their paths join the archive's path and the member's with
+	// ARCHIVE_SEPARATOR. Members are never acted on, and are preferred as
+	// the file kept. Archives cannot be used with a Checkpoint.
+	Archives bool
+
+	// Owners, if not empty, scans only the files owned by one of these
+	// user IDs. It cannot be used on Windows.
+	Owners []uint32
+
+	// SkipUnreadable leaves out the files and directories the process has
+	// no permission to read, judged by their permission bits as they are
+	// walked, rather than reporting each to OnError when reading it fails.
+	// Access control lists are not considered, so files they deny are
+	// still reported; on Windows, where permissions are all ACLs, that is
+	// every such file.
+	SkipUnreadable bool
+
+	// SkipHidden leaves out hidden files and directories, with everything
+	// below them: those whose names start with a dot, such as .cache and
+	// .DS_Store, and on Windows those with the hidden attribute too. The
+	// roots themselves are always scanned.
+	SkipHidden bool
+
+	// IncludeEmpty scans zero-byte files. They all share the same content,
+	// so they are skipped by default.
+	IncludeEmpty bool
+
+	// FindEmpty records the zero-byte files left out, unless IncludeEmpty
+	// is set, and the directories holding nothing but empty directories,
+	// for EmptyFiles and EmptyDirs to report as candidates for cleaning up.
+	// Files and directories left out by other filters are not recorded, and
+	// count as content. FindEmpty cannot be used with a Checkpoint.
+	FindEmpty bool
+
+	// Verify compares the files of each group byte for byte after hashing,
+	// so that a hash collision cannot produce a false positive. Groups
+	// found to contain differing files are split, and reported by
+	// Collisions.
+	Verify bool
+
+	// OnGroup, if set, is called during Scan with each duplicate group as
+	// soon as every candidate of its size has been hashed, so results can
+	// be used before the scan ends. Groups are passed verified and filtered
+	// as Scan would return them. Groups of sizes left unfinished by an
+	// interruption or a limit are only returned by Scan.
+	OnGroup func(DupeGroup)
+
+	// StopAt, if set, is called during Scan each time a file hashed joins
+	// others of the same hash, with the group they form, verified and
+	// filtered as Scan would return it. If it returns true, no further
+	// candidates are hashed, as when a sampling limit is reached, and
+	// Stats.Limit is ErrStopped; files being hashed at the time are still
+	// grouped. A caller that only needs to know whether there are any
+	// duplicates can so stop at the first.
+	StopAt func(DupeGroup) bool
+
+	bufferPool *sync.Pool
+	limiter    *rateLimiter
+	chunkSlots chan struct{}
+	stats      Stats
+	collisions []DupeGroup
+	// verified maps the ID of each group Verify has checked to the groups
+	// it was split into, so no group is verified twice.
+	verified map[string][]DupeGroup
+	// files holds every file found by the most recent scan, and skipped
+	// the paths of those that could not be read.
+	files   []candidate
+	skipped map[string]bool
+	// hashes maps the path of each file hashed by the most recent scan,
+	// including its links, to its hash.
+	hashes map[string]string
+	// emptyFiles and emptyDirs hold what FindEmpty found.
+	emptyFiles []string
+	emptyDirs  []string
+	// archives locates the archive members found by the most recent scan.
+	archives *archives
+	// ignores caches the ignore rules applying to each directory walked.
+	ignores map[string][]ignoreRule
+	// started is when the running Scan or ScanFiles started, for
+	// MaxDuration, and zero outside of them.
+	started time.Time
+
+	// Candidates keyed by the combined xxHash and HighwayHash.
+	hashTST trietst.TST
+}
+
+// Stats describes the progress of a scan.
+type Stats struct {
+	// Entries is the number of paths walked, including directories.
+	Entries int64
+	// Files is the number of files enumerated, and Bytes their total size.
+	Files int64
+	Bytes int64
+	// Candidates is the number of files sharing their size with at least
+	// one other file, and CandidateBytes their total size. Only these are
+	// hashed. Both are zero until the walk is complete.
+	Candidates     int64
+	CandidateBytes int64
+	// Hashed is the number of candidates hashed, and HashedBytes their
+	// total size.
+	Hashed      int64
+	HashedBytes int64
+	// Sampled is the number of large candidates whose first and last
+	// PartialHash bytes were hashed before reading them in full, and
+	// Rejected the number of those found to be unique by that alone, or
+	// S3 objects found to be unique by their ETags. Rejected candidates
+	// count as hashed.
+	Sampled  int64
+	Rejected int64
+	// Cached is the number of hashed candidates whose hash came from the
+	// Cache rather than from reading the file, and Resumed the number whose
+	// hash came from the Checkpoint.
+	Cached  int64
+	Resumed int64
+	// Collisions is the number of groups Verify found to contain files
+	// that differ despite their matching hash.
+	Collisions int64
+	// Links is the number of paths found to refer to a file already found,
+	// as hard links or followed symlinks, and so listed in its Links. The
+	// same path found twice is counted once, in Files, and not as a link.
+	Links int64
+	// Members is the number of files found inside archives, and those
+	// found in S3, over SFTP or by agents, which are counted in Files and
+	// Bytes too.
+	Members int64
+	// Limit is ErrMaxFiles or ErrMaxDuration if a sampling limit stopped
+	// the walk or the hashing before every file was processed, or
+	// ErrStopped if StopAt did.
+	Limit error
+	// WalkTime, HashTime and VerifyTime are how long the scan spent walking
+	// the roots, hashing candidates, including their partial hashes, and
+	// verifying groups.
+	WalkTime   time.Duration
+	HashTime   time.Duration
+	VerifyTime time.Duration
+}
+
+// candidate is a file queued for hashing. seq records the walk order so
+// groups list their files in the order they were found, regardless of which
+// worker finished first.
+type candidate struct {
+	seq  int64
+	file File
+	dev  uint64
+	ino  uint64
+}
+
+// hashResult is the outcome of hashing a single candidate.
+type hashResult struct {
+	candidate
+	hash    string
+	cached  bool
+	resumed bool
+	op      string
+	err     error
+}
+
+// Scan walks each root and returns the groups of files with identical
+// content. If ctx is cancelled, the scan stops and the groups found so far
+// are returned along with the context's error.
+//
+// The whole tree is walked first so files can be grouped by size; a file
+// with a unique size cannot have a duplicate and is never read. The rest are
+// hashed by a pool of Workers goroutines.
+//
+// A root, or a reference, may also be an S3 bucket or the keys under a
+// prefix in one, given as s3://bucket/prefix. Its objects are listed and
+// streamed rather than walked and read, and treated as archive members: they
+// are never acted on, and are preferred as the file kept. Objects sharing
+// their size only with other objects are compared by ETag before any is
+// downloaded; see matchETags.
+//
+// A root may likewise be a directory on another host, read over SFTP, given
+// as sftp://user@host/path; see walkSFTP. Its files are also treated as
+// archive members, but are sampled like local files, so only the first and
+// last PartialHash bytes of most are transferred unless they match. A
+// directory given as agent://user@host/path is scanned by dupes agent
+// running on that host instead, which sends only the files' hashes; see
+// walkAgent.
+func (s *Scanner) Scan(ctx context.Context, roots ...string) ([]DupeGroup, error) {
+	return s.scan(ctx, func() ([]candidate, error) {
+		return s.walkOrResume(ctx, roots)
+	})
+}
+
+// ScanFiles is like Scan, but compares the files listed in paths instead of
+// the trees under roots, e.g. a list produced by find. Each file's Root is
+// its directory, and the filters apply to it as if it had been found there.
+// Symbolic links are followed; directories, other files that are not
+// regular and paths listed more than once are skipped, as are files that
+// cannot be read. The References are still walked. ScanFiles cannot be used
+// with a Checkpoint.
+func (s *Scanner) ScanFiles(ctx context.Context, paths []string) ([]DupeGroup, error) {
+	if s.Checkpoint != nil {
+		return nil, errors.New("dupes: ScanFiles cannot be used with a Checkpoint")
+	}
+	return s.scan(ctx, func() ([]candidate, error) {
+		files, err := s.list(ctx, paths)
+		if err != nil {
+			return nil, err
+		}
+		return s.collapseLinks(files), nil
+	})
+}
+
+// scan runs a scan of the files returned by find, once the Scanner's state
+// has been reset.
+func (s *Scanner) scan(ctx context.Context, find func() ([]candidate, error)) ([]DupeGroup, error) {
+	start := time.Now()
+	if err := s.reset(); err != nil {
+		return nil, err
+	}
+	s.started = start
+	defer func() { s.started = time.Time{} }()
+	defer s.archives.close()
+
+	files, err := find()
+	if err != nil {
+		return nil, err
+	}
+	s.files = files
+	s.stats.WalkTime = time.Since(start)
+	hashStart := time.Now()
+
+	sizeCounts := make(map[int64]int)
+	for _, c := range files {
+		sizeCounts[c.file.Size]++
+	}
+	var candidates []candidate
+	for _, c := range files {
+		if sizeCounts[c.file.Size] > 1 {
+			candidates = append(candidates, c)
+			s.stats.CandidateBytes += c.file.Size
+		}
+	}
+	s.stats.Candidates = int64(len(candidates))
+	candidates = s.sample(ctx, s.matchETags(candidates))
+
+	queue := make(chan candidate)
+	var limit error
+	// stop is closed once StopAt has accepted a group.
+	stop := make(chan struct{})
+	stopped := false
+	go func() {
+		defer close(queue)
+		for i, c := range candidates {
+			if ctx.Err() != nil {
+				return
+			}
+			if s.MaxFiles > 0 && int64(i) >= s.MaxFiles {
+				limit = ErrMaxFiles
+				return
+			}
+			if s.MaxDuration > 0 && time.Since(start) >= s.MaxDuration {
+				limit = ErrMaxDuration
+				return
+			}
+			select {
+			case queue <- c:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var pending *sizeClasses
+	if s.OnGroup != nil {
+		pending = newSizeClasses(candidates)
+	}
+	hash := func(c candidate) hashResult { return s.fullHash(ctx, c) }
+	var locked lockedFiles
+	handle := func(r hashResult) {
+		// A file whose hashing was cancelled was neither hashed nor
+		// unreadable.
+		if r.err != nil && r.err == ctx.Err() {
+			return
+		}
+		if locked.setAside(s, r) {
+			return
+		}
+		if pending != nil {
+			defer s.finishCandidate(ctx, pending, r)
+		}
+		s.stats.Hashed++
+		s.stats.HashedBytes += r.file.Size
+		if s.Progress != nil {
+			defer s.Progress(s.stats)
+		}
+		if r.err != nil {
+			s.skip(r.file.Path, r.op, r.err)
+			return
+		}
+		switch {
+		case r.resumed:
+			s.stats.Resumed++
+		case r.cached:
+			s.stats.Cached++
+		case s.Cache != nil:
+			s.Cache.store(r.candidate, s.hashIdentity(), r.hash)
+		}
+		if s.Checkpoint != nil && !r.resumed {
+			s.Checkpoint.store(r.file.Path, r.hash)
+		}
+		s.addToTST(&s.hashTST, r.hash, r.candidate)
+		s.hashes[r.file.Path] = r.hash
+		for _, link := range r.file.Links {
+			s.hashes[link] = r.hash
+		}
+		if s.StopAt != nil && !stopped && s.stopAt(r.hash) {
+			stopped = true
+			close(stop)
+		}
+	}
+	s.hashAll(ctx, queue, hash, handle)
+	if stopped {
+		limit = ErrStopped
+	} else {
+		locked.retry(ctx, s, hash, handle)
+	}
+	// A limit reached while walking or sampling stopped the scan first.
+	if s.stats.Limit == nil {
+		s.stats.Limit = limit
+	}
+	s.stats.HashTime = time.Since(hashStart)
+
+	groups := s.groups()
+	if s.Verify && ctx.Err() == nil {
+		verifyStart := time.Now()
+		groups = s.verify(groups)
+		s.stats.VerifyTime = time.Since(verifyStart)
+	}
+	if len(s.References) > 0 {
+		groups = referenced(groups)
+	}
+	return groups, ctx.Err()
+}
+
+// collapseLinks removes files that are the same file as one earlier in
+// files, recording their paths in that file's Links. Such paths share their
+// content and storage, so they are neither hashed nor reported as
+// duplicates of each other. A path found again, as it is under overlapping
+// roots or through a symlinked directory, is dropped altogether, and no
+// longer counted in Stats.
+func (s *Scanner) collapseLinks(files []candidate) []candidate {
+	type id struct{ dev, ino uint64 }
+	first := make(map[id]int)
+	// paths holds the canonical paths each file found more than once was
+	// found by. Files without an inode number, such as archive members,
+	// are told apart by their absolute path alone, as resolving the
+	// symlinks of every path would take a stat per directory.
+	paths := make(map[id]map[string]bool)
+	byPath := make(map[string]int)
+	var unique []candidate
+	for _, c := range files {
+		if c.ino == 0 {
+			key := absolutePath(c.file.Path)
+			if i, ok := byPath[key]; ok {
+				s.dropRepeat(&unique[i], c)
+				continue
+			}
+			byPath[key] = len(unique)
+			unique = append(unique, c)
+			continue
+		}
+		key := id{c.dev, c.ino}
+		i, ok := first[key]
+		if !ok {
+			first[key] = len(unique)
+			unique = append(unique, c)
+			continue
+		}
+		if paths[key] == nil {
+			paths[key] = map[string]bool{canonicalPath(unique[i].file.Path): true}
+		}
+		if p := canonicalPath(c.file.Path); paths[key][p] {
+			s.dropRepeat(&unique[i], c)
+			continue
+		} else {
+			paths[key][p] = true
+		}
+		unique[i].file.Links = append(unique[i].file.Links, c.file.Path)
+		s.stats.Links++
+	}
+	return unique
+}
+
+// dropRepeat removes c, a path to the file of kept that was found before,
+// from the walk's Stats. A file found under a reference root either time
+// is a reference.
+func (s *Scanner) dropRepeat(kept *candidate, c candidate) {
+	s.stats.Files--
+	s.stats.Bytes -= c.file.Size
+	if c.file.Archive != "" {
+		s.stats.Members--
+	}
+	if c.file.Reference {
+		kept.file.Reference = true
+	}
+}
+
+// absolutePath returns the form of p used to tell whether two paths are
+// spelt alike: absolute, and as pathKey compares it.
+func absolutePath(p string) string {
+	if IsRemote(p) || IsImage(p) {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	return pathKey(p)
+}
+
+// canonicalPath returns p as absolutePath does, with symlinks resolved, so
+// that two paths to the same directory entry compare equal.
+func canonicalPath(p string) string {
+	if real, err := filepath.EvalSymlinks(p); err == nil {
+		p = real
+	}
+	return absolutePath(p)
+}
+
+// reset clears what an earlier scan left in the Scanner and checks its
+// options. The caller closes s.archives once done with them.
+func (s *Scanner) reset() error {
+	bufferSize := s.ReadBufferSize
+	if bufferSize <= 0 {
+		bufferSize = DEFAULT_READ_BUFFER
+	}
+	s.bufferPool = &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, bufferSize)
+			return &b
+		},
+	}
+	s.limiter = nil
+	if s.MaxReadRate > 0 {
+		s.limiter = &rateLimiter{rate: float64(s.MaxReadRate)}
+	}
+	s.chunkSlots = make(chan struct{}, s.workers())
+	s.stats = Stats{}
+	s.collisions = nil
+	s.verified = make(map[string][]DupeGroup)
+	s.files = nil
+	s.skipped = make(map[string]bool)
+	s.emptyFiles = nil
+	s.emptyDirs = nil
+	s.hashes = make(map[string]string)
+	s.ignores = nil
+	s.archives = &archives{members: make(map[string]*archiveMember)}
+	s.hashTST = trietst.TST{}
+
+	if err := ValidateHash(s.hashAlgorithm()); err != nil {
+		return err
+	}
+	if _, err := s.highwayKey(); err != nil {
+		return err
+	}
+	if err := s.validateFilters(); err != nil {
+		return err
+	}
+	if s.Archives && s.Checkpoint != nil {
+		return errors.New("dupes: Archives cannot be used with a Checkpoint")
+	}
+	if s.FindEmpty && s.Checkpoint != nil {
+		return errors.New("dupes: FindEmpty cannot be used with a Checkpoint")
+	}
+	return nil
+}
+
+// walkOrResume returns the files under roots with links collapsed, taken
+// from the Checkpoint if it holds a completed walk and walked otherwise.
+func (s *Scanner) walkOrResume(ctx context.Context, roots []string) ([]candidate, error) {
+	if s.Checkpoint == nil {
+		files, err := s.walk(ctx, roots)
+		if err != nil {
+			return nil, err
+		}
+		return s.collapseLinks(files), nil
+	}
+
+	for _, root := range append(append([]string(nil), roots...), s.References...) {
+		if IsRemote(root) || IsImage(root) {
+			return nil, errors.New("dupes: remote and image roots cannot be used with a Checkpoint")
+		}
+	}
+	if err := s.Checkpoint.start(s, roots); err != nil {
+		return nil, err
+	}
+	if files, ok := s.Checkpoint.walked(); ok {
+		for _, c := range files {
+			n := int64(1 + len(c.file.Links))
+			s.stats.Entries += n
+			s.stats.Files += n
+			s.stats.Bytes += n * c.file.Size
+			s.stats.Links += n - 1
+		}
+		return files, nil
+	}
+	files, err := s.walk(ctx, roots)
+	if err != nil {
+		return nil, err
+	}
+	files = s.collapseLinks(files)
+	if s.stats.Limit != nil {
+		// A walk cut short by a limit is not saved as complete.
+		return files, nil
+	}
+	s.Checkpoint.setWalked(files)
+	s.Checkpoint.Save()
+	return files, nil
+}
+
+// walk returns every file under roots, in walk order, or those found
+// before a sampling limit stopped the walk.
+func (s *Scanner) walk(ctx context.Context, roots []string) ([]candidate, error) {
+	wctx, stop := s.limitWalk(ctx)
+	defer stop(nil)
+	w, err := s.walkReferences(wctx, stop)
+	for i := 0; err == nil && i < len(roots); i++ {
+		err = w.walkRoot(roots[i])
+	}
+	if err != nil {
+		return s.walkStopped(ctx, wctx, w, err)
+	}
+	s.emptyDirs = w.emptyDirs()
+	return w.files, nil
+}
+
+// limitWalk returns a context for a walk within ctx, which is cancelled
+// with ErrMaxDuration once the running scan reaches MaxDuration, and the
+// function that cancels it, with ErrMaxFiles once MaxFiles files have
+// been found. Outside of Scan and ScanFiles the walk is not limited.
+func (s *Scanner) limitWalk(ctx context.Context) (context.Context, context.CancelCauseFunc) {
+	if s.started.IsZero() {
+		return ctx, func(error) {}
+	}
+	ctx, stop := context.WithCancelCause(ctx)
+	if s.MaxDuration <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithDeadlineCause(ctx, s.started.Add(s.MaxDuration), ErrMaxDuration)
+	return ctx, func(cause error) {
+		stop(cause)
+		cancel()
+	}
+}
+
+// walkStopped returns the result of a walk in wctx, a context from
+// limitWalk for ctx, that failed with err. If a sampling limit stopped it,
+// the files found so far are returned and the limit is recorded in Stats.
+// The empty directories cannot be told apart in a partial walk, so none
+// are reported.
+func (s *Scanner) walkStopped(ctx, wctx context.Context, w *walker, err error) ([]candidate, error) {
+	limit := context.Cause(wctx)
+	if ctx.Err() != nil || limit != ErrMaxFiles && limit != ErrMaxDuration {
+		return nil, err
+	}
+	s.stats.Limit = limit
+	return w.files, nil
+}
+
+// walkReferences returns a walker that has walked the References. They are
+// walked first, and skipped if they are met again below a root, so their
+// files are only found once and as reference files. The walker calls stop
+// once it has found MaxFiles files, if stop is not nil.
+func (s *Scanner) walkReferences(ctx context.Context, stop context.CancelCauseFunc) (*walker, error) {
+	w := &walker{ctx: ctx, s: s, stop: stop}
+	if s.FollowSymlinks {
+		w.visited = make(map[[2]uint64]bool)
+	}
+	w.references = make(map[string]bool)
+	for _, ref := range s.References {
+		w.reference = true
+		if err := w.walkRoot(ref); err != nil {
+			return w, err
+		}
+		if IsRemote(ref) || IsImage(ref) {
+			continue
+		}
+		if abs, err := filepath.Abs(ref); err == nil {
+			w.references[pathKey(abs)] = true
+		}
+	}
+	w.reference = false
+	return w, nil
+}
+
+// list returns the files of paths, as described by ScanFiles, after those
+// of the References.
+func (s *Scanner) list(ctx context.Context, paths []string) ([]candidate, error) {
+	wctx, stop := s.limitWalk(ctx)
+	defer stop(nil)
+	w, err := s.walkReferences(wctx, stop)
+	if err != nil {
+		return s.walkStopped(ctx, wctx, w, err)
+	}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if err := wctx.Err(); err != nil {
+			return s.walkStopped(ctx, wctx, w, err)
+		}
+		path = filepath.Clean(path)
+		if seen[pathKey(path)] {
+			continue
+		}
+		seen[pathKey(path)] = true
+		s.stats.Entries++
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			s.skip(path, "stat", err)
+		case info.IsDir():
+			s.filtered(path, "directory")
+		case !info.Mode().IsRegular():
+			s.filtered(path, "not a regular file")
+		case s.include(filepath.Dir(path), path, info):
+			w.add(filepath.Dir(path), path, info)
+		}
+		if s.Progress != nil {
+			s.Progress(s.stats)
+		}
+	}
+	return w.files, nil
+}
+
+// walker holds the state of a single walk.
+type walker struct {
+	ctx   context.Context
+	s     *Scanner
+	files []candidate
+	// stop, if not nil, stops the walk once MaxFiles files are found.
+	stop context.CancelCauseFunc
+	// reference is set while walking one of the Scanner's References, and
+	// references holds their absolute paths once walked.
+	reference  bool
+	references map[string]bool
+	// visited records the device and inode of every directory entered
+	// when following symlinks, so that a link cannot lead back into a
+	// directory already walked.
+	visited map[[2]uint64]bool
+	// device is the device of the root being walked if OneFileSystem is
+	// set, and otherwise 0.
+	device uint64
+	// dirs lists the directories walked, and nonEmpty those found to hold
+	// anything but empty directories, if FindEmpty is set.
+	dirs     []string
+	nonEmpty map[string]bool
+}
+
+// walkRoot walks root, a directory, an S3 bucket or prefix, or a directory
+// on another host read over SFTP or scanned by an agent.
+func (w *walker) walkRoot(root string) error {
+	switch {
+	case isS3(root):
+		return w.walkS3(root)
+	case strings.HasPrefix(root, SFTP_SCHEME):
+		return w.walkSFTP(root)
+	case strings.HasPrefix(root, AGENT_SCHEME):
+		return w.walkAgent(root)
+	case IsImage(root):
+		return w.walkImage(root)
+	}
+	w.device = w.s.rootDevice(root)
+	return w.walkTree(root, root)
+}
+
+// rootDevice returns the device of root if OneFileSystem is set, and
+// otherwise 0.
+func (s *Scanner) rootDevice(root string) uint64 {
+	if !s.OneFileSystem {
+		return 0
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return 0
+	}
+	dev, _ := fileID(root, info)
+	return dev
+}
+
+// otherDevice reports whether the entry at path is on a different device
+// than device, as returned by rootDevice. It is always false if device is 0.
+func otherDevice(device uint64, path string, info os.FileInfo) bool {
+	if device == 0 {
+		return false
+	}
+	dev, _ := fileID(path, info)
+	return dev != 0 && dev != device
+}
+
+// walkTree walks the tree at dir, which is root or a directory below it
+// reached through a symlink. Symlinks are skipped unless FollowSymlinks is
+// set; dir itself is always followed, so a root may be a symlink.
+func (w *walker) walkTree(root string, dir string) error {
+	s := w.s
+	return filepath.Walk(dir, w.noteEmpty(dir,
+		func(path string, info os.FileInfo, err error) error {
+			if ctxErr := w.ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			s.stats.Entries++
+			if s.Progress != nil {
+				defer s.Progress(s.stats)
+			}
+
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if path != dir && !s.FollowSymlinks {
+					s.filtered(path, "symbolic link")
+					return nil
+				}
+				target, statErr := os.Stat(path)
+				if statErr != nil {
+					s.skip(path, "stat", statErr)
+					return nil
+				}
+				if target.IsDir() {
+					if path == dir {
+						// filepath.Walk does not descend into a symlink,
+						// so walk the tree it points to from a path
+						// inside it instead.
+						return w.walkTree(root, path+string(filepath.Separator))
+					}
+					if !s.include(root, path, target) {
+						return nil
+					}
+					if otherDevice(w.device, path, target) {
+						s.filtered(path, "on another filesystem")
+						return nil
+					}
+					return w.walkTree(root, path)
+				}
+				info = target
+			}
+
+			if info != nil && !s.include(root, path, info) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if err != nil {
+				// Only a root that cannot be walked stops the scan; an
+				// entry below it that cannot be read is skipped, along
+				// with its contents if it is a directory.
+				if path == dir {
+					return err
+				}
+				s.skip(path, "walk", err)
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if path != dir && otherDevice(w.device, path, info) {
+					s.filtered(path, "on another filesystem")
+					return filepath.SkipDir
+				}
+				if !w.reference && len(w.references) > 0 {
+					if abs, err := filepath.Abs(path); err == nil && w.references[pathKey(abs)] {
+						return filepath.SkipDir
+					}
+				}
+				if w.visited != nil {
+					dev, ino := fileID(path, info)
+					if ino != 0 {
+						if w.visited[[2]uint64{dev, ino}] {
+							return filepath.SkipDir
+						}
+						w.visited[[2]uint64{dev, ino}] = true
+					}
+				}
+				return nil
+			}
+			// Devices, pipes and sockets have no content to compare,
+			// and opening a pipe would block.
+			if !info.Mode().IsRegular() {
+				s.filtered(path, "not a regular file")
+				return nil
+			}
+
+			if dev, _ := fileID(path, info); w.device != 0 && dev != 0 && dev != w.device {
+				// A file symlinked or bind-mounted from another filesystem.
+				s.filtered(path, "on another filesystem")
+				return nil
+			}
+			w.add(root, path, info)
+			return nil
+		}))
+}
+
+// add adds the regular file at path, found under root, to the walk, along
+// with the members of the archive it is, if Archives is set.
+func (w *walker) add(root string, path string, info os.FileInfo) {
+	s := w.s
+	if w.full() {
+		return
+	}
+	dev, ino := fileID(path, info)
+	s.stats.Files++
+	s.stats.Bytes += info.Size()
+	c := candidate{
+		seq:  s.stats.Files,
+		file: File{Path: path, Root: root, Size: info.Size(), ModTime: info.ModTime(), Reference: w.reference},
+		dev:  dev,
+		ino:  ino,
+	}
+	w.files = append(w.files, c)
+	if w.full() {
+		return
+	}
+	if s.Archives {
+		if kind, ok := archiveKind(path); ok {
+			w.walkArchive(root, path, kind)
+		}
+	}
+}
+
+// full reports whether the walk has found MaxFiles files, and if so stops
+// it.
+func (w *walker) full() bool {
+	if w.stop == nil || w.s.MaxFiles <= 0 || int64(len(w.files)) < w.s.MaxFiles {
+		return false
+	}
+	w.stop(ErrMaxFiles)
+	return true
+}
+
+// hashAll hashes each candidate received from in with hash, using a pool
+// of workers, and passes the results to handle. Files being hashed when
+// ctx is cancelled are abandoned and reported with ctx's error. handle is
+// only ever called from the calling goroutine, so it may update scanner
+// state without locking. hashAll returns once in is closed and every
+// result has been handled.
+func (s *Scanner) hashAll(ctx context.Context, in <-chan candidate, hash func(candidate) hashResult, handle func(hashResult)) {
+	workers := s.workers()
+	results := make(chan hashResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range in {
+				results <- hash(c)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		handle(r)
+	}
+}
+
+// workers returns the number of files to hash at once: Workers, or one
+// per CPU, but no more than the read buffers MaxMemory allows, and at
+// least one.
+func (s *Scanner) workers() int {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if s.MaxMemory > 0 {
+		bufferSize := int64(s.ReadBufferSize)
+		if bufferSize <= 0 {
+			bufferSize = DEFAULT_READ_BUFFER
+		}
+		if fit := s.MaxMemory / MEMORY_BUFFER_SHARE / bufferSize; fit < int64(workers) {
+			workers = int(fit)
+		}
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// knownHash returns the hash of c's file recorded by the Checkpoint or the
+// Cache, if either has one, or computed while listing its archive.
+func (s *Scanner) knownHash(c candidate) (hashResult, bool) {
+	if m := s.archives.member(c.file.Path); m != nil && m.hash != "" {
+		return hashResult{candidate: c, hash: m.hash}, true
+	}
+	if s.Checkpoint != nil {
+		if h, ok := s.Checkpoint.lookup(c.file.Path); ok {
+			return hashResult{candidate: c, hash: h, resumed: true}, true
+		}
+	}
+	if s.Cache != nil {
+		if h, ok := s.Cache.lookup(c, s.hashIdentity()); ok {
+			return hashResult{candidate: c, hash: h, cached: true}, true
+		}
+	}
+	return hashResult{}, false
+}
+
+// fullHash returns the hash of c's whole file, reading it only if the hash
+// is not already known.
+func (s *Scanner) fullHash(ctx context.Context, c candidate) hashResult {
+	if r, ok := s.knownHash(c); ok {
+		return r
+	}
+	h, err := s.hashFile(ctx, c.file.Path)
+	return hashResult{candidate: c, hash: h, op: "read", err: err}
+}
+
+// Files returns every file found by the most recent scan, in walk order.
+// Paths that are links to a file are listed in its Links rather than on
+// their own.
+func (s *Scanner) Files() []File {
+	files := make([]File, len(s.files))
+	for i, c := range s.files {
+		files[i] = c.file
+	}
+	return files
+}
+
+// FileHash returns the hash computed for the file at path by the most
+// recent scan. Files with a unique size or sample, and those that could not
+// be read, were never fully hashed and have none.
+func (s *Scanner) FileHash(path string) (string, bool) {
+	h, ok := s.hashes[path]
+	return h, ok
+}
+
+// Stats returns the statistics of the most recent scan.
+func (s *Scanner) Stats() Stats {
+	return s.stats
+}
+
+// Collisions returns the groups of the most recent scan that Verify found to
+// contain files with matching hashes but differing content, with all of
+// their original members.
+func (s *Scanner) Collisions() []DupeGroup {
+	return s.collisions
+}
+
+func (s *Scanner) skip(path string, defaultOp string, err error) {
+	s.skipped[path] = true
+	if s.OnError == nil {
+		return
+	}
+	op := defaultOp
+	if pe, ok := err.(*os.PathError); ok {
+		op = pe.Op
+	}
+	if isLocked(err) {
+		op = "locked"
+	}
+	s.OnError(path, op, err)
+}
+
+// addToTST appends c to the candidates stored under key in t.
+func (s *Scanner) addToTST(t *trietst.TST, key string, c candidate) {
+	var cands []candidate
+	if existing := t.Get(key); existing != nil {
+		cands = existing.([]candidate)
+	}
+	t.Set(key, append(cands, c))
+}
+
+// stopAt reports whether StopAt accepts any group formed by the files
+// hashed so far with hash h.
+func (s *Scanner) stopAt(h string) bool {
+	c := s.hashTST.Get(h)
+	if c == nil || len(c.([]candidate)) < 2 {
+		return false
+	}
+	cands := append([]candidate(nil), c.([]candidate)...)
+	sort.Slice(cands, func(i, j int) bool { return cands[i].seq < cands[j].seq })
+	group := DupeGroup{Hash: h}
+	for _, cand := range cands {
+		group.Files = append(group.Files, cand.file)
+	}
+	group.ID = groupID(group.Hash, group.Files)
+	groups := []DupeGroup{group}
+	if s.Verify {
+		groups = s.verify(groups)
+	}
+	if len(s.References) > 0 {
+		groups = referenced(groups)
+	}
+	for _, g := range groups {
+		if s.StopAt(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// referenced returns the groups containing both reference and other files.
+func referenced(groups []DupeGroup) []DupeGroup {
+	var matched []DupeGroup
+	for _, g := range groups {
+		refs := 0
+		for _, f := range g.Files {
+			if f.Reference {
+				refs++
+			}
+		}
+		if refs > 0 && refs < len(g.Files) {
+			matched = append(matched, g)
+		}
+	}
+	return matched
+}
+
+// groups returns the groups with more than one member, in the walk order
+// of their first file, with their files in walk order. Unlike the order of
+// their hashes, which changes with the HighwayKey, this is the same on
+// every scan of the same tree.
+func (s *Scanner) groups() []DupeGroup {
+	var groups []DupeGroup
+	var first []int64
+	s.hashTST.ForEach(
+		func(k string, c interface{}) {
+			if c == nil || len(c.([]candidate)) < 2 {
+				return
+			}
+			cands := c.([]candidate)
+			sort.Slice(cands, func(i, j int) bool { return cands[i].seq < cands[j].seq })
+
+			group := DupeGroup{Hash: k}
+			for _, cand := range cands {
+				group.Files = append(group.Files, cand.file)
+			}
+			group.ID = groupID(group.Hash, group.Files)
+			groups = append(groups, group)
+			first = append(first, cands[0].seq)
+		})
+	sort.Sort(bySeq{groups, first})
+	return groups
+}
+
+// bySeq sorts groups by the seq of their first file, held in first.
+type bySeq struct {
+	groups []DupeGroup
+	first  []int64
+}
+
+func (b bySeq) Len() int           { return len(b.groups) }
+func (b bySeq) Less(i, j int) bool { return b.first[i] < b.first[j] }
+func (b bySeq) Swap(i, j int) {
+	b.groups[i], b.groups[j] = b.groups[j], b.groups[i]
+	b.first[i], b.first[j] = b.first[j], b.first[i]
+}
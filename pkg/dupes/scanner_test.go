@@ -0,0 +1,185 @@
+package dupes
+
+import (
+	"context"
+	"encoding/binary"
+	"hash"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree creates the files, named by their paths relative to a new
+// temporary directory, with the given content, and returns the directory.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// groupPaths returns the paths of the files of each group, relative to
+// root, with the paths of their links after them.
+func groupPaths(t *testing.T, root string, groups []DupeGroup) [][]string {
+	t.Helper()
+	rel := func(path string) string {
+		r, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.ToSlash(r)
+	}
+	var paths [][]string
+	for _, g := range groups {
+		var p []string
+		for _, f := range g.Files {
+			p = append(p, rel(f.Path))
+			for _, l := range f.Links {
+				p = append(p, rel(l))
+			}
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func TestScan(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  [][]string
+	}{
+		{"no files", nil, nil},
+		{"no duplicates", map[string]string{"a": "one", "b": "two", "c": "three", "d/e": "four"}, nil},
+		{"same size", map[string]string{"a": "aaa", "b": "bbb", "c": "ccc"}, nil},
+		{"duplicates", map[string]string{"a": "same", "b": "diff", "d/c": "same", "d/e": "same"},
+			[][]string{{"a", "d/c", "d/e"}}},
+		{"two groups", map[string]string{"a": "one", "b": "two", "c": "one", "d": "two", "e": "three"},
+			[][]string{{"a", "c"}, {"b", "d"}}},
+		// Empty files are skipped, and not counted, unless IncludeEmpty
+		// is set.
+		{"empty files", map[string]string{"a": "", "b": ""}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := writeTree(t, tc.files)
+			var s Scanner
+			groups, err := s.Scan(context.Background(), root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := groupPaths(t, root, groups); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Scan = %q; want %q", got, tc.want)
+			}
+			for _, g := range groups {
+				if g.ID != groupID(g.Hash, g.Files) {
+					t.Errorf("group %s has the ID %s; want %s", g.Hash, g.ID, groupID(g.Hash, g.Files))
+				}
+			}
+			var files int64
+			for _, content := range tc.files {
+				if content != "" {
+					files++
+				}
+			}
+			if n := s.Stats().Files; n != files {
+				t.Errorf("Stats().Files = %d; want %d", n, files)
+			}
+		})
+	}
+}
+
+// lengthHash is a hash whose sum is only the number of bytes written, so
+// that every file of a size collides with the others.
+type lengthHash struct{ n uint64 }
+
+func (h *lengthHash) Write(p []byte) (int, error) {
+	h.n += uint64(len(p))
+	return len(p), nil
+}
+func (h *lengthHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.n)
+}
+func (h *lengthHash) Reset()         { h.n = 0 }
+func (h *lengthHash) Size() int      { return 8 }
+func (h *lengthHash) BlockSize() int { return 1 }
+
+func TestScanVerifyCollisions(t *testing.T) {
+	hashConstructors["length"] = func(key []byte) (hash.Hash, error) { return &lengthHash{}, nil }
+	defer delete(hashConstructors, "length")
+
+	root := writeTree(t, map[string]string{
+		"a": "aaaa",
+		"b": "bbbb",
+		"c": "aaaa",
+		"d": "cccc",
+		"e": "bbbb",
+		"f": "dddd",
+	})
+	s := Scanner{Hash: "length"}
+	groups, err := s.Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"a", "b", "c", "d", "e", "f"}}
+	if got := groupPaths(t, root, groups); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scan without Verify = %q; want %q", got, want)
+	}
+
+	s.Verify = true
+	groups, err = s.Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][]string{{"a", "c"}, {"b", "e"}}
+	if got := groupPaths(t, root, groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan with Verify = %q; want %q", got, want)
+	}
+	if groups[0].ID == groups[1].ID {
+		t.Errorf("the groups split by Verify share the ID %s", groups[0].ID)
+	}
+	if n := s.Stats().Collisions; n != 1 {
+		t.Errorf("Stats().Collisions = %d; want 1", n)
+	}
+	if c := s.Collisions(); len(c) != 1 || len(c[0].Files) != 6 {
+		t.Errorf("Collisions = %v; want the one group of all six files", c)
+	}
+}
+
+func TestScanHardLinks(t *testing.T) {
+	root := writeTree(t, map[string]string{"a": "same", "c": "same", "e": "unique"})
+	for _, link := range []struct{ from, to string }{{"a", "b"}, {"e", "f"}} {
+		if err := os.Link(filepath.Join(root, link.from), filepath.Join(root, link.to)); err != nil {
+			t.Skip("hard links are not supported here:", err)
+		}
+	}
+
+	var s Scanner
+	groups, err := s.Scan(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b shares a's storage, so the group has one duplicate and e, linked
+	// only to f, none.
+	want := [][]string{{"a", "b", "c"}}
+	if got := groupPaths(t, root, groups); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Scan = %q; want %q", got, want)
+	}
+	if n := groups[0].Duplicates(); n != 1 {
+		t.Errorf("Duplicates = %d; want 1", n)
+	}
+	if n := groups[0].WastedBytes(); n != int64(len("same")) {
+		t.Errorf("WastedBytes = %d; want %d", n, len("same"))
+	}
+	if n := s.Stats().Links; n != 2 {
+		t.Errorf("Stats().Links = %d; want 2", n)
+	}
+}
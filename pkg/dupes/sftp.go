@@ -0,0 +1,676 @@
+package dupes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of the roots that name a directory on another machine, read over
+// SFTP, as in "sftp://backup@nas/srv/photos". A path starting with /~/ is
+// relative to the user's home directory.
+const SFTP_SCHEME = "sftp://"
+
+// Size of each read request sent to an SFTP server, which every server
+// accepts, and the number of them kept in flight while a file is read.
+const (
+	sftpReadSize  = 32 << 10
+	sftpReadAhead = 16
+)
+
+// SFTP version 3 packet types, status codes and attribute flags.
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRealpath = 16
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+
+	sftpOK       = 0
+	sftpEOF      = 1
+	sftpNoFile   = 2
+	sftpDenied   = 3
+	sftpOpenRead = 1
+
+	sftpAttrSize     = 0x1
+	sftpAttrUIDGID   = 0x2
+	sftpAttrMode     = 0x4
+	sftpAttrTimes    = 0x8
+	sftpAttrExtended = 0x80000000
+
+	sftpTypeMask = 0170000
+	sftpTypeDir  = 0040000
+	sftpTypeFile = 0100000
+	sftpTypeLink = 0120000
+)
+
+var errSFTPPacket = errors.New("dupes: malformed SFTP packet")
+
+// IsRemote reports whether path is an S3, SFTP or agent root or file,
+// rather than a local path.
+func IsRemote(path string) bool {
+	return isS3(path) || strings.HasPrefix(path, SFTP_SCHEME) || strings.HasPrefix(path, AGENT_SCHEME)
+}
+
+// parseRemoteURL splits an SFTP or agent root, with the given scheme, into
+// the scheme://user@host:port it is on, which stands for the archive its
+// files are members of, and the directory on that host.
+func parseRemoteURL(root string, scheme string) (archive string, dir string, err error) {
+	u, err := url.Parse(root)
+	if err != nil || u.Scheme+"://" != scheme || u.Hostname() == "" {
+		return "", "", fmt.Errorf("dupes: invalid root %q; give %suser@host/path", root, scheme)
+	}
+	// ssh would take such a host for an option, as in -oProxyCommand=...
+	if strings.HasPrefix(u.Hostname(), "-") {
+		return "", "", fmt.Errorf("dupes: invalid host %q in root %q", u.Hostname(), root)
+	}
+	archive = scheme + u.Host
+	if u.User != nil {
+		archive = scheme + u.User.Username() + "@" + u.Host
+	}
+	dir = u.Path
+	switch {
+	case dir == "/~" || strings.HasPrefix(dir, "/~/"):
+		// REALPATH resolves relative paths against the home directory.
+		dir = "." + dir[2:]
+	case dir == "":
+		dir = "/"
+	}
+	return archive, dir, nil
+}
+
+// sftpConn is an SFTP session with a host, run over the ssh command, or
+// DUPES_SSH_COMMAND if it is set, so that the user's keys, agent and
+// ssh_config apply. Requests from any number of goroutines are sent as
+// they are made, and the replies matched to them by ID.
+type sftpConn struct {
+	cmd     *exec.Cmd
+	w       io.WriteCloser
+	mu      sync.Mutex
+	next    uint32
+	pending map[uint32]chan []byte
+	err     error
+}
+
+// sftpEntry is a directory entry listed by READDIR.
+type sftpEntry struct {
+	name    string
+	size    int64
+	mode    uint32
+	modTime time.Time
+}
+
+// sshCommand returns the command running ssh, or DUPES_SSH_COMMAND, with
+// the options opts, to the host of archive, as returned by parseRemoteURL,
+// to run the command remote there.
+func sshCommand(archive string, opts []string, remote ...string) (*exec.Cmd, error) {
+	u, err := url.Parse(archive)
+	if err != nil {
+		return nil, err
+	}
+	ssh := strings.Fields(os.Getenv("DUPES_SSH_COMMAND"))
+	if len(ssh) == 0 {
+		ssh = []string{"ssh"}
+	}
+	if u.Port() != "" {
+		ssh = append(ssh, "-p", u.Port())
+	}
+	if u.User != nil {
+		ssh = append(ssh, "-l", u.User.Username())
+	}
+	// The host follows --, so that ssh never reads it as an option.
+	ssh = append(append(append(ssh, opts...), "--", u.Hostname()), remote...)
+	cmd := exec.Command(ssh[0], ssh[1:]...)
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
+
+// dialSFTP starts an SFTP session on the host of archive, as returned by
+// parseRemoteURL.
+func dialSFTP(archive string) (*sftpConn, error) {
+	u, err := url.Parse(archive)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := sshCommand(archive, []string{"-s"}, "sftp")
+	if err != nil {
+		return nil, err
+	}
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("dupes: connecting to %s: %v", u.Host, err)
+	}
+	c := &sftpConn{cmd: cmd, w: w, pending: make(map[uint32]chan []byte)}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	init := []byte{0, 0, 0, 5, sftpInit, 0, 0, 0, 3}
+	_, err = w.Write(init)
+	var typ byte
+	if err == nil {
+		typ, _, err = readSFTPPacket(br)
+	}
+	if err == nil && typ != sftpVersion {
+		err = errSFTPPacket
+	}
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("dupes: connecting to %s over SFTP: %v", u.Host, err)
+	}
+	go c.receive(br)
+	return c, nil
+}
+
+// readSFTPPacket reads a packet, and returns its type and the rest of it.
+func readSFTPPacket(r io.Reader) (byte, []byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 1 || n > 1<<20 {
+		return 0, nil, errSFTPPacket
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return data[0], data[1:], nil
+}
+
+// receive passes each reply read from r to the request it answers, until
+// the session ends.
+func (c *sftpConn) receive(r io.Reader) {
+	for {
+		typ, data, err := readSFTPPacket(r)
+		if err == nil && len(data) < 4 {
+			err = errSFTPPacket
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("dupes: the SFTP session ended")
+			}
+			c.mu.Lock()
+			c.fail(err)
+			c.mu.Unlock()
+			return
+		}
+		id := binary.BigEndian.Uint32(data)
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- append([]byte{typ}, data[4:]...)
+		}
+	}
+}
+
+// fail ends the session with err, failing the requests waiting for a
+// reply. The caller holds c.mu.
+func (c *sftpConn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// send sends a request of type typ with payload, and returns the channel
+// its reply will be passed on.
+func (c *sftpConn) send(typ byte, payload []byte) (<-chan []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	id := c.next
+	c.next++
+	ch := make(chan []byte, 1)
+	c.pending[id] = ch
+	packet := binary.BigEndian.AppendUint32(nil, uint32(5+len(payload)))
+	packet = append(packet, typ)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	if _, err := c.w.Write(append(packet, payload...)); err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return ch, nil
+}
+
+// reply waits for the reply on ch, and returns its type and contents, or
+// the error of a status other than OK.
+func (c *sftpConn) reply(ch <-chan []byte) (byte, *sftpReader, error) {
+	resp, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return 0, nil, c.err
+	}
+	r := &sftpReader{b: resp[1:]}
+	if resp[0] == sftpStatus {
+		return resp[0], r, r.status()
+	}
+	return resp[0], r, nil
+}
+
+// call sends a request and waits for its reply, which must be of type
+// want.
+func (c *sftpConn) call(typ byte, payload []byte, want byte) (*sftpReader, error) {
+	ch, err := c.send(typ, payload)
+	if err != nil {
+		return nil, err
+	}
+	got, r, err := c.reply(ch)
+	if err != nil {
+		return nil, err
+	}
+	if got != want {
+		return nil, errSFTPPacket
+	}
+	return r, nil
+}
+
+// realpath returns the absolute form of p on the host.
+func (c *sftpConn) realpath(p string) (string, error) {
+	r, err := c.call(sftpRealpath, sftpString(nil, p), sftpName)
+	if err != nil {
+		return "", err
+	}
+	r.u32()
+	name := r.str()
+	return name, r.err
+}
+
+// readDir lists the entries of the directory dir, including . and ..,
+// with their attributes as lstat gives them.
+func (c *sftpConn) readDir(dir string) ([]sftpEntry, error) {
+	r, err := c.call(sftpOpendir, sftpString(nil, dir), sftpHandle)
+	if err != nil {
+		return nil, err
+	}
+	handle := r.str()
+	defer c.closeHandle(handle)
+	var entries []sftpEntry
+	for {
+		r, err := c.call(sftpReaddir, sftpString(nil, handle), sftpName)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			e := sftpEntry{name: r.str()}
+			r.str()
+			e.size, e.mode, e.modTime = r.attrs()
+			entries = append(entries, e)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+}
+
+// open opens the file at p on the host, size bytes long, for reading.
+func (c *sftpConn) open(p string, size int64) (*sftpFile, error) {
+	payload := sftpString(nil, p)
+	payload = binary.BigEndian.AppendUint32(payload, sftpOpenRead)
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	r, err := c.call(sftpOpen, payload, sftpHandle)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	return &sftpFile{c: c, handle: r.str(), size: size}, r.err
+}
+
+// closeHandle closes a file or directory handle.
+func (c *sftpConn) closeHandle(handle string) error {
+	ch, err := c.send(sftpClose, sftpString(nil, handle))
+	if err != nil {
+		return err
+	}
+	_, _, err = c.reply(ch)
+	return err
+}
+
+// close ends the session.
+func (c *sftpConn) close() {
+	c.w.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+}
+
+// sftpString appends s to b as SFTP encodes strings, after their length.
+func sftpString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sftpReader decodes the fields of a reply. A reply too short for a field
+// sets err, and every field read after it is zero.
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) u32() uint32 {
+	if len(r.b) < 4 {
+		r.b, r.err = nil, errSFTPPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sftpReader) u64() uint64 {
+	return uint64(r.u32())<<32 | uint64(r.u32())
+}
+
+func (r *sftpReader) bytes() []byte {
+	n := r.u32()
+	if uint32(len(r.b)) < n {
+		r.b, r.err = nil, errSFTPPacket
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *sftpReader) str() string {
+	return string(r.bytes())
+}
+
+// attrs decodes a file's attributes, returning those dupes uses.
+func (r *sftpReader) attrs() (size int64, mode uint32, modTime time.Time) {
+	flags := r.u32()
+	if flags&sftpAttrSize != 0 {
+		size = int64(r.u64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		r.u64()
+	}
+	if flags&sftpAttrMode != 0 {
+		mode = r.u32()
+	}
+	if flags&sftpAttrTimes != 0 {
+		r.u32()
+		modTime = time.Unix(int64(r.u32()), 0)
+	}
+	if flags&sftpAttrExtended != 0 {
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.str()
+			r.str()
+		}
+	}
+	return size, mode, modTime
+}
+
+// status returns the error of a status reply, or nil if it is OK.
+func (r *sftpReader) status() error {
+	code := r.u32()
+	msg := r.str()
+	switch {
+	case r.err != nil:
+		return r.err
+	case code == sftpOK:
+		return nil
+	case code == sftpEOF:
+		return io.EOF
+	case code == sftpNoFile:
+		return os.ErrNotExist
+	case code == sftpDenied:
+		return os.ErrPermission
+	}
+	return errors.New(msg)
+}
+
+// sftpFile is a file open on an SFTP host. Read keeps sftpReadAhead
+// requests in flight, so that reading is not held up by the round trip to
+// the host; ReadAt sends the requests for all it reads at once.
+type sftpFile struct {
+	c      *sftpConn
+	handle string
+	size   int64
+	next   int64
+	ahead  []sftpPending
+	buf    []byte
+	err    error
+}
+
+// sftpPending is a read request awaiting its reply.
+type sftpPending struct {
+	off int64
+	n   int
+	ch  <-chan []byte
+}
+
+// request sends a request for n bytes at off.
+func (f *sftpFile) request(off int64, n int) (sftpPending, error) {
+	payload := sftpString(nil, f.handle)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(off))
+	payload = binary.BigEndian.AppendUint32(payload, uint32(n))
+	ch, err := f.c.send(sftpRead, payload)
+	return sftpPending{off, n, ch}, err
+}
+
+// data waits for the reply to a read request.
+func (f *sftpFile) data(p sftpPending) ([]byte, error) {
+	typ, r, err := f.c.reply(p.ch)
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpData {
+		return nil, errSFTPPacket
+	}
+	data := r.bytes()
+	if r.err == nil && len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, r.err
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		for len(f.ahead) < sftpReadAhead && f.next < f.size && f.err == nil {
+			n := int64(sftpReadSize)
+			if f.size-f.next < n {
+				n = f.size - f.next
+			}
+			req, err := f.request(f.next, int(n))
+			if err != nil {
+				f.err = err
+				break
+			}
+			f.ahead = append(f.ahead, req)
+			f.next += n
+		}
+		if len(f.ahead) == 0 {
+			if f.err == nil {
+				f.err = io.EOF
+			}
+			continue
+		}
+		req := f.ahead[0]
+		f.ahead = f.ahead[1:]
+		data, err := f.data(req)
+		if err != nil {
+			f.err = err
+			continue
+		}
+		if len(data) < req.n {
+			// The server may return less than was asked for, so the
+			// rest is asked for before the reads already sent beyond it.
+			gap, err := f.request(req.off+int64(len(data)), req.n-len(data))
+			if err != nil {
+				f.err = err
+			} else {
+				f.ahead = append([]sftpPending{gap}, f.ahead...)
+			}
+		}
+		f.buf = data
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+func (f *sftpFile) ReadAt(p []byte, off int64) (int, error) {
+	var reqs []sftpPending
+	for i := 0; i < len(p); i += sftpReadSize {
+		n := len(p) - i
+		if n > sftpReadSize {
+			n = sftpReadSize
+		}
+		req, err := f.request(off+int64(i), n)
+		if err != nil {
+			return 0, err
+		}
+		reqs = append(reqs, req)
+	}
+	read := 0
+	for _, req := range reqs {
+		data, err := f.data(req)
+		if err != nil {
+			return read, err
+		}
+		start := int(req.off - off)
+		copy(p[start:], data)
+		read += len(data)
+		if len(data) < req.n {
+			n, err := f.ReadAt(p[start+len(data):start+req.n], req.off+int64(len(data)))
+			read += n
+			if err != nil {
+				return read, err
+			}
+		}
+	}
+	return read, nil
+}
+
+func (f *sftpFile) Close() error {
+	return f.c.closeHandle(f.handle)
+}
+
+// sftpConn returns the session with the host of archive, as returned by
+// parseRemoteURL, starting it the first time.
+func (a *archives) sftpConn(archive string) (*sftpConn, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.sftp[archive]; ok {
+		return c, nil
+	}
+	c, err := dialSFTP(archive)
+	if err != nil {
+		return nil, err
+	}
+	if a.sftp == nil {
+		a.sftp = make(map[string]*sftpConn)
+	}
+	a.sftp[archive] = c
+	return c, nil
+}
+
+// openSFTP opens a file found by walkSFTP.
+func (s *Scanner) openSFTP(m *archiveMember) (*sftpFile, error) {
+	c, err := s.archives.sftpConn(m.archive)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(m.name, m.size)
+}
+
+// walkSFTP adds the files under the SFTP root to the walk. They are found
+// as archive members are, the host standing for the archive, and filtered
+// by their paths relative to the root. Symbolic links are not followed.
+func (w *walker) walkSFTP(root string) error {
+	archive, dir, err := parseRemoteURL(root, SFTP_SCHEME)
+	if err != nil {
+		return err
+	}
+	c, err := w.s.archives.sftpConn(archive)
+	if err != nil {
+		return err
+	}
+	if dir, err = c.realpath(dir); err != nil {
+		return &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	return w.walkSFTPDir(c, root, archive, dir, dir)
+}
+
+// walkSFTPDir walks dir, below top, the directory of root.
+func (w *walker) walkSFTPDir(c *sftpConn, root string, archive string, top string, dir string) error {
+	s := w.s
+	entries, err := c.readDir(dir)
+	if err != nil {
+		if dir == top {
+			return &os.PathError{Op: "open", Path: root, Err: err}
+		}
+		s.skip(archive+dir, "walk", err)
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	for _, e := range entries {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		if e.name == "." || e.name == ".." {
+			continue
+		}
+		p := path.Join(dir, e.name)
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, top), "/")
+		s.stats.Entries++
+		if s.Progress != nil {
+			s.Progress(s.stats)
+		}
+		switch e.mode & sftpTypeMask {
+		case sftpTypeDir:
+			if s.SkipHidden && strings.HasPrefix(e.name, ".") || s.memberExcluded(rel) {
+				continue
+			}
+			if err := w.walkSFTPDir(c, root, archive, top, p); err != nil {
+				return err
+			}
+		case sftpTypeFile:
+			m := &archiveMember{archive: archive, name: p, kind: archiveSFTP, size: e.size}
+			if s.memberIncluded(&archiveMember{name: rel, size: e.size}, e.modTime) {
+				w.addMember(root, archive+p, m, e.modTime)
+			}
+		case sftpTypeLink:
+			s.filtered(archive+p, "symbolic link")
+		default:
+			s.filtered(archive+p, "not a regular file")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package dupes
+
+// disjointSet partitions files, identified by seq, into groups for
+// SimilarImages and SimilarAudio. Each group is represented by its member
+// with the lowest seq.
+type disjointSet map[int64]int64
+
+// add makes seq a group of its own.
+func (d disjointSet) add(seq int64) {
+	d[seq] = seq
+}
+
+// find returns the representative of seq's group.
+func (d disjointSet) find(seq int64) int64 {
+	for d[seq] != seq {
+		d[seq] = d[d[seq]]
+		seq = d[seq]
+	}
+	return seq
+}
+
+// union merges the groups of a and b.
+func (d disjointSet) union(a, b int64) {
+	a, b = d.find(a), d.find(b)
+	if a > b {
+		a, b = b, a
+	}
+	d[b] = a
+}
+
+// groups returns the groups of cands with more than one member, in the
+// order of their first member in cands. Members keep their order in cands.
+// Files not added to d are left out.
+func (d disjointSet) groups(cands []candidate) [][]candidate {
+	members := make(map[int64][]candidate)
+	var order []int64
+	for _, c := range cands {
+		if _, ok := d[c.seq]; !ok {
+			continue
+		}
+		root := d.find(c.seq)
+		if _, ok := members[root]; !ok {
+			order = append(order, root)
+		}
+		members[root] = append(members[root], c)
+	}
+	var groups [][]candidate
+	for _, root := range order {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
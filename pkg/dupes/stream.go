@@ -0,0 +1,67 @@
+package dupes
+
+import (
+	"context"
+	"sort"
+)
+
+// sizeClasses tracks the candidates of each size still being hashed, so
+// that a size's groups can be reported as soon as they are complete.
+type sizeClasses struct {
+	remaining map[int64]int
+	hashed    map[int64][]candidate
+}
+
+func newSizeClasses(candidates []candidate) *sizeClasses {
+	p := &sizeClasses{remaining: make(map[int64]int), hashed: make(map[int64][]candidate)}
+	for _, c := range candidates {
+		p.remaining[c.file.Size]++
+	}
+	return p
+}
+
+// finishCandidate records that r's candidate has been handled and, if it
+// was the last of its size, passes the groups of that size to OnGroup.
+func (s *Scanner) finishCandidate(ctx context.Context, pending *sizeClasses, r hashResult) {
+	size := r.file.Size
+	if r.err == nil {
+		pending.hashed[size] = append(pending.hashed[size], r.candidate)
+	}
+	pending.remaining[size]--
+	if pending.remaining[size] > 0 {
+		return
+	}
+	cands := pending.hashed[size]
+	delete(pending.hashed, size)
+	delete(pending.remaining, size)
+	if ctx.Err() != nil {
+		return
+	}
+
+	sort.Slice(cands, func(i, j int) bool { return cands[i].seq < cands[j].seq })
+	byHash := make(map[string][]File)
+	var hashes []string
+	for _, c := range cands {
+		h := s.hashes[c.file.Path]
+		if _, ok := byHash[h]; !ok {
+			hashes = append(hashes, h)
+		}
+		byHash[h] = append(byHash[h], c.file)
+	}
+	sort.Strings(hashes)
+	var groups []DupeGroup
+	for _, h := range hashes {
+		if files := byHash[h]; len(files) > 1 {
+			groups = append(groups, DupeGroup{ID: groupID(h, files), Hash: h, Files: files})
+		}
+	}
+	if s.Verify {
+		groups = s.verify(groups)
+	}
+	if len(s.References) > 0 {
+		groups = referenced(groups)
+	}
+	for _, g := range groups {
+		s.OnGroup(g)
+	}
+}
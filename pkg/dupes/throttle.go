@@ -0,0 +1,52 @@
+package dupes
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out reads so that, together, they read no more than
+// rate bytes per second.
+type rateLimiter struct {
+	rate float64
+	mu   sync.Mutex
+	// next is the time by which the bytes read so far are allowed.
+	next time.Time
+}
+
+// wait blocks until n more bytes are allowed. Time spent without reading
+// is not saved up, so reads never burst above the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledReader is a Reader limited by a rateLimiter.
+type throttledReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.l.wait(n)
+	}
+	return n, err
+}
+
+// throttle returns r limited to the scanner's MaxReadRate, if it has one.
+func (s *Scanner) throttle(r io.Reader) io.Reader {
+	if s.limiter == nil {
+		return r
+	}
+	return throttledReader{r, s.limiter}
+}
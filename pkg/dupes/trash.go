@@ -0,0 +1,13 @@
+package dupes
+
+// TrashAction moves duplicate files to the platform's trash, so that they
+// can be restored: the XDG trash on Linux and other Unix systems, ~/.Trash
+// on macOS and the Recycle Bin on Windows.
+type TrashAction struct{}
+
+func (TrashAction) Apply(keep File, dupe File) error {
+	if err := checkNotSameFile(keep, dupe); err != nil {
+		return err
+	}
+	return moveToTrash(dupe.Path)
+}
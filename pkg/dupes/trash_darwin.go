@@ -0,0 +1,32 @@
+package dupes
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// moveToTrash moves the file at path to ~/.Trash, adding a number to its
+// name if the trash already holds a file of that name.
+func moveToTrash(path string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	trash := filepath.Join(home, ".Trash")
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = stem + " " + strconv.Itoa(i) + ext
+		}
+		err := moveFile(path, filepath.Join(trash, name))
+		if os.IsExist(err) {
+			continue
+		}
+		return err
+	}
+}
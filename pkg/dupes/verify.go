@@ -0,0 +1,123 @@
+package dupes
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// verify compares the files of each group byte for byte and splits groups
+// whose members turn out to differ. Files that cannot be read are skipped,
+// whether they fail as the file compared or as the first of a subset, the
+// one the others are compared with, whose place the next member then
+// takes. Files scanned by agents can only be compared by hash, and join
+// the first file's subset.
+// Each group that had to be split is recorded as a collision, and its
+// byte-identical subsets with more than one member are returned in its
+// place. The first subset keeps the group's ID; the others derive theirs
+// from the hash and their position. Groups verified earlier in the scan
+// are not read again.
+func (s *Scanner) verify(groups []DupeGroup) []DupeGroup {
+	var verified []DupeGroup
+	for _, g := range groups {
+		if subs, ok := s.verified[g.ID]; ok {
+			verified = append(verified, subs...)
+			continue
+		}
+		var classes [][]File
+		var byAgent []File
+		for _, f := range g.Files {
+			if m := s.archives.member(f.Path); m != nil && m.kind == archiveAgent {
+				byAgent = append(byAgent, f)
+				continue
+			}
+			placed := false
+			for i := 0; i < len(classes) && !placed; i++ {
+				same, failed, err := s.sameContent(classes[i][0].Path, f.Path)
+				switch {
+				case err != nil && failed == classes[i][0].Path:
+					s.skip(failed, "read", err)
+					if classes[i] = classes[i][1:]; len(classes[i]) == 0 {
+						classes = append(classes[:i], classes[i+1:]...)
+					}
+					// Compare f with the subset's next file, if any.
+					i--
+				case err != nil:
+					s.skip(f.Path, "read", err)
+					placed = true
+				case same:
+					classes[i] = append(classes[i], f)
+					placed = true
+				}
+			}
+			if !placed {
+				classes = append(classes, []File{f})
+			}
+		}
+
+		if len(classes) == 0 {
+			classes = append(classes, nil)
+		}
+		classes[0] = append(classes[0], byAgent...)
+		if len(classes) > 1 {
+			s.stats.Collisions++
+			s.collisions = append(s.collisions, g)
+		}
+		var subs []DupeGroup
+		for _, class := range classes {
+			if len(class) < 2 {
+				continue
+			}
+			sub := DupeGroup{ID: g.ID, Hash: g.Hash, Files: class}
+			if len(subs) > 0 {
+				sub.ID = groupID(g.Hash+"/"+strconv.Itoa(len(subs)), class)
+			}
+			subs = append(subs, sub)
+		}
+		s.verified[g.ID] = subs
+		verified = append(verified, subs...)
+	}
+	return verified
+}
+
+// sameContent reports whether the files at a and b have identical
+// contents. If one cannot be read, it returns its path as failed, with
+// the error.
+func (s *Scanner) sameContent(a, b string) (same bool, failed string, err error) {
+	fa, err := s.open(a)
+	if err != nil {
+		return false, a, err
+	}
+	defer fa.Close()
+	fb, err := s.open(b)
+	if err != nil {
+		return false, b, err
+	}
+	defer fb.Close()
+
+	bpa := s.bufferPool.Get().(*[]byte)
+	defer s.bufferPool.Put(bpa)
+	bpb := s.bufferPool.Get().(*[]byte)
+	defer s.bufferPool.Put(bpb)
+	bufA, bufB := *bpa, *bpb
+
+	ra, rb := s.throttle(fa), s.throttle(fb)
+	for {
+		na, errA := io.ReadFull(ra, bufA)
+		nb, errB := io.ReadFull(rb, bufB)
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, a, errA
+		}
+		if errB != nil && !endB {
+			return false, b, errB
+		}
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, "", nil
+		}
+		if endA || endB {
+			return endA && endB, "", nil
+		}
+	}
+}
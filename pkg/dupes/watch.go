@@ -0,0 +1,201 @@
+package dupes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Time a file must go unchanged before Watch hashes it, so that files still
+// being written are not hashed half finished.
+const DEFAULT_WATCH_SETTLE = 2 * time.Second
+
+// watchEntry is a file known to Watch. hash is empty until the file has
+// been hashed.
+type watchEntry struct {
+	file     File
+	dev, ino uint64
+	hash     string
+}
+
+// watchIndex holds every file Watch knows about, by path and by size.
+type watchIndex struct {
+	byPath map[string]*watchEntry
+	bySize map[int64][]*watchEntry
+}
+
+func (x *watchIndex) add(e *watchEntry) {
+	x.remove(e.file.Path)
+	x.byPath[e.file.Path] = e
+	x.bySize[e.file.Size] = append(x.bySize[e.file.Size], e)
+}
+
+func (x *watchIndex) remove(path string) {
+	e, ok := x.byPath[path]
+	if !ok {
+		return
+	}
+	delete(x.byPath, path)
+	same := x.bySize[e.file.Size]
+	for i, other := range same {
+		if other == e {
+			x.bySize[e.file.Size] = append(same[:i], same[i+1:]...)
+			break
+		}
+	}
+}
+
+// Watch keeps monitoring roots after a Scan of them, until ctx is cancelled.
+// Each file that is created or modified is hashed once it has been unchanged
+// for DEFAULT_WATCH_SETTLE, and if it turns out to duplicate a known file,
+// onDupe is called with the file and its whole group. New directories are
+// watched as they appear. Watch returns nil when ctx is cancelled.
+func (s *Scanner) Watch(ctx context.Context, roots []string, onDupe func(group DupeGroup, file File)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	index := &watchIndex{byPath: make(map[string]*watchEntry), bySize: make(map[int64][]*watchEntry)}
+	for _, c := range s.files {
+		if c.file.Archive != "" {
+			continue
+		}
+		index.add(&watchEntry{file: c.file, dev: c.dev, ino: c.ino, hash: s.hashes[c.file.Path]})
+	}
+
+	allRoots := make(map[string]bool)
+	for _, root := range append(append([]string(nil), roots...), s.References...) {
+		allRoots[root] = true
+		if err := s.watchTree(watcher, root, root); err != nil {
+			return err
+		}
+	}
+	isReference := make(map[string]bool)
+	for _, ref := range s.References {
+		isReference[ref] = true
+	}
+
+	pending := make(map[string]time.Time)
+	tick := time.NewTicker(DEFAULT_WATCH_SETTLE / 4)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			s.skip("", "watch", err)
+		case e := <-watcher.Events:
+			if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				index.remove(e.Name)
+				delete(pending, e.Name)
+				continue
+			}
+			if e.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				pending[e.Name] = time.Now()
+			}
+		case now := <-tick.C:
+			for path, changed := range pending {
+				if now.Sub(changed) < DEFAULT_WATCH_SETTLE {
+					continue
+				}
+				delete(pending, path)
+				root := rootOf(allRoots, path)
+				if root == "" {
+					continue
+				}
+				s.watchArrival(ctx, watcher, index, root, isReference[root], path, onDupe)
+			}
+		}
+	}
+}
+
+// watchTree adds every directory under dir that the scan would walk to
+// watcher.
+func (s *Scanner) watchTree(watcher *fsnotify.Watcher, root string, dir string) error {
+	device := s.rootDevice(root)
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if !s.include(root, path, info) || otherDevice(device, path, info) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// watchArrival handles a file or directory at path that was created or
+// modified, reporting it to onDupe if it duplicates a known file.
+func (s *Scanner) watchArrival(ctx context.Context, watcher *fsnotify.Watcher, index *watchIndex,
+	root string, reference bool, path string, onDupe func(DupeGroup, File)) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		index.remove(path)
+		return
+	}
+	if info.IsDir() {
+		if err := s.watchTree(watcher, root, path); err != nil {
+			s.skip(path, "watch", err)
+		}
+		// Files created along with the directory raise no events of
+		// their own.
+		filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				s.watchArrival(ctx, watcher, index, root, reference, p, onDupe)
+			}
+			return nil
+		})
+		return
+	}
+	if !info.Mode().IsRegular() || !s.include(root, path, info) {
+		return
+	}
+
+	e := &watchEntry{file: File{Path: path, Root: root, Size: info.Size(), ModTime: info.ModTime(), Reference: reference}}
+	e.dev, e.ino = fileID(path, info)
+	index.add(e)
+	same := index.bySize[e.file.Size]
+	if len(same) < 2 {
+		return
+	}
+
+	if e.hash, err = s.hashFile(ctx, path); err != nil {
+		s.skip(path, "read", err)
+		index.remove(path)
+		return
+	}
+	group := DupeGroup{Hash: e.hash}
+	for _, other := range same {
+		// A hard link to a known file is not a duplicate of it.
+		if other != e && e.ino != 0 && other.dev == e.dev && other.ino == e.ino {
+			return
+		}
+		// Files acted on by onDupe may be gone without an event yet.
+		if _, err := os.Lstat(other.file.Path); err != nil {
+			index.remove(other.file.Path)
+			continue
+		}
+		if other.hash == "" {
+			if other.hash, err = s.hashFile(ctx, other.file.Path); err != nil {
+				s.skip(other.file.Path, "read", err)
+				continue
+			}
+		}
+		if other.hash == e.hash {
+			group.Files = append(group.Files, other.file)
+		}
+	}
+	if len(group.Files) < 2 {
+		return
+	}
+	if len(s.References) > 0 && len(referenced([]DupeGroup{group})) == 0 {
+		return
+	}
+	group.ID = groupID(group.Hash, group.Files)
+	onDupe(group, e.file)
+}
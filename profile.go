@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// profile is a bundle of settings for a common kind of scan, chosen with
+// --profile. Each setting is applied as one in the config file is, so
+// options given on the command line override single values and add to
+// repeatable ones.
+type profile struct {
+	name     string
+	settings []profileSetting
+}
+
+// profileSetting sets the flag called name to value.
+type profileSetting struct {
+	name  string
+	value string
+}
+
+var profiles = []profile{
+	{"photos", []profileSetting{
+		{"include-ext", "jpg,jpeg,png,gif,heic,heif,webp,tif,tiff,bmp,dng,cr2,cr3,nef,arw,orf,rw2,raf"},
+		// Thumbnails made by file managers and NAS indexers.
+		{"exclude", ".thumbnails"},
+		{"exclude", "@eaDir"},
+		{"min-size", "16K"},
+		{"images", "true"},
+		{"similar-names", "true"},
+		{"keep", "oldest"},
+	}},
+	{"code", []profileSetting{
+		{"respect-gitignore", "true"},
+		{"exclude", "node_modules"},
+		{"exclude", "__pycache__"},
+		{"exclude", ".venv"},
+		{"exclude", ".tox"},
+		// Source files are small, so near-copies are found in small chunks.
+		{"near", "true"},
+		{"near-chunk", "1K"},
+		{"keep", "shortest-path"},
+	}},
+	{"media", []profileSetting{
+		{"include-ext", "mp4,m4v,mkv,mov,avi,wmv,webm,mpg,mpeg,m2ts,mp3,flac,wav,m4a,aac,ogg,opus,wma"},
+		{"min-size", "1M"},
+		{"audio", "true"},
+		// Media files often share their first bytes, so more is sampled.
+		{"partial-hash", "1M"},
+		{"chunk-over", "1G"},
+		{"read-buffer", "1M"},
+	}},
+	{"backups", []profileSetting{
+		{"archives", "true"},
+		{"dirs", "true"},
+		{"similar-names", "true"},
+		{"one-file-system", "true"},
+		{"verify", "true"},
+		{"keep", "newest"},
+	}},
+}
+
+// profileNames returns the names of the profiles, in order.
+func profileNames() []string {
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = p.name
+	}
+	return names
+}
+
+// findProfile returns the profile called name.
+func findProfile(name string) (profile, error) {
+	for _, p := range profiles {
+		if p.name == name {
+			return p, nil
+		}
+	}
+	names := profileNames()
+	return profile{}, fmt.Errorf("unknown profile %q; use %s or %s", name, strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
+
+// profileUsage describes the profiles for the usage of --profile, one per
+// line, with their settings as options.
+func profileUsage() string {
+	var lines []string
+	for _, p := range profiles {
+		var opts []string
+		for _, s := range p.settings {
+			if s.value == "true" {
+				opts = append(opts, "--"+s.name)
+			} else {
+				opts = append(opts, "--"+s.name+" "+s.value)
+			}
+		}
+		lines = append(lines, p.name+": "+strings.Join(opts, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// applyProfile applies the settings of the profile called name to the
+// flags. Settings for options the command does not take, such as --keep
+// for dupes scan, are left out.
+func (fs *flagSet) applyProfile(name string) error {
+	p, err := findProfile(name)
+	if err != nil {
+		return err
+	}
+	for _, s := range p.settings {
+		if fs.Lookup(s.name) == nil {
+			continue
+		}
+		if err := fs.Set(s.name, s.value); err != nil {
+			return fmt.Errorf("profile %s: invalid value %s for %s: %v", p.name, s.value, s.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// progressDisplay reports scan progress. On a terminal a single status line
+// is redrawn several times a second; otherwise a line is printed every few
+// seconds so logs stay readable.
+type progressDisplay struct {
+	tty       bool
+	interval  time.Duration
+	last      time.Time
+	hashStart time.Time
+	lineLen   int
+}
+
+func newProgressDisplay() *progressDisplay {
+	p := &progressDisplay{interval: 5 * time.Second}
+	if isTerminal(os.Stdout) {
+		p.tty = true
+		p.interval = 200 * time.Millisecond
+	}
+	p.last = time.Now()
+	return p
+}
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// update is used as the scanner's Progress callback.
+func (p *progressDisplay) update(stats dupes.Stats) {
+	now := time.Now()
+	if stats.Candidates > 0 && p.hashStart.IsZero() {
+		p.hashStart = now
+	}
+	if now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+
+	var line string
+	if stats.Candidates == 0 {
+		line = fmt.Sprintf("Walking: %d files found (%s)", stats.Files, formatBytes(stats.Bytes))
+	} else {
+		percent := 100.0
+		if stats.CandidateBytes > 0 {
+			percent = 100 * float64(stats.HashedBytes) / float64(stats.CandidateBytes)
+		}
+		line = fmt.Sprintf("Hashing: %d/%d files, %s/%s (%.1f%%)", stats.Hashed, stats.Candidates,
+			formatBytes(stats.HashedBytes), formatBytes(stats.CandidateBytes), percent)
+
+		elapsed := now.Sub(p.hashStart).Seconds()
+		if elapsed > 0 && stats.HashedBytes > 0 {
+			bytesPerSec := float64(stats.HashedBytes) / elapsed
+			filesPerSec := float64(stats.Hashed) / elapsed
+			eta := time.Duration(float64(stats.CandidateBytes-stats.HashedBytes) / bytesPerSec * float64(time.Second))
+			line += fmt.Sprintf(", %s/s, %.0f files/s, ETA %s",
+				formatBytes(int64(bytesPerSec)), filesPerSec, eta.Round(time.Second))
+		}
+	}
+
+	if !p.tty {
+		fmt.Println(line)
+		return
+	}
+	pad := ""
+	if len(line) < p.lineLen {
+		pad = strings.Repeat(" ", p.lineLen-len(line))
+	}
+	fmt.Print("\r" + line + pad)
+	p.lineLen = len(line)
+}
+
+// finish clears the status line so the report starts on a clean line.
+func (p *progressDisplay) finish() {
+	if p.tty && p.lineLen > 0 {
+		fmt.Print("\r" + strings.Repeat(" ", p.lineLen) + "\r")
+		p.lineLen = 0
+	}
+}
+
+// formatBytes formats a byte count using binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatSize formats n with formatBytes if --human was given, and as a
+// number of bytes otherwise.
+func formatSize(n int64) string {
+	if human {
+		return formatBytes(n)
+	}
+	return fmt.Sprintf(tr("%d bytes"), n)
+}
@@ -0,0 +1,705 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"gopkg.in/gookit/color.v1"
+)
+
+// scan is a run set up from its options by buildScanner: the scanner, what
+// its results are checked against and where they are reported.
+type scan struct {
+	*options
+	scanner  dupes.Scanner
+	errs     scanErrors
+	accepted acceptList
+	manifest []manifestEntry
+	fileList []string
+	// highwayKeyKind is "random" or "custom" if --random-seed or --hh-key
+	// chose the HighwayHash key, and chunking is set by --chunk-over.
+	highwayKeyKind string
+	chunking       *hashChunking
+	// reportOut is stdout if the report is written there, in which case
+	// os.Stdout is stderr. stream writes --format ndjson output, to output
+	// unless it is stdout.
+	reportOut io.Writer
+	stream    *ndjsonStream
+	output    *os.File
+	progress  *progressDisplay
+	// metrics holds the metrics served with --watch --metrics, and
+	// metricsStats the stats they last counted.
+	metrics      *metrics
+	metricsStats dupes.Stats
+	start        time.Time
+	// status is the exit status, raised as each outcome is known.
+	status int
+}
+
+// results is what a scan found, as reported.
+type results struct {
+	report
+	// found holds every duplicate group not accepted, of which Dupes holds
+	// those reported.
+	found []dupe
+	stats dupes.Stats
+}
+
+// opError is an error doing what op describes, as in "opening cache", that
+// stops the run. It is logged as logError logs it.
+type opError struct {
+	op  string
+	err error
+}
+
+func (e *opError) Error() string { return e.op + ": " + e.err.Error() }
+
+// raise raises the exit status to status, unless it is already higher.
+func (s *scan) raise(status int) {
+	if status > s.status {
+		s.status = status
+	}
+}
+
+// close closes the --format ndjson output file and the audit log, and
+// returns the first error the audit log met.
+func (s *scan) close() error {
+	if s.output != nil {
+		s.output.Close()
+	}
+	if audit != nil {
+		return audit.close()
+	}
+	return nil
+}
+
+// buildScanner sets up the scan o asks for: it reads the HighwayHash key,
+// the manifest, the accept list and the file list, opens the cache,
+// checkpoint, output and audit log, and configures the scanner.
+func buildScanner(o *options) (_ *scan, err error) {
+	s := &scan{options: o}
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+	if o.sqliteFile != "" && errNoSQLite != nil {
+		// Fail before the scan rather than after it.
+		return nil, &opError{"writing SQLite database", errNoSQLite}
+	}
+	var highwayKey []byte
+	if o.randomSeed {
+		highwayKey = make([]byte, dupes.HH_KEY_SIZE)
+		if _, err := rand.Read(highwayKey); err != nil {
+			return nil, &opError{"generating HighwayHash key", err}
+		}
+		s.highwayKeyKind = "random"
+	} else if o.hhKey != "" && strings.Contains("+"+o.hashAlgorithm+"+", "+highway+") {
+		var err error
+		if o.hhKey == "-" {
+			if o.hhKey, err = readStdinLine(); err != nil {
+				return nil, &opError{"reading HighwayHash key", err}
+			}
+		}
+		if highwayKey, err = dupes.ParseHighwayKey(o.hhKey); err != nil {
+			return nil, usageError(tr("Error:") + " " + err.Error())
+		}
+		s.highwayKeyKind = "custom"
+	}
+	if o.manifestFile != "" {
+		var err error
+		if s.manifest, err = readManifest(o.manifestFile); err != nil {
+			return nil, &opError{"reading manifest", err}
+		}
+		if !o.hashGiven && len(s.manifest) > 0 {
+			algorithm, ok := manifestHashes[len(s.manifest[0].sum)]
+			if !ok {
+				return nil, errors.New(tr("Error: Cannot tell which hash made the manifest; give it with --hash"))
+			}
+			o.hashAlgorithm = algorithm
+		} else if !o.hashGiven {
+			o.hashAlgorithm = "sha256"
+		}
+		if strings.Contains(o.hashAlgorithm, "+") {
+			return nil, usageError(tr("Error: --manifest requires a --hash of a single algorithm"))
+		}
+		// As with dupes manifest, empty files are checked too.
+		o.includeEmpty = true
+	}
+	if o.acceptListFile != "" {
+		var err error
+		if s.accepted, err = loadAcceptList(o.acceptListFile); err != nil {
+			return nil, &opError{"reading accept list", err}
+		}
+	}
+
+	// Output written to stdout must not be interleaved with anything else,
+	// so every other message is sent to stderr instead.
+	if o.cmd == "manifest" || o.cmd == "agent" || o.outputFormat != "" && (o.outputFile == "" || o.outputFile == "-") {
+		s.reportOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	switch o.colorMode {
+	case "always":
+		color.Enable = true
+	case "never":
+		color.Enable = false
+	default:
+		color.Enable = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+	}
+
+	// NDJSON groups are written while scanning rather than in the report.
+	if o.outputFormat == "ndjson" {
+		out := s.reportOut
+		if out == nil {
+			f, err := os.Create(o.outputFile)
+			if err != nil {
+				return nil, &opError{"writing output file, please check permissions and that the directory exists", err}
+			}
+			s.output = f
+			out = f
+		}
+		s.stream = &ndjsonStream{w: out, accepted: s.accepted, max: o.maxErrorsReported}
+	}
+
+	s.errs = scanErrors{max: o.maxErrorsReported, verbose: o.verbose}
+	s.scanner = dupes.Scanner{
+		Hash:             o.hashAlgorithm,
+		HighwayKey:       highwayKey,
+		Verify:           o.verify,
+		PartialHash:      o.partialHash,
+		Workers:          o.workers,
+		References:       o.references,
+		Exclude:          o.excludes,
+		MinSize:          o.minSize,
+		ModifiedAfter:    o.newerThan,
+		ModifiedBefore:   o.olderThan,
+		MaxSize:          o.maxSize,
+		MaxDepth:         o.maxDepth,
+		IncludeEmpty:     o.includeEmpty,
+		FindEmpty:        o.findEmpty,
+		SkipHidden:       o.skipHidden,
+		Owners:           o.owners,
+		SkipUnreadable:   o.skipUnreadable,
+		RespectGitignore: o.respectGitignore,
+		Archives:         o.archives,
+		IncludeExt:       o.includeExt,
+		ExcludeExt:       o.excludeExt,
+		MIME:             o.mimeTypes,
+		FollowSymlinks:   o.followSymlinks,
+		OneFileSystem:    o.oneFileSystem,
+		ReadBufferSize:   o.readBufferSize,
+		MaxMemory:        o.maxMemory,
+		Mmap:             o.mmap,
+		MaxFiles:         o.maxFiles,
+		MaxDuration:      o.maxDuration,
+		RetryLocked:      o.retryLocked,
+		MaxReadRate:      o.maxReadRate,
+		ChunkThreshold:   o.chunkOver,
+		ChunkSize:        o.chunkSize,
+		OnError:          s.errs.add,
+	}
+	scanner := &s.scanner
+	if o.verbose {
+		scanner.OnFiltered = func(path string, reason string) {
+			fmt.Printf(tr("Skipped %s: %s\n"), path, reason)
+		}
+	}
+	if o.cacheFile != "" {
+		cache, err := dupes.OpenCache(o.cacheFile)
+		if err != nil {
+			return nil, &opError{"opening cache", err}
+		}
+		scanner.Cache = cache
+	}
+	if o.checkpointFile != "" {
+		if o.resume {
+			checkpoint, err := dupes.ResumeCheckpoint(o.checkpointFile)
+			if err != nil {
+				return nil, &opError{"resuming checkpoint", err}
+			}
+			scanner.Checkpoint = checkpoint
+		} else {
+			scanner.Checkpoint = dupes.NewCheckpoint(o.checkpointFile)
+		}
+	}
+	if stream := s.stream; stream != nil {
+		scanner.OnGroup = stream.group
+		onError := scanner.OnError
+		scanner.OnError = func(path string, op string, err error) {
+			onError(path, op, err)
+			stream.fileError(path, op, err)
+		}
+	}
+	if o.first {
+		scanner.StopAt = func(g dupes.DupeGroup) bool {
+			found := toDupes([]dupes.DupeGroup{g})
+			if s.accepted != nil {
+				found, _ = s.accepted.filter(found)
+			}
+			return len(found) > 0
+		}
+	}
+	if o.filesFrom != "" {
+		list, err := readFileList(o.filesFrom)
+		if err != nil {
+			return nil, &opError{"reading file list", err}
+		}
+		s.fileList = list
+	}
+	if o.auditLogFile != "" {
+		log, err := openAuditLog(o.auditLogFile)
+		if err != nil {
+			return nil, &opError{"opening audit log", err}
+		}
+		audit = log
+	}
+	if o.showProgress {
+		s.progress = newProgressDisplay()
+		scanner.Progress = s.progress.update
+	}
+	s.start = time.Now()
+	logger.Info("scan started", "dirs", o.dirs)
+	if o.metricsListen != "" && o.watch {
+		s.metrics = newMetrics()
+		if err := serveMetrics(s.metrics, o.metricsListen); err != nil {
+			return nil, &opError{"serving metrics", err}
+		}
+		show := scanner.Progress
+		scanner.Progress = func(stats dupes.Stats) {
+			s.metricsStats = s.metrics.progress(s.metricsStats, stats)
+			if show != nil {
+				show(stats)
+			}
+		}
+		onError := scanner.OnError
+		scanner.OnError = func(path string, op string, err error) {
+			onError(path, op, err)
+			s.metrics.fileError()
+		}
+		s.metrics.scanStarted()
+	}
+	if o.maxMemory > 0 {
+		debug.SetMemoryLimit(o.maxMemory)
+	}
+	if o.idle {
+		if err := lowerPriority(); err != nil {
+			color.Yellow.Printf(tr("Warning: could not lower priority: %v\n"), err)
+		}
+	}
+	if o.chunkOver > 0 {
+		s.chunking = &hashChunking{Threshold: o.chunkOver, ChunkSize: o.chunkSize}
+		if o.chunkSize == 0 {
+			s.chunking.ChunkSize = dupes.DEFAULT_HASH_CHUNK
+		}
+	}
+	return s, nil
+}
+
+// server returns the server of dupes serve and dupes daemon, whose scans
+// are configured as s.
+func (s *scan) server(ctx context.Context) *server {
+	return &server{
+		ctx:       ctx,
+		base:      s.scanner,
+		roots:     s.dirs,
+		accepted:  s.accepted,
+		maxErrors: s.maxErrorsReported,
+		info:      report{HashAlgorithm: s.hashAlgorithm, HashChunking: s.chunking, HighwayKey: s.highwayKeyKind, Verified: s.verify},
+		scans:     make(map[string]*serverScan),
+		metrics:   newMetrics(),
+		notifier:  s.notify,
+	}
+}
+
+// runScan scans the directories, or compares the two of --compare, and
+// returns what was found once the cache, checkpoint, --sqlite database and
+// notifications are updated. Errors saving them raise the status without
+// stopping the run; only an error that stopped the scan is returned.
+func (s *scan) runScan(ctx context.Context) (*results, error) {
+	scanner := &s.scanner
+	var groups []dupes.DupeGroup
+	var cmp *comparison
+	var err error
+	if s.compare {
+		var c dupes.Comparison
+		c, err = scanner.Compare(ctx, s.dirs[0], s.dirs[1])
+		groups = c.Both
+		cmp = &comparison{A: s.dirs[0], B: s.dirs[1], OnlyInA: []dupeFile{}, OnlyInB: []dupeFile{}}
+		for _, f := range c.OnlyA {
+			cmp.OnlyInA = append(cmp.OnlyInA, toDupeFile(f))
+		}
+		for _, f := range c.OnlyB {
+			cmp.OnlyInB = append(cmp.OnlyInB, toDupeFile(f))
+		}
+	} else {
+		if s.filesFrom != "" {
+			groups, err = scanner.ScanFiles(ctx, s.fileList)
+		} else {
+			groups, err = scanner.Scan(ctx, s.dirs...)
+		}
+	}
+	if s.progress != nil {
+		s.progress.finish()
+	}
+	if scanner.Cache != nil {
+		if err := scanner.Cache.Save(); err != nil {
+			logError("saving cache", err)
+			s.raise(EXIT_ERROR)
+		}
+	}
+	interrupted := errors.Is(err, context.Canceled)
+	if err != nil && !interrupted {
+		return nil, &opError{"scanning", err}
+	}
+	stats := scanner.Stats()
+	if scanner.Checkpoint != nil {
+		// Keep the checkpoint only while there is work left to resume.
+		if interrupted || stats.Limit != nil {
+			if err := scanner.Checkpoint.Save(); err != nil {
+				logError("saving checkpoint", err)
+				s.raise(EXIT_ERROR)
+			} else {
+				fmt.Printf(tr("Progress saved; continue with --checkpoint %s --resume\n"), s.checkpointFile)
+			}
+		} else if err := scanner.Checkpoint.Remove(); err != nil {
+			logError("removing checkpoint", err)
+			s.raise(EXIT_ERROR)
+		}
+	}
+
+	if s.sqliteFile != "" {
+		if err := writeSQLite(s.sqliteFile, scanner, scanner.HashScheme(), groups, interrupted); err != nil {
+			logError("writing SQLite database", err)
+			s.raise(EXIT_ERROR)
+		}
+	}
+
+	r := &results{stats: stats}
+	r.Interrupted = interrupted
+	r.Comparison = cmp
+	r.found = toDupes(groups)
+	suppressed := 0
+	if s.accepted != nil {
+		r.found, suppressed = s.accepted.filter(r.found)
+	}
+	if s.sortOrder != "" {
+		sortDupes(r.found, s.sortOrder)
+	}
+	r.Dupes = r.found
+	if s.top > 0 && len(r.found) > s.top {
+		r.Dupes = r.found[:s.top]
+	}
+	r.Summary = newSummary(stats, r.found, suppressed)
+	scanState := SCAN_FINISHED
+	if interrupted {
+		scanState = SCAN_INTERRUPTED
+	}
+	logScanStopped(logger, s.dirs, scanState, s.start, r.Summary)
+	if s.metrics != nil {
+		s.metrics.progress(s.metricsStats, stats)
+		s.metrics.scanStopped(scanState, time.Since(s.start), r.Summary)
+	}
+	if s.notify != nil {
+		if err := s.notify.notify(newNotification(s.dirs, scanState, s.start, r.Summary, r.found)); err != nil {
+			logError("sending notification", err)
+			s.raise(EXIT_ERROR)
+		}
+	}
+	r.Collisions = toDupes(scanner.Collisions())
+	if s.byDir {
+		r.WasteByDir = wasteByDir(r.found, s.keeper)
+	}
+	if s.findEmpty {
+		for _, p := range scanner.EmptyFiles() {
+			r.EmptyFiles = append(r.EmptyFiles, reportedPath(p))
+		}
+		for _, p := range scanner.EmptyDirs() {
+			r.EmptyDirs = append(r.EmptyDirs, reportedPath(p))
+		}
+	}
+	if s.findDirs {
+		for _, g := range scanner.DuplicateDirs() {
+			r.DuplicateDirs = append(r.DuplicateDirs, dupeDir{
+				Digest:      g.Digest,
+				Files:       g.Files,
+				Size:        g.Size,
+				WastedBytes: g.WastedBytes(),
+				Dirs:        g.Dirs,
+			})
+		}
+	}
+	if !interrupted {
+		s.findSimilar(ctx, r)
+	}
+	return r, nil
+}
+
+// findSimilar adds the files found by --images, --audio, --near and
+// --similar-names to r.
+func (s *scan) findSimilar(ctx context.Context, r *results) {
+	scanner := &s.scanner
+	toSimilar := func(files []dupes.File, sim float64) similarFiles {
+		sf := similarFiles{Similarity: math.Round(sim*1000) / 10}
+		for _, f := range files {
+			sf.Files = append(sf.Files, toDupeFile(f))
+		}
+		return sf
+	}
+	if s.findImages {
+		minSimilarity := dupes.DEFAULT_IMAGE_SIMILARITY
+		if s.similarity > 0 {
+			minSimilarity = s.similarity
+		}
+		groups, err := scanner.SimilarImages(ctx, s.imageHash, minSimilarity)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logError("comparing images", err)
+			s.raise(EXIT_ERROR)
+		}
+		for _, g := range groups {
+			r.SimilarImages = append(r.SimilarImages, toSimilar(g.Files, g.Similarity))
+		}
+	}
+	if s.findAudio {
+		minSimilarity := dupes.DEFAULT_AUDIO_SIMILARITY
+		if s.similarity > 0 {
+			minSimilarity = s.similarity
+		}
+		groups, err := scanner.SimilarAudio(ctx, minSimilarity)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logError("comparing audio", err)
+			s.raise(EXIT_ERROR)
+		}
+		for _, g := range groups {
+			r.SimilarAudio = append(r.SimilarAudio, toSimilar(g.Files, g.Similarity))
+		}
+	}
+	if s.findNear {
+		minSimilarity := dupes.DEFAULT_CDC_SIMILARITY
+		if s.similarity > 0 {
+			minSimilarity = s.similarity
+		}
+		groups, err := scanner.SimilarContent(ctx, s.cdcChunk, minSimilarity)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logError("comparing file content", err)
+			s.raise(EXIT_ERROR)
+		}
+		for _, g := range groups {
+			r.SimilarContent = append(r.SimilarContent, toSimilar(g.Files, g.Similarity))
+		}
+	}
+	if s.findNames {
+		for _, g := range scanner.SimilarNames() {
+			n := similarNames{Name: g.Name, SizeRatio: math.Round(g.SizeRatio*1000) / 10}
+			for _, f := range g.Files {
+				n.Files = append(n.Files, toDupeFile(f))
+			}
+			r.SimilarNames = append(r.SimilarNames, n)
+		}
+	}
+}
+
+// report prints the text report of r, or writes it in --format, then the
+// errors and the summary, and raises the status to match.
+func (s *scan) report(r *results) {
+	dupeCount := r.Summary.DuplicateFiles
+	if s.reportOut == nil && quiet == 0 && r.Comparison != nil {
+		printComparison(r.Comparison, r.found)
+	} else if s.reportOut == nil && quiet == 0 {
+		if dupeCount > 0 {
+			color.Red.Printf(tr("%d Files with duplicates found:\n"), dupeCount)
+			printDupes(r.Dupes)
+			if len(r.Dupes) < len(r.found) {
+				fmt.Printf(tr("Showing the first %d of %d groups.\n"), len(r.Dupes), len(r.found))
+			}
+		} else {
+			color.Green.Println(tr("No duplicate files exist in the specified directories."))
+		}
+		if s.byDir {
+			printWasteByDir(r.WasteByDir)
+		}
+		if s.findDirs {
+			printDupeDirs(r.DuplicateDirs)
+		}
+		if s.findEmpty {
+			printEmpty(r.EmptyFiles, r.EmptyDirs)
+		}
+		if s.findImages {
+			printSimilar(r.SimilarImages, tr("images"))
+		}
+		if s.findAudio {
+			printSimilar(r.SimilarAudio, tr("recordings"))
+		}
+		if s.findNear {
+			printSimilar(r.SimilarContent, tr("files"))
+		}
+		if s.findNames {
+			printSimilarNames(r.SimilarNames)
+		}
+		for _, c := range r.Collisions {
+			color.Yellow.Printf(tr("Hash collision: %s matched files with differing content:\n"), c.Hash)
+			for _, f := range c.Files {
+				fmt.Println("\t" + f.Path)
+			}
+		}
+	}
+
+	if s.stream != nil {
+		s.stream.finish()
+		s.stream.summary(r.Summary, r.Interrupted)
+	}
+	if s.stream != nil && s.stream.err != nil {
+		logError("writing ndjson output", s.stream.err)
+		s.raise(EXIT_ERROR)
+	}
+	if s.outputFormat != "" && s.stream == nil {
+		r.HashAlgorithm = s.hashAlgorithm
+		r.HashChunking = s.chunking
+		r.HighwayKey = s.highwayKeyKind
+		r.Verified = s.verify
+		r.Errors = s.errs.entries
+		r.ErrorsTotal = s.errs.total
+		r.ErrorsTruncated = s.errs.truncated()
+		if err := writeReport(s.outputFormat, r.report, s.outputFile, s.reportOut); err != nil {
+			s.raise(EXIT_ERROR)
+		}
+	}
+	if dupeCount > 0 {
+		s.raise(EXIT_DUPES_FOUND)
+	}
+	if s.errs.total > 0 {
+		s.raise(EXIT_FILE_ERRORS)
+	}
+	if r.stats.Limit == dupes.ErrStopped && s.status < EXIT_ERROR {
+		s.status = EXIT_FIRST_DUPLICATE
+	}
+
+	if s.errs.total > 0 && quiet == 0 {
+		s.errs.print()
+	}
+
+	if quiet < 2 {
+		printSummary(r.Summary)
+	}
+	if s.verbose {
+		printTimes(r.stats)
+	}
+}
+
+// act updates the accept list and applies the action to the duplicates in
+// r, or lets the user review them with --interactive. Nothing is done after
+// an interrupted scan.
+func (s *scan) act(r *results) error {
+	if r.Interrupted {
+		if quiet < 2 {
+			color.Yellow.Println(tr("Partial results: scan interrupted."))
+			if len(s.actions) > 0 || s.writeAcceptList {
+				fmt.Println(tr("No files were changed and the accept list was not updated."))
+			}
+		}
+		s.raise(EXIT_INTERRUPTED)
+		return nil
+	}
+
+	if s.writeAcceptList && len(r.found) > 0 {
+		if err := appendAcceptList(s.acceptListFile, r.found); err != nil {
+			return &opError{"writing accept list", err}
+		}
+		if quiet == 0 {
+			fmt.Printf(tr("%d groups added to accept list %s\n"), len(r.found), s.acceptListFile)
+		}
+	}
+
+	dupeCount := r.Summary.DuplicateFiles
+	if s.interactive && dupeCount > 0 {
+		results, err := review(r.found, s.keeper, s.actions[0], s.force)
+		if err != nil {
+			return &opError{"reviewing duplicates", err}
+		}
+		if results.failed > 0 {
+			s.raise(EXIT_FILE_ERRORS)
+		}
+		s.actions = nil
+	}
+	actOn, actCount := r.found, dupeCount
+	if len(s.protect) > 0 && len(s.actions) > 0 {
+		actOn = skipProtected(actOn, s.keeper)
+	}
+	if s.reclaim > 0 && len(s.actions) > 0 && dupeCount > 0 {
+		var wasted int64
+		actOn, actCount, wasted = reclaimGroups(actOn, s.reclaim)
+		if quiet < 2 {
+			fmt.Printf(tr("Acting on the %d largest groups, %d duplicates wasting %s, to reclaim %s.\n"),
+				len(actOn), actCount, formatBytes(wasted), formatBytes(s.reclaim))
+			if wasted < s.reclaim {
+				color.Yellow.Println(tr("Warning: the duplicates found waste less than the space to reclaim."))
+			}
+		}
+	}
+	if len(s.keepMatch) > 0 || len(s.deleteMatch) > 0 || len(s.protect) > 0 {
+		actCount = actedOnCount(actOn, s.keeper)
+	}
+	for _, a := range s.actions {
+		if actCount == 0 {
+			break
+		}
+		if !s.force && !s.dryRun && s.scriptFile == "" && !confirm(fmt.Sprintf(a.prompt, actCount)) {
+			fmt.Printf(tr("No files were %s.\n"), a.noun)
+			continue
+		}
+		if s.scriptFile != "" {
+			results, err := writeScript(s.scriptFile, actOn, s.keeper, a)
+			if err != nil {
+				return &opError{"writing script", err}
+			}
+			if quiet < 2 {
+				printActionSummary(results, a.verb, a.noun)
+				fmt.Printf(tr("Wrote the commands to %s; no files were %s.\n"), s.scriptFile, a.noun)
+			}
+			continue
+		}
+		results := runAction(actOn, s.keeper, a.action, a.verb)
+		if quiet < 2 {
+			printActionSummary(results, a.verb, a.noun)
+		}
+		if results.failed > 0 {
+			s.raise(EXIT_FILE_ERRORS)
+		}
+	}
+	return nil
+}
+
+// watchDirs keeps watching the directories with --watch, reporting each
+// new duplicate and applying the action to it, until ctx is done.
+func (s *scan) watchDirs(ctx context.Context) error {
+	var a *cliAction
+	if len(s.actions) > 0 {
+		a = &s.actions[0]
+	}
+	fmt.Println(tr("Watching for new duplicates; press Ctrl-C to stop."))
+	if err := s.scanner.Watch(ctx, s.dirs, func(g dupes.DupeGroup, f dupes.File) {
+		if s.stream != nil {
+			s.stream.group(g)
+		}
+		watchDupe(g, f, a)
+		if s.metrics != nil {
+			s.metrics.duplicateFound()
+		}
+	}); err != nil {
+		return &opError{"watching", err}
+	}
+	if s.stream != nil {
+		s.stream.finish()
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is when dupes daemon runs its scans, given as a crontab line's
+// five time fields: minute, hour, day of the month, month and day of the
+// week. Each field is *, a number, a range such as 1-5, any of these with a
+// step such as */15, or a comma-separated list of them. As in cron, if both
+// days are restricted, a day matching either is enough.
+type schedule struct {
+	minute, hour, day, month, weekday []bool
+	anyDay, anyWeekday                bool
+}
+
+// scheduleMacros are the shorthands cron accepts for common schedules.
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseSchedule parses a schedule such as "0 3 * * *", every day at 3am,
+// or one of the macros such as @daily.
+func parseSchedule(s string) (*schedule, error) {
+	if macro, ok := scheduleMacros[strings.TrimSpace(s)]; ok {
+		s = macro
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, e.g. \"0 3 * * *\"", s)
+	}
+	sched := &schedule{
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+	var err error
+	for i, f := range []struct {
+		set      *[]bool
+		min, max int
+		name     string
+	}{
+		{&sched.minute, 0, 59, "minute"},
+		{&sched.hour, 0, 23, "hour"},
+		{&sched.day, 1, 31, "day of the month"},
+		{&sched.month, 1, 12, "month"},
+		// Sunday is both 0 and 7.
+		{&sched.weekday, 0, 7, "day of the week"},
+	} {
+		if *f.set, err = parseScheduleField(fields[i], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("invalid %s in schedule %q: %v", f.name, s, err)
+		}
+	}
+	sched.weekday[0] = sched.weekday[0] || sched.weekday[7]
+	return sched, nil
+}
+
+// parseScheduleField returns the values of a field between min and max
+// that the field matches, indexed by value.
+func parseScheduleField(field string, min int, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				// As in cron, 5/10 means 5, 15, 25 and so on.
+				hi = max
+			}
+			if lo < min || hi > max || lo > hi {
+				return nil, fmt.Errorf("%s is outside %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// errNeverRuns is returned by next for a schedule no date matches, such
+// as "0 0 31 2 *".
+var errNeverRuns = errors.New("the schedule never runs")
+
+// next returns the first minute after t that the schedule runs in, in t's
+// location.
+func (s *schedule) next(t time.Time) (time.Time, error) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every schedule that can run does so within about four years, once
+	// February 29th comes round.
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case !s.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+	}
+	return time.Time{}, errNeverRuns
+}
+
+// matchesDay reports whether the schedule runs on t's day.
+func (s *schedule) matchesDay(t time.Time) bool {
+	day, weekday := s.day[t.Day()], s.weekday[t.Weekday()]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
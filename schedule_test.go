@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, tc := range []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"0 3 * * *", "2024-01-01 10:00", "2024-01-02 03:00"},
+		// The minute t is in does not count, even if it matches.
+		{"0 3 * * *", "2024-01-02 03:00", "2024-01-03 03:00"},
+		{"*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"5/20 * * * *", "2024-01-01 10:00", "2024-01-01 10:05"},
+		{"0,30 9-17 * * *", "2024-01-01 17:45", "2024-01-02 09:00"},
+		{"@hourly", "2024-01-01 10:07", "2024-01-01 11:00"},
+		{"@daily", "2024-12-31 23:59", "2025-01-01 00:00"},
+		{"@weekly", "2024-01-01 10:00", "2024-01-07 00:00"},
+		{"@monthly", "2024-01-15 10:00", "2024-02-01 00:00"},
+		// Weekdays run Monday to Friday; 2024-01-06 is a Saturday.
+		{"0 9 * * 1-5", "2024-01-06 12:00", "2024-01-08 09:00"},
+		// Sunday is both 0 and 7.
+		{"0 0 * * 7", "2024-01-02 00:00", "2024-01-07 00:00"},
+		// With both days restricted, either is enough.
+		{"0 0 1 * 0", "2024-01-02 00:00", "2024-01-07 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+	} {
+		s, err := parseSchedule(tc.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tc.spec, err)
+			continue
+		}
+		got, err := s.next(at(tc.from))
+		if want := at(tc.want); err != nil || !got.Equal(want) {
+			t.Errorf("%q: next(%s) = %s, %v; want %s", tc.spec, tc.from, got.Format("2006-01-02 15:04"), err, tc.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"0 3 * *",
+		"0 3 * * * *",
+		"@sometimes",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+	} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded; want an error", spec)
+		}
+	}
+}
+
+func TestScheduleNeverRuns(t *testing.T) {
+	s, err := parseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.next(time.Now()); err != errNeverRuns {
+		t.Errorf("next = %v; want %v", err, errNeverRuns)
+	}
+}
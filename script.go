@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// scriptAction is the action given with --script: rather than changing any
+// files, it writes the shell commands that would carry out action to w.
+// Each duplicate is first checked as by --dry-run.
+type scriptAction struct {
+	action dupes.Action
+	w      *bufio.Writer
+	// kept is the path of the file kept by the group last written, so that
+	// each group is introduced by a comment.
+	kept string
+}
+
+// newScriptAction returns a scriptAction writing for action to w, after
+// the script's header.
+func newScriptAction(action dupes.Action, w io.Writer, what string) *scriptAction {
+	s := &scriptAction{action: action, w: bufio.NewWriter(w)}
+	fmt.Fprintln(s.w, "#!/bin/sh")
+	fmt.Fprintf(s.w, "# Written by dupes on %s to %s duplicate files.\n", time.Now().Format(time.RFC3339), what)
+	fmt.Fprintln(s.w, "# Review it before running it. Nothing is checked again when it runs,")
+	fmt.Fprintln(s.w, "# so files changed since then are not protected.")
+	return s
+}
+
+func (s *scriptAction) Apply(keep dupes.File, dupe dupes.File) error {
+	if err := (dupes.DryRun{Action: s.action}).Apply(keep, dupe); err != nil {
+		return err
+	}
+	var cmd string
+	switch a := s.action.(type) {
+	case dupes.DeleteAction:
+		cmd = "rm -f -- " + shellQuote(dupe.Path)
+	case dupes.HardlinkAction:
+		cmd = "ln -f -- " + shellQuote(keep.Path) + " " + shellQuote(dupe.Path)
+	case dupes.SymlinkAction:
+		target, err := a.Target(keep, dupe)
+		if err != nil {
+			return err
+		}
+		cmd = "ln -sf -- " + shellQuote(target) + " " + shellQuote(dupe.Path)
+	case dupes.MoveAction:
+		dest, err := a.Destination(dupe)
+		if err != nil {
+			return err
+		}
+		cmd = "mkdir -p -- " + shellQuote(filepath.Dir(dest)) + " && mv -n -- " + shellQuote(dupe.Path) + " " + shellQuote(dest)
+	default:
+		return fmt.Errorf("--script cannot write %T", s.action)
+	}
+	if keep.Path != s.kept {
+		s.kept = keep.Path
+		fmt.Fprintf(s.w, "\n# Keeping %s\n", strings.ReplaceAll(keep.Path, "\n", "\\n"))
+	}
+	fmt.Fprintln(s.w, cmd)
+	return nil
+}
+
+// flush writes out the script.
+func (s *scriptAction) flush() error {
+	return s.w.Flush()
+}
+
+// writeScript writes the script of the commands a would run on the
+// duplicates in found to a new executable file at path, printing each file
+// as --dry-run does.
+func writeScript(path string, found []dupe, keep dupes.Keeper, a cliAction) (actionResults, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return actionResults{}, err
+	}
+	defer f.Close()
+	script := newScriptAction(a.action, f, a.mark)
+	results := runAction(found, keep, script, a.verb)
+	if err := script.flush(); err != nil {
+		return results, err
+	}
+	return results, f.Close()
+}
+
+// shellQuote quotes s for a POSIX shell, so that it is passed on as a
+// single word whatever it contains.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
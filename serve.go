@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// Address dupes serve listens on by default. Only local clients can reach
+// it, since the API has no authentication of its own.
+const DEFAULT_LISTEN = "localhost:8080"
+
+// States of a scan started through the API. An interrupted scan was
+// cancelled, or the server stopped, before it finished; its report holds
+// the partial results.
+const (
+	SCAN_RUNNING     = "running"
+	SCAN_FINISHED    = "finished"
+	SCAN_INTERRUPTED = "interrupted"
+	SCAN_FAILED      = "failed"
+)
+
+// server runs the scans started through the REST API of dupes serve. Each
+// is configured as base, from the command line, but scans the directories
+// its request names. If roots is set, only directories inside them may be
+// scanned. mu guards scans and the state of each.
+type server struct {
+	ctx       context.Context
+	base      dupes.Scanner
+	roots     []string
+	accepted  acceptList
+	maxErrors int
+	// info holds the fields every report shares, describing the hash.
+	info     report
+	metrics  *metrics
+	notifier *notifier
+
+	mu    sync.Mutex
+	next  int
+	scans map[string]*serverScan
+	order []string
+}
+
+// serverScan is the state of a scan started through the API, as returned
+// by GET /scans/<id>.
+type serverScan struct {
+	ID       string       `json:"id"`
+	Dirs     []string     `json:"dirs"`
+	State    string       `json:"state"`
+	Started  time.Time    `json:"started"`
+	Finished *time.Time   `json:"finished,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Progress scanProgress `json:"progress"`
+
+	cancel context.CancelFunc
+	report *report
+}
+
+// scanProgress is how far a scan has got.
+type scanProgress struct {
+	FilesScanned   int64 `json:"files_scanned"`
+	BytesScanned   int64 `json:"bytes_scanned"`
+	Candidates     int64 `json:"candidates"`
+	CandidateBytes int64 `json:"candidate_bytes"`
+	Hashed         int64 `json:"hashed"`
+	HashedBytes    int64 `json:"hashed_bytes"`
+}
+
+func toScanProgress(stats dupes.Stats) scanProgress {
+	return scanProgress{
+		FilesScanned:   stats.Files,
+		BytesScanned:   stats.Bytes,
+		Candidates:     stats.Candidates,
+		CandidateBytes: stats.CandidateBytes,
+		Hashed:         stats.Hashed,
+		HashedBytes:    stats.HashedBytes,
+	}
+}
+
+// runServe serves the API on listen until ctx is done, and returns the
+// exit status.
+func runServe(s *server, listen string) int {
+	serve, err := s.listen(listen)
+	if err != nil {
+		logError("listening", err)
+		return EXIT_ERROR
+	}
+	if quiet < 2 {
+		fmt.Println(tr("Press Ctrl-C to stop."))
+	}
+	if err := serve(); err != nil {
+		logError("serving", err)
+		return EXIT_ERROR
+	}
+	return EXIT_NO_DUPES
+}
+
+// listen starts listening for the API on the address, and returns the
+// function serving it until ctx is done.
+func (s *server) listen(address string) (func() error, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	hs := &http.Server{Handler: s}
+	go func() {
+		<-s.ctx.Done()
+		hs.Shutdown(context.Background())
+	}()
+	if quiet < 2 {
+		fmt.Printf(tr("Serving the dupes API on http://%s.\n"), ln.Addr())
+	}
+	return func() error {
+		if err := hs.Serve(ln); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// ServeHTTP routes the API's requests:
+//
+//	POST   /scans             starts a scan of {"dirs": [...]}
+//	GET    /scans             lists the scans
+//	GET    /scans/<id>        returns a scan's state and progress
+//	GET    /scans/<id>/groups returns a finished scan's report, as --format json
+//	DELETE /scans/<id>        cancels a running scan, or forgets a finished one
+//	GET    /metrics           returns the metrics of every scan, for Prometheus
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		s.metrics.ServeHTTP(w, r)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "scans" || len(parts) > 3 || len(parts) == 3 && parts[2] != "groups" {
+		httpError(w, http.StatusNotFound, "no such endpoint")
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.list(w)
+		case http.MethodPost:
+			s.start(w, r)
+		default:
+			httpError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scan, ok := s.scans[parts[1]]
+	if !ok {
+		httpError(w, http.StatusNotFound, "no such scan")
+		return
+	}
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		if scan.report == nil {
+			httpError(w, http.StatusConflict, "the scan has not finished")
+			return
+		}
+		writeJSON(w, http.StatusOK, scan.report)
+	case r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, scan)
+	case r.Method == http.MethodDelete:
+		if scan.State == SCAN_RUNNING {
+			scan.cancel()
+			writeJSON(w, http.StatusAccepted, scan)
+			return
+		}
+		s.forget(scan.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// list writes every scan, oldest first.
+func (s *server) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scans := make([]*serverScan, 0, len(s.order))
+	for _, id := range s.order {
+		scans = append(scans, s.scans[id])
+	}
+	writeJSON(w, http.StatusOK, scans)
+}
+
+// start starts the scan requested by r. Without a body, or with no dirs,
+// the roots given on the command line are scanned.
+func (s *server) start(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Dirs []string `json:"dirs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		httpError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if len(req.Dirs) == 0 {
+		req.Dirs = s.roots
+	}
+	if len(req.Dirs) == 0 {
+		httpError(w, http.StatusBadRequest, "no directory specified to scan")
+		return
+	}
+	// Paths outside the roots are refused before they are looked at, so
+	// that the response does not tell whether they exist.
+	for _, dir := range req.Dirs {
+		if !s.allowed(dir) {
+			httpError(w, http.StatusForbidden, dir+" is outside the directories being served")
+			return
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			httpError(w, http.StatusBadRequest, dir+" is not a directory")
+			return
+		}
+	}
+
+	ctx, scan := s.begin(req.Dirs)
+	s.mu.Lock()
+	w.Header().Set("Location", "/scans/"+scan.ID)
+	writeJSON(w, http.StatusAccepted, scan)
+	s.mu.Unlock()
+	go s.run(ctx, scan)
+}
+
+// begin adds a scan of dirs, to be run by run with the context returned.
+func (s *server) begin(dirs []string) (context.Context, *serverScan) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	scan := &serverScan{
+		ID:      strconv.Itoa(s.next),
+		Dirs:    dirs,
+		State:   SCAN_RUNNING,
+		Started: time.Now().UTC(),
+		cancel:  cancel,
+	}
+	s.scans[scan.ID] = scan
+	s.order = append(s.order, scan.ID)
+	if quiet < 2 {
+		fmt.Printf(tr("Scan %s of %s started.\n"), scan.ID, strings.Join(scan.Dirs, ", "))
+	}
+	logger.Info("scan started", "scan", scan.ID, "dirs", scan.Dirs)
+	return ctx, scan
+}
+
+// run scans for scan and records the outcome.
+func (s *server) run(ctx context.Context, scan *serverScan) {
+	defer scan.cancel()
+	scanner := s.base
+	errs := scanErrors{max: s.maxErrors}
+	scanner.OnError = func(path string, op string, err error) {
+		errs.add(path, op, err)
+		s.metrics.fileError()
+	}
+	var last dupes.Stats
+	scanner.Progress = func(stats dupes.Stats) {
+		last = s.metrics.progress(last, stats)
+		s.mu.Lock()
+		scan.Progress = toScanProgress(stats)
+		s.mu.Unlock()
+	}
+	s.metrics.scanStarted()
+	groups, err := scanner.Scan(ctx, scan.Dirs...)
+	s.metrics.progress(last, scanner.Stats())
+	if scanner.Cache != nil {
+		if err := scanner.Cache.Save(); err != nil {
+			logError("saving cache", err)
+		}
+	}
+	interrupted := errors.Is(err, context.Canceled)
+
+	s.mu.Lock()
+	finished := time.Now().UTC()
+	scan.Finished = &finished
+	scan.Progress = toScanProgress(scanner.Stats())
+	switch {
+	case err != nil && !interrupted:
+		scan.State = SCAN_FAILED
+		scan.Error = err.Error()
+	case interrupted:
+		scan.State = SCAN_INTERRUPTED
+	default:
+		scan.State = SCAN_FINISHED
+	}
+	var sum summary
+	if scan.State != SCAN_FAILED {
+		r := s.report(&scanner, groups, &errs)
+		r.Interrupted = interrupted
+		scan.report = &r
+		sum = r.Summary
+	}
+	r := scan.report
+	s.metrics.scanStopped(scan.State, finished.Sub(scan.Started), sum)
+	if scan.State == SCAN_FAILED {
+		logger.Error("scan "+scan.State, "scan", scan.ID, "dirs", scan.Dirs, "error", scan.Error)
+	} else {
+		logScanStopped(logger.With("scan", scan.ID), scan.Dirs, scan.State, scan.Started, sum)
+	}
+	if quiet < 2 {
+		fmt.Printf(tr("Scan %s %s.\n"), scan.ID, tr(scan.State))
+	}
+	state := scan.State
+	s.mu.Unlock()
+
+	if r != nil {
+		if err := s.notifier.notify(newNotification(scan.Dirs, state, scan.Started, sum, r.Dupes)); err != nil {
+			logError("sending notification", err)
+		}
+	}
+}
+
+// report returns the report of a scan by scanner that found groups.
+func (s *server) report(scanner *dupes.Scanner, groups []dupes.DupeGroup, errs *scanErrors) report {
+	found := toDupes(groups)
+	suppressed := 0
+	if s.accepted != nil {
+		found, suppressed = s.accepted.filter(found)
+	}
+	r := s.info
+	r.Summary = newSummary(scanner.Stats(), found, suppressed)
+	r.Dupes = found
+	r.Collisions = toDupes(scanner.Collisions())
+	if scanner.FindEmpty {
+		for _, p := range scanner.EmptyFiles() {
+			r.EmptyFiles = append(r.EmptyFiles, reportedPath(p))
+		}
+		for _, p := range scanner.EmptyDirs() {
+			r.EmptyDirs = append(r.EmptyDirs, reportedPath(p))
+		}
+	}
+	r.Errors = errs.entries
+	r.ErrorsTotal = errs.total
+	r.ErrorsTruncated = errs.truncated()
+	if r.Dupes == nil {
+		r.Dupes = []dupe{}
+	}
+	if r.Errors == nil {
+		r.Errors = []scanError{}
+	}
+	return r
+}
+
+// forget removes the finished scan id and its report.
+func (s *server) forget(id string) {
+	delete(s.scans, id)
+	for i, other := range s.order {
+		if other == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// allowed reports whether dir may be scanned: whether it is inside one of
+// the roots, after resolving symbolic links, if any were given.
+func (s *server) allowed(dir string) bool {
+	if len(s.roots) == 0 {
+		return true
+	}
+	dir = canonicalDir(dir)
+	for _, root := range s.roots {
+		rel, err := filepath.Rel(canonicalDir(root), dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalDir returns the absolute path of dir with symbolic links
+// resolved. If dir does not exist, the links in the part of it that does
+// are resolved, so that a path through a link is judged by where the link
+// leads whether or not the rest of it exists.
+func canonicalDir(dir string) string {
+	dir = absPath(dir)
+	for rest := ""; ; {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return filepath.Join(dir, rest)
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// writeJSON writes v as the JSON body of a response with the status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// httpError writes an error response with the message as {"error": ...}.
+func httpError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
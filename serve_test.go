@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerStartOutsideRoots(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "shares")
+	outside := filepath.Join(dir, "private")
+	for _, d := range []string{root, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skip("symbolic links are not supported here:", err)
+	}
+	s := &server{roots: []string{root}}
+
+	// Paths outside the roots, directly or through a link, are refused the
+	// same way whether they exist or not.
+	for _, tc := range []struct {
+		dir    string
+		status int
+	}{
+		{outside, http.StatusForbidden},
+		{filepath.Join(dir, "missing"), http.StatusForbidden},
+		{filepath.Join(root, "link"), http.StatusForbidden},
+		{filepath.Join(root, "link", "missing"), http.StatusForbidden},
+		{filepath.Join(root, "missing"), http.StatusBadRequest},
+	} {
+		body, _ := json.Marshal(map[string][]string{"dirs": {tc.dir}})
+		w := httptest.NewRecorder()
+		s.start(w, httptest.NewRequest("POST", "/scans", bytes.NewReader(body)))
+		if w.Code != tc.status {
+			t.Errorf("POST /scans %s: status %d; want %d (%s)", tc.dir, w.Code, tc.status, w.Body)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/gookit/color.v1"
+)
+
+// snapshot is a scan read back from a database written by dupes snapshot
+// or --sqlite.
+type snapshot struct {
+	path          string
+	hashAlgorithm string
+	scannedAt     string
+	interrupted   bool
+	groups        map[string]*snapshotGroup
+}
+
+// snapshotGroup is a duplicate group of a snapshot.
+type snapshotGroup struct {
+	id          string
+	size        int64
+	fileCount   int
+	wastedBytes int64
+	paths       []string
+}
+
+// wastedBytes returns the space wasted by all the snapshot's groups.
+func (s *snapshot) wastedBytes() int64 {
+	var total int64
+	for _, g := range s.groups {
+		total += g.wastedBytes
+	}
+	return total
+}
+
+// readSnapshot reads the snapshot database at path.
+func readSnapshot(path string) (*snapshot, error) {
+	// Opening a database that does not exist would create it.
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	s := &snapshot{path: path, groups: make(map[string]*snapshotGroup)}
+	if err := db.QueryRow("SELECT hash_algorithm, scanned_at, interrupted FROM scan").
+		Scan(&s.hashAlgorithm, &s.scannedAt, &s.interrupted); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	rows, err := db.Query("SELECT group_id, size, file_count, wasted_bytes FROM groups")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		g := &snapshotGroup{}
+		if err := rows.Scan(&g.id, &g.size, &g.fileCount, &g.wastedBytes); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		s.groups[g.id] = g
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	files, err := db.Query("SELECT group_id, path FROM files WHERE group_id IS NOT NULL ORDER BY path")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defer files.Close()
+	for files.Next() {
+		var id, p string
+		if err := files.Scan(&id, &p); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if g, ok := s.groups[id]; ok {
+			g.paths = append(g.paths, p)
+		}
+	}
+	if err := files.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// runDiff runs the diff command, comparing the duplicates of two
+// snapshots, and returns the exit status: EXIT_DUPES_FOUND if groups
+// appeared since the older one.
+func runDiff(args []string) int {
+	if len(args) != 2 {
+		fmt.Println(tr("Error: dupes diff takes two snapshots, e.g. dupes diff scan1.db scan2.db"))
+		printUsage("diff")
+		return EXIT_USAGE
+	}
+	before, err := readSnapshot(args[0])
+	if err != nil {
+		logError("reading snapshot", err)
+		return EXIT_ERROR
+	}
+	after, err := readSnapshot(args[1])
+	if err != nil {
+		logError("reading snapshot", err)
+		return EXIT_ERROR
+	}
+	// Group IDs are derived from the hash, so they only match between
+	// snapshots hashed alike.
+	if before.hashAlgorithm != after.hashAlgorithm {
+		fmt.Printf(tr("Error: %s was hashed with %s but %s with %s, so their groups cannot be compared\n"),
+			before.path, before.hashAlgorithm, after.path, after.hashAlgorithm)
+		return EXIT_ERROR
+	}
+
+	var added, resolved, changed []string
+	for id, g := range after.groups {
+		if old, ok := before.groups[id]; !ok {
+			added = append(added, id)
+		} else if old.fileCount != g.fileCount {
+			changed = append(changed, id)
+		}
+	}
+	for id := range before.groups {
+		if _, ok := after.groups[id]; !ok {
+			resolved = append(resolved, id)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(resolved)
+	sort.Strings(changed)
+
+	fmt.Printf(tr("Comparing %s (scanned %s) with %s (scanned %s)\n"), before.path, before.scannedAt, after.path, after.scannedAt)
+	for _, s := range []*snapshot{before, after} {
+		if s.interrupted {
+			color.Yellow.Printf(tr("%s holds the partial results of an interrupted scan.\n"), s.path)
+		}
+	}
+	if quiet == 0 {
+		printGroups := func(what string, s *snapshot, ids []string) {
+			if len(ids) == 0 {
+				return
+			}
+			color.Red.Printf(tr("%s duplicate groups (%d):\n"), what, len(ids))
+			for _, id := range ids {
+				g := s.groups[id]
+				fmt.Printf(tr("Group: %s (%d files of %s each, %s reclaimable)\n"), id, g.fileCount, formatSize(g.size), formatSize(g.wastedBytes))
+				for _, p := range g.paths {
+					fmt.Println("\t" + p)
+				}
+			}
+		}
+		printGroups(tr("New"), after, added)
+		printGroups(tr("Resolved"), before, resolved)
+		if len(changed) > 0 {
+			color.Red.Printf(tr("Changed duplicate groups (%d):\n"), len(changed))
+			for _, id := range changed {
+				fmt.Printf(tr("Group: %s (%d files, now %d)\n"), id, before.groups[id].fileCount, after.groups[id].fileCount)
+			}
+		}
+	}
+	if quiet < 2 {
+		fmt.Printf(tr("Duplicate groups: %d -> %d (%d new, %d resolved, %d changed)\n"),
+			len(before.groups), len(after.groups), len(added), len(resolved), len(changed))
+		wasted, was := after.wastedBytes(), before.wastedBytes()
+		sign := "+"
+		delta := wasted - was
+		if delta < 0 {
+			sign, delta = "-", -delta
+		}
+		fmt.Printf(tr("Reclaimable space: %s -> %s (%s%s)\n"), formatSize(was), formatSize(wasted), sign, formatSize(delta))
+	}
+	if len(added) > 0 {
+		return EXIT_DUPES_FOUND
+	}
+	return EXIT_NO_DUPES
+}
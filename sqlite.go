@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+)
+
+// sqliteSchema creates the tables written by --sqlite. files holds every
+// file scanned, with its hash if it was hashed and its group if it is a
+// duplicate; a path that is a link to another file names it in link_of and
+// shares its hash, but is not counted as a member of its group.
+const sqliteSchema = `
+CREATE TABLE scan (
+	hash_algorithm TEXT NOT NULL,
+	scanned_at TEXT NOT NULL,
+	interrupted INTEGER NOT NULL
+);
+CREATE TABLE groups (
+	group_id TEXT PRIMARY KEY,
+	hash TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	file_count INTEGER NOT NULL,
+	wasted_bytes INTEGER NOT NULL
+);
+CREATE TABLE files (
+	path TEXT PRIMARY KEY,
+	root TEXT NOT NULL,
+	dir TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	mtime TEXT NOT NULL,
+	hash TEXT,
+	group_id TEXT REFERENCES groups(group_id),
+	reference INTEGER NOT NULL,
+	archive TEXT,
+	link_of TEXT REFERENCES files(path)
+);
+CREATE INDEX files_group_id ON files(group_id);
+CREATE INDEX files_dir ON files(dir);
+`
+
+// openSQLite opens the SQLite database at path.
+func openSQLite(path string) (*sql.DB, error) {
+	if errNoSQLite != nil {
+		return nil, errNoSQLite
+	}
+	return sql.Open("sqlite3", path)
+}
+
+// writeSQLite writes the files and groups of the scan to a new SQLite
+// database at path, replacing any existing file.
+func writeSQLite(path string, scanner *dupes.Scanner, hashAlgorithm string, groups []dupes.DupeGroup, interrupted bool) error {
+	if errNoSQLite != nil {
+		return errNoSQLite
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db, err := openSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO scan VALUES (?, ?, ?)",
+		hashAlgorithm, time.Now().UTC().Format(time.RFC3339), interrupted); err != nil {
+		return err
+	}
+
+	insertGroup, err := tx.Prepare("INSERT INTO groups VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insertGroup.Close()
+	groupOf := make(map[string]string)
+	for _, g := range groups {
+		if _, err := insertGroup.Exec(g.ID, g.Hash, g.Size(), len(g.Files), g.WastedBytes()); err != nil {
+			return err
+		}
+		for _, f := range g.Files {
+			groupOf[f.Path] = g.ID
+		}
+	}
+
+	insertFile, err := tx.Prepare("INSERT INTO files VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insertFile.Close()
+	insert := func(path string, f dupes.File, linkOf interface{}) error {
+		var hash, group, archive interface{}
+		if h, ok := scanner.FileHash(path); ok {
+			hash = h
+		}
+		if id, ok := groupOf[path]; ok {
+			group = id
+		}
+		if f.Archive != "" {
+			archive = f.Archive
+		}
+		_, err := insertFile.Exec(path, f.Root, filepath.Dir(path), f.Size,
+			f.ModTime.UTC().Format(time.RFC3339Nano), hash, group, f.Reference, archive, linkOf)
+		return err
+	}
+	for _, f := range scanner.Files() {
+		if err := insert(f.Path, f, nil); err != nil {
+			return err
+		}
+		for _, link := range f.Links {
+			if err := insert(link, f, f.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,10 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// go-sqlite3 compiles SQLite from C, so it is only built with cgo.
+import _ "github.com/mattn/go-sqlite3"
+
+// errNoSQLite is nil, as this build can read and write SQLite databases.
+var errNoSQLite error
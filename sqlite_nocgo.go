@@ -0,0 +1,10 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+import "errors"
+
+// errNoSQLite is returned for --sqlite, dupes snapshot and dupes diff by a
+// build without cgo, which go-sqlite3 needs.
+var errNoSQLite = errors.New("this build of dupes has no SQLite support; rebuild it with CGO_ENABLED=1 and a C compiler")
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"gopkg.in/gookit/color.v1"
+)
+
+// undoStep is what undoing a record of the audit log takes: moving the file
+// back from where it was moved to, or copying the kept file into its place.
+// A record that cannot be undone has skip set to the reason instead, and
+// one that needs no undoing, as a reflink does not, has unchanged set too.
+type undoStep struct {
+	move      bool
+	skip      string
+	unchanged bool
+}
+
+// readAuditLog reads the records of the audit log at path, in the order
+// they were written.
+func readAuditLog(path string) ([]auditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []auditRecord
+	lines := bufio.NewScanner(f)
+	lines.Buffer(nil, 1<<20)
+	for n := 1; lines.Scan(); n++ {
+		if len(lines.Bytes()) == 0 {
+			continue
+		}
+		var r auditRecord
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		records = append(records, r)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// planUndo works out how to undo r, checking that the files are still as
+// the action left them, so that nothing changed since is overwritten.
+func planUndo(r auditRecord) undoStep {
+	var step undoStep
+	sourceInfo, sourceErr := os.Lstat(r.Source)
+	// keptMatches reports whether the kept file can still stand in for the
+	// duplicate: whether it has the size and modification time it was
+	// scanned with, which is what can be checked without knowing how the
+	// scan hashed it. Logs written before the time was recorded are
+	// checked by size alone.
+	keptMatches := func() bool {
+		info, err := os.Stat(r.Kept)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != r.Size ||
+			!r.KeptModTime.IsZero() && !info.ModTime().Equal(r.KeptModTime) {
+			step.skip = fmt.Sprintf("the kept file %s is missing or has changed", r.Kept)
+			return false
+		}
+		return true
+	}
+	switch r.Action {
+	case "move":
+		step.move = true
+		if sourceErr == nil {
+			step.skip = "it exists again"
+		} else if info, err := os.Lstat(r.Target); err != nil || !info.Mode().IsRegular() || info.Size() != r.Size {
+			step.skip = fmt.Sprintf("%s is missing or has changed", r.Target)
+		}
+	case "delete", "trash":
+		if sourceErr == nil {
+			step.skip = "it exists again"
+		} else {
+			keptMatches()
+		}
+	case "hardlink":
+		if sourceErr != nil {
+			step.skip = "it no longer exists"
+		} else if keptInfo, err := os.Stat(r.Kept); err != nil || !os.SameFile(sourceInfo, keptInfo) {
+			step.skip = "it is no longer a hard link to " + r.Kept
+		} else {
+			keptMatches()
+		}
+	case "symlink":
+		if sourceErr != nil {
+			step.skip = "it no longer exists"
+		} else if target, err := os.Readlink(r.Source); err != nil || target != r.Target {
+			step.skip = "it is no longer a symbolic link to " + r.Target
+		} else {
+			keptMatches()
+		}
+	case "reflink":
+		step.skip = "it is still a file of its own"
+		step.unchanged = true
+	default:
+		step.skip = fmt.Sprintf("unknown action %q", r.Action)
+	}
+	return step
+}
+
+// runUndo runs the undo command, reversing the actions recorded in the
+// audit log given in args, most recent first, and returns the exit status.
+// With dryRun it only prints what it would do.
+func runUndo(args []string, dryRun bool, force bool) int {
+	if len(args) != 1 {
+		fmt.Println(tr("Error: dupes undo takes an audit log written by --audit-log, e.g. dupes undo cleanup.jsonl"))
+		printUsage("undo")
+		return EXIT_USAGE
+	}
+	records, err := readAuditLog(args[0])
+	if err != nil {
+		logError("reading audit log", err)
+		return EXIT_ERROR
+	}
+
+	// Files the action failed on or skipped were left as they were. Once
+	// a path is restored, earlier actions on it are not undone too.
+	var pending []auditRecord
+	count := 0
+	counted := make(map[string]bool)
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Error != "" {
+			continue
+		}
+		pending = append(pending, r)
+		if !counted[r.Source] && planUndo(r).skip == "" {
+			count++
+			counted[r.Source] = true
+		}
+	}
+	if count > 0 && !force && !dryRun && !confirm(fmt.Sprintf("Restore %d files?", count)) {
+		fmt.Println(tr("No files were restored."))
+		return EXIT_NO_DUPES
+	}
+
+	verb := tr("Restored")
+	if dryRun {
+		verb = tr("Would restore")
+	}
+	var results actionResults
+	unchanged := 0
+	restored := make(map[string]bool)
+	for _, r := range pending {
+		step := planUndo(r)
+		if restored[r.Source] {
+			step.skip = "it is restored from a later action"
+		}
+		if step.unchanged && !restored[r.Source] {
+			unchanged++
+			if quiet == 0 {
+				fmt.Printf(tr("%s needs no undoing: reflinked to %s, it is still a file of its own.\n"), r.Source, r.Kept)
+			}
+			continue
+		}
+		if step.skip != "" {
+			results.skipped++
+			if quiet == 0 {
+				color.Yellow.Printf(tr("Warning: skipped %s, %s\n"), r.Source, step.skip)
+			}
+			continue
+		}
+		if !dryRun {
+			if step.move {
+				err = dupes.RestoreMoved(r.Source, r.Target)
+			} else {
+				err = dupes.RestoreCopy(r.Source, r.Kept)
+			}
+			if err != nil {
+				results.failed++
+				color.Red.Printf(tr("Error: %v\n"), err)
+				continue
+			}
+		}
+		results.count++
+		restored[r.Source] = true
+		if quiet > 0 {
+			continue
+		}
+		color.Yellow.Printf("%s %s", verb, r.Source)
+		if step.move {
+			fmt.Printf(tr(" (moved back from %s)\n"), r.Target)
+		} else {
+			fmt.Printf(tr(" (copied from %s)\n"), r.Kept)
+		}
+	}
+	if quiet < 2 {
+		color.Green.Printf(tr("%s %d files.\n"), verb, results.count)
+		if unchanged > 0 {
+			fmt.Printf(tr("%d reflinked files need no undoing.\n"), unchanged)
+		}
+		if results.skipped > 0 {
+			color.Yellow.Printf(tr("%d files were skipped.\n"), results.skipped)
+		}
+		if results.failed > 0 {
+			color.Red.Printf(tr("%d files could not be restored.\n"), results.failed)
+		}
+	}
+	if results.failed > 0 {
+		return EXIT_FILE_ERRORS
+	}
+	return EXIT_NO_DUPES
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanUndo(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	write := func(name string, content string) {
+		if err := os.WriteFile(path(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("kept", "content")
+	write("moved", "content")
+	write("again", "content")
+	write("changed", "other content")
+	if err := os.Link(path("kept"), path("linked")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(path("kept"), path("symlinked")); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len("content"))
+	info, err := os.Stat(path("kept"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned := info.ModTime()
+
+	for _, tc := range []struct {
+		name     string
+		record   auditRecord
+		wantMove bool
+		wantSkip bool
+	}{
+		{"move", auditRecord{Action: "move", Source: path("gone"), Target: path("moved"), Size: size}, true, false},
+		{"move back over a file", auditRecord{Action: "move", Source: path("again"), Target: path("moved"), Size: size}, true, true},
+		{"move from a missing file", auditRecord{Action: "move", Source: path("gone"), Target: path("missing"), Size: size}, true, true},
+		{"move from a changed file", auditRecord{Action: "move", Source: path("gone"), Target: path("changed"), Size: size}, true, true},
+		{"delete", auditRecord{Action: "delete", Source: path("gone"), Kept: path("kept"), Size: size}, false, false},
+		{"trash", auditRecord{Action: "trash", Source: path("gone"), Kept: path("kept"), Size: size}, false, false},
+		{"delete over a file", auditRecord{Action: "delete", Source: path("again"), Kept: path("kept"), Size: size}, false, true},
+		{"delete with the kept file missing", auditRecord{Action: "delete", Source: path("gone"), Kept: path("missing"), Size: size}, false, true},
+		{"delete with the kept file changed", auditRecord{Action: "delete", Source: path("gone"), Kept: path("changed"), Size: size}, false, true},
+		{"delete with the kept file as scanned", auditRecord{Action: "delete", Source: path("gone"), Kept: path("kept"), KeptModTime: scanned, Size: size}, false, false},
+		{"delete with the kept file rewritten", auditRecord{Action: "delete", Source: path("gone"), Kept: path("kept"), KeptModTime: scanned.Add(-time.Hour), Size: size}, false, true},
+		{"hardlink with the kept file rewritten", auditRecord{Action: "hardlink", Source: path("linked"), Kept: path("kept"), KeptModTime: scanned.Add(-time.Hour), Size: size}, false, true},
+		{"hardlink", auditRecord{Action: "hardlink", Source: path("linked"), Kept: path("kept"), Size: size}, false, false},
+		{"hardlink no longer linked", auditRecord{Action: "hardlink", Source: path("again"), Kept: path("kept"), Size: size}, false, true},
+		{"hardlink removed", auditRecord{Action: "hardlink", Source: path("gone"), Kept: path("kept"), Size: size}, false, true},
+		{"symlink", auditRecord{Action: "symlink", Source: path("symlinked"), Target: path("kept"), Kept: path("kept"), Size: size}, false, false},
+		{"symlink replaced", auditRecord{Action: "symlink", Source: path("again"), Target: path("kept"), Kept: path("kept"), Size: size}, false, true},
+		{"symlink elsewhere", auditRecord{Action: "symlink", Source: path("symlinked"), Target: path("other"), Kept: path("kept"), Size: size}, false, true},
+		{"reflink", auditRecord{Action: "reflink", Source: path("again"), Kept: path("kept"), Size: size}, false, true},
+		{"unknown action", auditRecord{Action: "shred", Source: path("gone"), Kept: path("kept"), Size: size}, false, true},
+	} {
+		step := planUndo(tc.record)
+		if step.move != tc.wantMove || (step.skip != "") != tc.wantSkip {
+			t.Errorf("%s: planUndo = %+v; want move %v, skip %v", tc.name, step, tc.wantMove, tc.wantSkip)
+		}
+	}
+	if step := planUndo(auditRecord{Action: "reflink", Source: path("again"), Kept: path("kept"), Size: size}); !step.unchanged {
+		t.Errorf("reflink: planUndo = %+v; want it reported as needing no undoing", step)
+	}
+}
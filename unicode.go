@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeUnicode is set by --normalize-unicode to report paths in the
+// composed form, NFC, whichever form they are stored in. macOS used to
+// store names decomposed, as NFD, so copies of one file may have names
+// that look alike but differ byte for byte.
+var normalizeUnicode bool
+
+// reportedPath returns path as the report shows it.
+func reportedPath(path string) string {
+	if !normalizeUnicode {
+		return path
+	}
+	return norm.NFC.String(path)
+}
+
+// unicodeFormNote returns a note on the files of g named alike but in
+// different Unicode forms, or "" if there are none.
+func unicodeFormNote(g dupes.DupeGroup) string {
+	first := make(map[string]string)
+	var pairs []string
+	for _, f := range g.Files {
+		name := filepath.Base(f.Path)
+		key := norm.NFC.String(name)
+		other, ok := first[key]
+		if !ok {
+			first[key] = name
+			continue
+		}
+		if other != name {
+			pairs = append(pairs, fmt.Sprintf("%+q and %+q", other, name))
+		}
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "same name in different Unicode forms: " + strings.Join(pairs, ", ")
+}
+
+// addNote adds note to those already made on d.
+func addNote(d *dupe, note string) {
+	if d.Note == "" {
+		d.Note = note
+	} else {
+		d.Note += "; " + note
+	}
+}
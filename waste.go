@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cwadley/dupes/pkg/dupes"
+	"gopkg.in/gookit/color.v1"
+)
+
+// dirWaste is the space the duplicates below one top-level directory
+// waste, as reported by --by-dir.
+type dirWaste struct {
+	Dir         string  `json:"dir"`
+	Files       int     `json:"files"`
+	WastedBytes int64   `json:"wasted_bytes"`
+	Share       float64 `json:"share"`
+}
+
+// topLevelDir returns the directory directly below f's root that f is in,
+// or the root itself for a file directly in it.
+func topLevelDir(f dupes.File) string {
+	rel, err := filepath.Rel(f.Root, f.Path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Dir(f.Path)
+	}
+	if i := strings.IndexRune(rel, filepath.Separator); i >= 0 {
+		return filepath.Join(f.Root, rel[:i])
+	}
+	return f.Root
+}
+
+// wasteByDir totals the space wasted by the duplicates in found under each
+// top-level directory of the roots, most first. The duplicates are the
+// files an action would remove, so the file keep keeps is not counted,
+// nor are reference files and archive members. Share is the percentage of
+// all that space that each directory holds.
+func wasteByDir(found []dupe, keep dupes.Keeper) []dirWaste {
+	groups := make([]dupes.DupeGroup, len(found))
+	for i, d := range found {
+		groups[i] = d.group
+	}
+	byDir := make(map[string]*dirWaste)
+	var total int64
+	dupes.Resolve(groups, keep, noAction{}, func(r dupes.ActionResult) {
+		if r.Link {
+			return
+		}
+		dir := reportedPath(topLevelDir(r.Dupe))
+		w, ok := byDir[dir]
+		if !ok {
+			w = &dirWaste{Dir: dir}
+			byDir[dir] = w
+		}
+		w.Files++
+		w.WastedBytes += r.Dupe.Size
+		total += r.Dupe.Size
+	})
+	dirs := make([]dirWaste, 0, len(byDir))
+	for _, w := range byDir {
+		if total > 0 {
+			w.Share = math.Round(float64(w.WastedBytes)*1000/float64(total)) / 10
+		}
+		dirs = append(dirs, *w)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].WastedBytes != dirs[j].WastedBytes {
+			return dirs[i].WastedBytes > dirs[j].WastedBytes
+		}
+		return dirs[i].Dir < dirs[j].Dir
+	})
+	return dirs
+}
+
+// printWasteByDir prints the space wasted under each top-level directory.
+func printWasteByDir(dirs []dirWaste) {
+	if len(dirs) == 0 {
+		return
+	}
+	color.Red.Println(tr("Reclaimable space by directory:"))
+	for _, d := range dirs {
+		fmt.Printf(tr("\t%5.1f%%  %s  %s (%d files)\n"), d.Share, formatSize(d.WastedBytes), d.Dir, d.Files)
+	}
+}